@@ -2,6 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"html/template"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -12,14 +16,36 @@ import (
 	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/cache"
 	"github.com/buharamanya/shortener/internal/app/config"
 	"github.com/buharamanya/shortener/internal/app/handlers"
 	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/ratelimit"
+	"github.com/buharamanya/shortener/internal/app/screening"
 	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/buharamanya/shortener/internal/app/stream"
+	"github.com/buharamanya/shortener/internal/app/urlcrypt"
+	"github.com/buharamanya/shortener/internal/app/urlnorm"
+	"github.com/buharamanya/shortener/internal/app/webhooks"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
+// сколько последних событий помнить для переподключения к потоку.
+const eventBufferSize = 1024
+
+// сколько живет запись в кэше хранилища, если в конфиге не задано.
+const defaultStorageCacheTTL = time.Minute
+
+// как часто сбрасывать кэш целиком на случай потерянных уведомлений от других реплик.
+const linksResyncInterval = 5 * time.Minute
+
+// как часто проверять реплики базы.
+const replicaCheckInterval = 5 * time.Second
+
+// как часто записывать накопленные переходы по ссылкам.
+const clickFlushInterval = time.Second
+
 var (
 	buildVersion = "N/A"
 	buildDate    = "N/A"
@@ -33,64 +59,323 @@ func main() {
 	logger.Log.Info("Build info: ", zap.String("date", buildDate))
 	logger.Log.Info("Build info: ", zap.String("commit", buildCommit))
 
-	var appConfig = config.InitConfiguration()
+	appConfig, launch, err := loadConfig()
+	if launch.PrintConfig {
+		appConfig.Print(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		logger.Log.Fatal("Некорректная конфигурация:", zap.Error(err))
+	}
+	if err := logger.SetLevel(appConfig.LogLevel); err != nil {
+		logger.Log.Fatal("Некорректный уровень логирования:", zap.Error(err))
+	}
+
+	if err := auth.Initialize(appConfig); err != nil {
+		logger.Log.Fatal("Ошибка настройки подписи токенов:", zap.Error(err))
+	}
+
+	urlKeys, err := urlcrypt.Load(appConfig.URLKeys, appConfig.URLKeysFile)
+	if err != nil {
+		logger.Log.Fatal("Ошибка загрузки ключей шифрования адресов:", zap.Error(err))
+	}
 
 	var repo storage.URLStorage
+	var db *storage.DBStorage
 
 	if appConfig.DataBaseDSN == "" {
 		file, err := os.OpenFile(appConfig.StorageFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 		if err != nil {
 			logger.Log.Fatal("Ошибка запуска файлового хранилища:", zap.Error(err))
 		}
-		repo = storage.NewInMemoryStorage(file)
+		repo = storage.NewInMemoryStorage(file, storage.WithFileKeys(urlKeys))
 	} else {
-		var err error
-		repo, err = storage.NewDBStorage(appConfig.DataBaseDSN)
+		pool, err := poolConfig(appConfig.DBPool)
+		if err != nil {
+			logger.Log.Fatal("Некорректные настройки пула соединений:", zap.Error(err))
+		}
+		db, err = storage.NewDBStorage(appConfig.DataBaseDSN,
+			storage.WithPool(pool),
+			storage.WithReplicas(appConfig.DataBaseReplicaDSNs...),
+			storage.WithURLKeys(urlKeys),
+		)
 		if err != nil {
 			logger.Log.Fatal("Ошибка подключения к базе данных:", zap.Error(err))
 		}
+		repo = db
 	}
 	defer repo.Close()
 
-	shortenHandler := handlers.NewShortenHandler(repo, appConfig.RedirectBaseURL)
+	// пинг проверяет само хранилище, а не кэш перед ним
+	pingHandler := handlers.PingHandler(repo)
+
+	if appConfig.StorageCacheSize > 0 {
+		cacheTTL := defaultStorageCacheTTL
+		if appConfig.StorageCacheTTL != "" {
+			var err error
+			cacheTTL, err = time.ParseDuration(appConfig.StorageCacheTTL)
+			if err != nil {
+				logger.Log.Fatal("Некорректный срок жизни кэша хранилища:", zap.Error(err))
+			}
+		}
+		cached := cache.New(repo, appConfig.StorageCacheSize, cacheTTL)
+		// попадания и промахи видны администратору в /debug/vars
+		expvar.Publish("storage_cache", expvar.Func(func() any { return cached.Stats() }))
+		repo = cached
+	}
+
+	// Создаем контекст для фоновых задач и graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if db != nil {
+		go db.WatchReplicas(ctx, replicaCheckInterval)
+	}
+
+	// изменения с других реплик сбрасывают локальный кэш
+	if cached, ok := repo.(*cache.Storage); ok && db != nil {
+		go db.Listen(ctx, cached, linksResyncInterval)
+	}
+
+	trustedProxies, err := ratelimit.ParseTrustedProxies(appConfig.TrustedProxies)
+	if err != nil {
+		logger.Log.Fatal("Некорректный список доверенных прокси:", zap.Error(err))
+	}
+
+	limiterStore := ratelimit.NewStore(10*time.Minute, 100000)
+	go limiterStore.Run(ctx)
+	limiter := ratelimit.NewLimiter(limiterStore, rateLimits(appConfig.RateLimits), ratelimit.ByAPIKey(ratelimit.KeySet(appConfig.APIKeys)), ratelimit.ByUser, ratelimit.ByIP(trustedProxies))
+	// попытки ввода пароля отдельно: запросы без cookie заводят новые ведра лимитера
+	// и вытеснили бы из общего хранилища счетчики попыток
+	unlockStore := ratelimit.NewStore(time.Hour, 100000)
+	go unlockStore.Run(ctx)
+
+	// адрес коротких ссылок общий для всех хэндлеров и меняется при перезагрузке конфига
+	baseURL := handlers.NewBaseURL(appConfig.RedirectBaseURL)
+
+	normalizer := urlnorm.New(urlnorm.Options{
+		AllowedSchemes: appConfig.AllowedSchemes,
+		SelfBaseURL:    appConfig.RedirectBaseURL,
+		SortQuery:      appConfig.SortQueryParams,
+	})
+
+	// события о ссылках доставляются подписчикам вебхуков в фоне и в открытые потоки SSE
+	var webhookRetention time.Duration
+	if appConfig.WebhookRetention != "" {
+		webhookRetention, err = time.ParseDuration(appConfig.WebhookRetention)
+		if err != nil {
+			logger.Log.Fatal("Некорректный срок хранения доставок вебхуков:", zap.Error(err))
+		}
+	}
+	dispatcherOpts := []webhooks.Option{
+		webhooks.WithRetention(webhookRetention, appConfig.WebhookDeliveriesKept),
+	}
+	var webhookOpts []handlers.WebhookOption
+	if appConfig.AllowInternalWebhooks {
+		dispatcherOpts = append(dispatcherOpts, webhooks.WithInternalTargets())
+		webhookOpts = append(webhookOpts, handlers.WithInternalWebhookTargets())
+	}
+	// переходы по ссылкам без лимита пишутся пачками
+	clicks := storage.NewClickBatcher(repo, clickFlushInterval)
+	go clicks.Run(ctx)
+
+	dispatcher := webhooks.NewDispatcher(repo, dispatcherOpts...)
+	go dispatcher.Run(ctx)
+	broker := stream.NewBroker(eventBufferSize)
+	events := handlers.Publishers{dispatcher, broker}
+
+	shortenOpts := []handlers.ShortenOption{
+		handlers.WithNormalizer(normalizer),
+		handlers.WithShortenEvents(events),
+	}
+	var unlockTTL time.Duration
+	if appConfig.UnlockTTL != "" {
+		unlockTTL, err = time.ParseDuration(appConfig.UnlockTTL)
+		if err != nil {
+			logger.Log.Fatal("Некорректный срок запоминания пароля ссылки:", zap.Error(err))
+		}
+	}
+
+	redirectOpts := []handlers.RedirectOption{
+		handlers.WithUnlockCookie(appConfig.SecretKey, unlockTTL),
+		handlers.WithSecureUnlockCookie(appConfig.EnableHTTPS),
+		handlers.WithTrustedProxies(trustedProxies),
+		handlers.WithUnlockAttemptStore(unlockStore),
+		handlers.WithGeoHeader(appConfig.GeoHeader),
+		handlers.WithRedirectStatus(appConfig.RedirectStatus),
+		handlers.WithBaseURL(baseURL),
+		handlers.WithRedirectEvents(events),
+		handlers.WithClickCounter(clicks),
+	}
+
+	if appConfig.RedirectCacheTTL != "" {
+		cacheTTL, err := time.ParseDuration(appConfig.RedirectCacheTTL)
+		if err != nil {
+			logger.Log.Fatal("Некорректный срок кэширования редиректов:", zap.Error(err))
+		}
+		redirectOpts = append(redirectOpts, handlers.WithCacheTTL(cacheTTL))
+	}
+
+	if appConfig.NotFoundPageFile != "" {
+		page, err := template.ParseFiles(appConfig.NotFoundPageFile)
+		if err != nil {
+			logger.Log.Fatal("Ошибка загрузки страницы неизвестной ссылки:", zap.Error(err))
+		}
+		redirectOpts = append(redirectOpts, handlers.WithNotFoundPage(page))
+	}
+
+	if appConfig.InactivePageFile != "" {
+		page, err := template.ParseFiles(appConfig.InactivePageFile)
+		if err != nil {
+			logger.Log.Fatal("Ошибка загрузки страницы неактивной ссылки:", zap.Error(err))
+		}
+		redirectOpts = append(redirectOpts, handlers.WithInactivePage(page))
+	}
+
+	var screener *screening.Screener
+	if appConfig.BlocklistFile != "" {
+		screener, err = screening.New(appConfig.BlocklistFile)
+		if err != nil {
+			logger.Log.Fatal("Ошибка загрузки правил блокировки:", zap.Error(err))
+		}
+		go screener.Watch(ctx, 5*time.Second, func(err error) {
+			logger.Log.Error("Ошибка перезагрузки правил блокировки", zap.Error(err))
+		})
+		shortenOpts = append(shortenOpts, handlers.WithScreener(screener))
+		redirectOpts = append(redirectOpts, handlers.WithRedirectScreener(screener))
+	}
+
+	// по SIGHUP и из админки перечитываем конфиг; на лету меняются уровень логов,
+	// адрес коротких ссылок, лимиты и правила блокировки
+	reloader := config.NewReloader(appConfig, func() (*config.AppConfig, error) {
+		cfg, _, err := loadConfig()
+		return cfg, err
+	}, func(cfg *config.AppConfig) error {
+		// правила читаются первыми: если файл испорчен, остальное не трогаем
+		if screener != nil {
+			if err := screener.Reload(); err != nil {
+				return fmt.Errorf("blocklist_file: %w", err)
+			}
+		}
+		if err := logger.SetLevel(cfg.LogLevel); err != nil {
+			return fmt.Errorf("log_level: %w", err)
+		}
+		baseURL.Set(cfg.RedirectBaseURL)
+		normalizer.SetSelfBaseURL(cfg.RedirectBaseURL)
+		limiter.SetLimits(rateLimits(cfg.RateLimits))
+		return nil
+	})
+
+	shortenHandler := handlers.NewShortenHandler(repo, baseURL, shortenOpts...)
+	accountHandler := handlers.NewAccountHandler(repo)
+	teamHandler := handlers.NewTeamHandler(repo, baseURL)
+	linkHandler := handlers.NewLinkHandler(repo, shortenHandler)
+	webhookHandler := handlers.NewWebhookHandler(repo, webhookOpts...)
+	eventStreamHandler := handlers.NewEventStreamHandler(broker)
 
 	r := chi.NewRouter()
 
 	r.Use(logger.WithRequestLogging)
 
-	r.Get("/ping", handlers.PingHandler(repo))
+	r.Get("/ping", pingHandler)
 
 	r.Group(func(r chi.Router) {
 		r.Mount("/debug/pprof", http.DefaultServeMux)
 	})
 
+	r.Group(func(r chi.Router) {
+		// архив уже сжат, поэтому без gzip мидлвари
+		r.Use(auth.WithAdminMiddleware(appConfig.AdminToken))
+		r.Get("/api/admin/backup", handlers.BackupHandler(repo))
+		r.Post("/api/admin/reload", handlers.ReloadHandler(reloader))
+		// метрики выдают нагрузку на сервис, поэтому только администратору
+		r.Mount("/debug/vars", http.DefaultServeMux)
+	})
+
 	r.Group(func(r chi.Router) {
 		r.Use(handlers.WithGzipMiddleware, auth.WithAuthMiddleware())
-		r.Post("/", shortenHandler.ShortenURL)
-		r.Get("/{shortCode}", handlers.NewRedirectHandler(repo).RedirectByShortURL)
-		r.Post("/api/shorten", shortenHandler.JSONShortenURL)
-		r.Post("/api/shorten/batch", shortenHandler.JSONShortenBatchURL)
-		r.Delete("/api/user/urls", handlers.APIDeleteUserURLsHandler(repo))
+		redirectHandler := handlers.NewRedirectHandler(repo, redirectOpts...)
+		r.Group(func(r chi.Router) {
+			r.Use(limiter.Middleware("redirect"))
+			for _, pattern := range []string{"/{shortCode}", "/{shortCode}/*"} {
+				r.Get(pattern, redirectHandler.RedirectByShortURL)
+				r.Head(pattern, redirectHandler.RedirectByShortURL)
+				r.Post(pattern, redirectHandler.RedirectByShortURL)
+			}
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(limiter.Middleware("shorten"))
+			r.Post("/", shortenHandler.ShortenURL)
+			r.Post("/api/shorten", shortenHandler.JSONShortenURL)
+			r.Post("/api/shorten/batch", shortenHandler.JSONShortenBatchURL)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(limiter.Middleware("api"))
+			r.Delete("/api/user/urls", handlers.APIDeleteUserURLsHandler(repo, baseURL, events))
+			r.Post("/api/user/register", accountHandler.Register)
+			r.Post("/api/user/login", accountHandler.Login)
+			r.Get("/api/urls/{shortCode}", redirectHandler.LinkInfo)
+			r.Get("/api/urls/{shortCode}/qr", redirectHandler.QRCode)
+		})
 	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(handlers.WithGzipMiddleware, auth.WithCheckAuthMiddleware())
-		r.Get("/api/user/urls", handlers.APIFetchUserURLsHandler(repo))
+		r.Use(handlers.WithGzipMiddleware, auth.WithCheckAuthMiddleware(), limiter.Middleware("api"))
+		r.Get("/api/user/urls", handlers.APIFetchUserURLsHandler(repo, baseURL))
+		r.Put("/api/user/urls/{shortCode}/schedule", linkHandler.UpdateSchedule)
+		r.Put("/api/user/urls/{shortCode}/forwarding", linkHandler.UpdateForwarding)
+		r.Put("/api/user/urls/{shortCode}/redirect", linkHandler.UpdateRedirect)
+		r.Put("/api/user/urls/{shortCode}/metadata", linkHandler.UpdateMetadata)
+		r.Get("/api/user/urls/{shortCode}/rules", linkHandler.GetRules)
+		r.Put("/api/user/urls/{shortCode}/rules", linkHandler.UpdateRules)
+		r.Post("/api/teams", teamHandler.CreateTeam)
+		r.Get("/api/teams", teamHandler.ListTeams)
+		r.Get("/api/teams/{teamID}/members", teamHandler.ListMembers)
+		r.Post("/api/teams/{teamID}/members", teamHandler.AddMember)
+		r.Delete("/api/teams/{teamID}/members/{userID}", teamHandler.RemoveMember)
+		r.Get("/api/teams/{teamID}/urls", teamHandler.ListURLs)
+		r.Post("/api/user/webhooks", webhookHandler.Create)
+		r.Get("/api/user/webhooks", webhookHandler.List)
+		r.Delete("/api/user/webhooks/{webhookID}", webhookHandler.Delete)
+		r.Get("/api/user/webhooks/{webhookID}/deliveries", webhookHandler.Deliveries)
+		r.Get("/api/user/events", eventStreamHandler.Stream)
 	})
 
 	// Создаем канал для сигналов ОС
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 
-	// Создаем контекст для graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// По SIGHUP перечитываем конфиг
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go func() {
+		for range reloadChan {
+			err := reloader.Reload()
+			var restartErr *config.RestartRequiredError
+			switch {
+			case err == nil:
+				logger.Log.Info("Конфигурация перезагружена")
+			case errors.As(err, &restartErr):
+				logger.Log.Warn("Конфигурация перезагружена, часть изменений требует перезапуска", zap.Strings("diff", restartErr.Diff))
+			default:
+				logger.Log.Error("Ошибка перезагрузки конфигурации", zap.Error(err))
+			}
+		}
+	}()
 
 	// Запускаем сервер в отдельной горутине
 	server := &http.Server{
 		Addr:    appConfig.ServerBaseURL,
 		Handler: r,
 	}
+	// Shutdown не прерывает открытые соединения, потоки событий закрываем сами
+	server.RegisterOnShutdown(broker.Close)
 
 	var serverErr error
 	serverStopped := make(chan struct{})
@@ -156,6 +441,11 @@ func main() {
 		logger.Log.Info("Сервер успешно остановлен")
 	}
 
+	// Дописываем накопленные переходы, пока хранилище открыто
+	if err := clicks.Flush(); err != nil {
+		logger.Log.Error("Ошибка записи переходов", zap.Error(err))
+	}
+
 	// Закрываем хранилище
 	if err := repo.Close(); err != nil {
 		logger.Log.Error("Ошибка при закрытии хранилища", zap.Error(err))
@@ -165,3 +455,38 @@ func main() {
 
 	logger.Log.Info("Приложение завершено")
 }
+
+// собрать конфиг из аргументов запуска и окружения.
+func loadConfig() (*config.AppConfig, config.Launch, error) {
+	return config.Load(os.Args[1:], os.LookupEnv)
+}
+
+// лимиты групп маршрутов из конфига.
+func rateLimits(limits map[string]config.RateLimitConfig) map[string]ratelimit.Limit {
+	res := make(map[string]ratelimit.Limit, len(limits))
+	for group, l := range limits {
+		res[group] = ratelimit.Limit{Rate: l.RPS, Burst: l.Burst}
+	}
+	return res
+}
+
+// настройки пула из конфига.
+func poolConfig(c config.DBPoolConfig) (storage.PoolConfig, error) {
+	pool := storage.PoolConfig{
+		MaxOpenConns: c.MaxOpenConns,
+		MaxIdleConns: c.MaxIdleConns,
+		ExecMode:     c.StatementCacheMode,
+	}
+	var err error
+	if c.ConnMaxLifetime != "" {
+		if pool.ConnMaxLifetime, err = time.ParseDuration(c.ConnMaxLifetime); err != nil {
+			return pool, err
+		}
+	}
+	if c.ConnMaxIdleTime != "" {
+		if pool.ConnMaxIdleTime, err = time.ParseDuration(c.ConnMaxIdleTime); err != nil {
+			return pool, err
+		}
+	}
+	return pool, nil
+}
@@ -11,12 +11,13 @@ import (
 
 // TestConfigInitialization тестирует инициализацию конфигурации
 func TestConfigInitialization(t *testing.T) {
-	// Инициализируем конфигурацию
-	appConfig := config.InitConfiguration()
+	// Инициализируем конфигурацию без флагов и окружения
+	appConfig, _, err := config.Load(nil, func(string) (string, bool) { return "", false })
+	assert.NoError(t, err)
 
 	// Проверяем что конфигурация загрузилась с фактическими значениями по умолчанию
 	assert.Equal(t, "localhost:8080", appConfig.ServerBaseURL)
-	assert.NotEmpty(t, appConfig.SecretKey)                   // SecretKey должен генерироваться автоматически
+	assert.NotEmpty(t, appConfig.SecretKey)                   // SecretKey задан по умолчанию
 	assert.Equal(t, "storage.txt", appConfig.StorageFileName) // Фактическое значение из вашего конфига
 }
 
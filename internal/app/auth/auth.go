@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/buharamanya/shortener/internal/app/config"
 	"github.com/buharamanya/shortener/internal/app/logger"
@@ -17,18 +18,94 @@ import (
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID string
+	Login  string `json:"login,omitempty"`
 }
 
 type contextKey string
 
+// имя куки с токеном.
+const authCookieName = "AUTH_TOKEN"
+
+// настройки выдачи токенов, задаются в Initialize.
+var (
+	keyring      *Keyring
+	tokenTTL     = 24 * time.Hour
+	tokenIssuer  = "shortener"
+	secureCookie bool
+)
+
+// Initialize настраивает связку ключей и параметры токенов по конфигу.
+// Секрет из SecretKey всегда остается в связке под kid "default",
+// чтобы выданные ранее токены продолжали проверяться.
+func Initialize(cfg *config.AppConfig) error {
+	ttl, err := time.ParseDuration(cfg.TokenTTL)
+	if err != nil || ttl <= 0 {
+		return fmt.Errorf("invalid token TTL %q", cfg.TokenTTL)
+	}
+
+	keys := []*SigningKey{NewHMACKey(DefaultKeyID, []byte(cfg.SecretKey))}
+	for _, kc := range cfg.JWTKeys {
+		if kc.KID == DefaultKeyID {
+			return fmt.Errorf("key id %q is reserved for secret_key", DefaultKeyID)
+		}
+
+		var key *SigningKey
+		if kc.KeyFile != "" {
+			key, err = LoadKeyFile(kc.KID, kc.Alg, kc.KeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to load key %q: %w", kc.KID, err)
+			}
+		} else {
+			if kc.Alg != "" && kc.Alg != jwt.SigningMethodHS256.Alg() {
+				return fmt.Errorf("key %q: %s requires key_file", kc.KID, kc.Alg)
+			}
+			key = NewHMACKey(kc.KID, []byte(kc.Secret))
+		}
+		keys = append(keys, key)
+	}
+
+	activeKID := cfg.JWTActiveKID
+	if activeKID == "" {
+		activeKID = keys[len(keys)-1].ID
+	}
+
+	kr, err := NewKeyring(activeKID, keys...)
+	if err != nil {
+		return err
+	}
+
+	keyring = kr
+	tokenTTL = ttl
+	tokenIssuer = cfg.JWTIssuer
+	secureCookie = cfg.EnableHTTPS
+
+	return nil
+}
+
+// связка ключей. До Initialize используется секрет по умолчанию.
+func currentKeyring() *Keyring {
+	if keyring != nil {
+		return keyring
+	}
+	return &Keyring{
+		active: DefaultKeyID,
+		keys: map[string]*SigningKey{
+			DefaultKeyID: NewHMACKey(DefaultKeyID, []byte(config.Default().SecretKey)),
+		},
+	}
+}
+
 // это UserIDContextKey.
 const UserIDContextKey contextKey = "userID"
 
+// логин именованного аккаунта, для анонимов в контексте пусто.
+const LoginContextKey contextKey = "login"
+
 // это авторизация.
 func WithAuthMiddleware() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authCookie, cookieErr := r.Cookie("AUTH_TOKEN")
+			authCookie, cookieErr := r.Cookie(authCookieName)
 
 			if cookieErr != nil {
 				if errors.Is(cookieErr, http.ErrNoCookie) {
@@ -46,19 +123,21 @@ func WithAuthMiddleware() func(next http.Handler) http.Handler {
 				}
 			}
 
-			userID := getUserID(authCookie.Value)
+			claims, refresh := verifyToken(authCookie.Value)
 
-			if userID == "" {
+			if claims == nil {
 				authCookie, err := setAuthCookie(w)
 				if err != nil {
 					w.WriteHeader(http.StatusInternalServerError)
 					logger.Log.Error("failed to build auth token", zap.Error(err))
 					return
 				}
-				userID = getUserID(authCookie.Value)
+				claims, _ = verifyToken(authCookie.Value)
+			} else if refresh {
+				refreshAuthCookie(w, claims)
 			}
 
-			newContext := context.WithValue(r.Context(), UserIDContextKey, userID)
+			newContext := withClaims(r.Context(), claims)
 			newRequest := r.WithContext(newContext)
 			next.ServeHTTP(w, newRequest)
 		})
@@ -69,7 +148,7 @@ func WithAuthMiddleware() func(next http.Handler) http.Handler {
 func WithCheckAuthMiddleware() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authCookie, cookieErr := r.Cookie("AUTH_TOKEN")
+			authCookie, cookieErr := r.Cookie(authCookieName)
 
 			if cookieErr != nil {
 				if cookieErr == http.ErrNoCookie {
@@ -82,31 +161,58 @@ func WithCheckAuthMiddleware() func(next http.Handler) http.Handler {
 
 			}
 
-			userID := getUserID(authCookie.Value)
+			claims, refresh := verifyToken(authCookie.Value)
 
-			if userID == "" {
+			if claims == nil {
 				w.WriteHeader(http.StatusUnauthorized)
 				logger.Log.Error("failed to parse auth token")
 				return
 			}
 
-			newContext := context.WithValue(r.Context(), UserIDContextKey, userID)
+			if refresh {
+				refreshAuthCookie(w, claims)
+			}
+
+			newContext := withClaims(r.Context(), claims)
 			newRequest := r.WithContext(newContext)
 			next.ServeHTTP(w, newRequest)
 		})
 	}
 }
 
+// положить данные токена в контекст.
+func withClaims(ctx context.Context, claims *Claims) context.Context {
+	ctx = context.WithValue(ctx, UserIDContextKey, claims.UserID)
+	return context.WithValue(ctx, LoginContextKey, claims.Login)
+}
+
 func setAuthCookie(w http.ResponseWriter) (*http.Cookie, error) {
-	authToken, err := buildJWTString()
+	return setUserCookie(w, uuid.New().String(), "")
+}
+
+// SetUserCookie выдает куку с токеном для конкретного пользователя,
+// login пустой для анонимов.
+func SetUserCookie(w http.ResponseWriter, userID, login string) error {
+	_, err := setUserCookie(w, userID, login)
+	return err
+}
+
+func setUserCookie(w http.ResponseWriter, userID, login string) (*http.Cookie, error) {
+	expiresAt := time.Now().Add(tokenTTL)
+
+	authToken, err := signToken(userID, login, expiresAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build auth token: %w", err)
 	}
 
 	cookie := &http.Cookie{
-		Name:     "AUTH_TOKEN",
+		Name:     authCookieName,
 		Value:    authToken,
+		Path:     "/",
+		Expires:  expiresAt,
 		HttpOnly: true,
+		Secure:   secureCookie,
+		SameSite: http.SameSiteLaxMode,
 	}
 
 	http.SetCookie(w, cookie)
@@ -114,14 +220,29 @@ func setAuthCookie(w http.ResponseWriter) (*http.Cookie, error) {
 	return cookie, nil
 }
 
+// продлить токен. Ошибка не критична: старый токен еще действует.
+func refreshAuthCookie(w http.ResponseWriter, claims *Claims) {
+	if _, err := setUserCookie(w, claims.UserID, claims.Login); err != nil {
+		logger.Log.Warn("failed to refresh auth token", zap.Error(err))
+	}
+}
+
 func buildJWTString() (string, error) {
-	userID := uuid.New().String()
+	return signToken(uuid.New().String(), "", time.Now().Add(tokenTTL))
+}
+
+func signToken(userID, login string, expiresAt time.Time) (string, error) {
+	now := time.Now()
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+	tokenString, err := currentKeyring().sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
 		UserID: userID,
+		Login:  login,
 	})
-
-	tokenString, err := token.SignedString([]byte(config.AppParams.SecretKey))
 	if err != nil {
 		return "", fmt.Errorf("failed to signed token: %w", err)
 	}
@@ -130,18 +251,31 @@ func buildJWTString() (string, error) {
 }
 
 func getUserID(tokenString string) string {
+	claims, _ := verifyToken(tokenString)
+	if claims == nil {
+		return ""
+	}
+	return claims.UserID
+}
+
+// проверить токен, nil для невалидного. Второе значение - пора ли выдать новый:
+// прошла половина срока жизни, токен без exp или подписан не активным ключом.
+func verifyToken(tokenString string) (*Claims, bool) {
 	claims := &Claims{}
+	kr := currentKeyring()
 
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-		return []byte(config.AppParams.SecretKey), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, kr.keyFunc)
+	if err != nil || claims.UserID == "" {
+		return nil, false
+	}
 
-	if err != nil {
-		return ""
+	if claims.Issuer != "" && claims.Issuer != tokenIssuer {
+		return nil, false
 	}
 
-	return claims.UserID
+	if claims.ExpiresAt == nil || kr.isStale(token) {
+		return claims, true
+	}
+
+	return claims, time.Until(claims.ExpiresAt.Time) < tokenTTL/2
 }
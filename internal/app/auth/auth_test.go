@@ -1,18 +1,43 @@
 package auth
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/buharamanya/shortener/internal/app/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// сбрасывает настройки, заданные через Initialize.
+func resetSettings() {
+	keyring = nil
+	tokenTTL = 24 * time.Hour
+	tokenIssuer = "shortener"
+	secureCookie = false
+}
+
+// подписывать токены одним HMAC-ключом с секретом secret.
+func useSecret(secret string) {
+	keyring = &Keyring{
+		active: DefaultKeyID,
+		keys:   map[string]*SigningKey{DefaultKeyID: NewHMACKey(DefaultKeyID, []byte(secret))},
+	}
+}
+
 func TestBuildJWTString(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	token, err := buildJWTString()
 	require.NoError(t, err)
@@ -20,7 +45,7 @@ func TestBuildJWTString(t *testing.T) {
 }
 
 func TestGetUserID_ValidToken(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	// Создаем валидный токен
 	tokenString, err := buildJWTString()
@@ -31,24 +56,24 @@ func TestGetUserID_ValidToken(t *testing.T) {
 }
 
 func TestGetUserID_InvalidToken(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	// Невалидный токен
 	userID := getUserID("invalid.token.here")
 	assert.Empty(t, userID)
 
 	// Токен с неправильной подписью
-	config.AppParams.SecretKey = "different-secret"
+	useSecret("different-secret")
 	tokenString, err := buildJWTString()
 	require.NoError(t, err)
 
-	config.AppParams.SecretKey = "test-secret-key" // Возвращаем оригинальный секрет
+	useSecret("test-secret-key") // Возвращаем оригинальный секрет
 	userID = getUserID(tokenString)
 	assert.Empty(t, userID)
 }
 
 func TestSetAuthCookie(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	w := httptest.NewRecorder()
 	cookie, err := setAuthCookie(w)
@@ -70,7 +95,7 @@ func TestSetAuthCookie(t *testing.T) {
 }
 
 func TestWithAuthMiddleware_NoCookie(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	middleware := WithAuthMiddleware()
 	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -97,7 +122,7 @@ func TestWithAuthMiddleware_NoCookie(t *testing.T) {
 }
 
 func TestWithAuthMiddleware_ValidCookie(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	// Сначала создаем валидный токен
 	tokenString, err := buildJWTString()
@@ -126,7 +151,7 @@ func TestWithAuthMiddleware_ValidCookie(t *testing.T) {
 }
 
 func TestWithAuthMiddleware_InvalidCookie(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	middleware := WithAuthMiddleware()
 	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -155,7 +180,7 @@ func TestWithAuthMiddleware_InvalidCookie(t *testing.T) {
 }
 
 func TestWithCheckAuthMiddleware_NoCookie(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	middleware := WithCheckAuthMiddleware()
 	handlerCalled := false
@@ -186,7 +211,7 @@ func TestWithCheckAuthMiddleware_NoCookie(t *testing.T) {
 }
 
 func TestWithCheckAuthMiddleware_ValidCookie(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	tokenString, err := buildJWTString()
 	require.NoError(t, err)
@@ -217,7 +242,7 @@ func TestWithCheckAuthMiddleware_ValidCookie(t *testing.T) {
 }
 
 func TestWithCheckAuthMiddleware_InvalidCookie(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	middleware := WithCheckAuthMiddleware()
 	handlerCalled := false
@@ -249,7 +274,7 @@ func TestContextKeyType(t *testing.T) {
 }
 
 func TestMiddlewareChaining(t *testing.T) {
-	config.AppParams.SecretKey = "test-secret-key"
+	useSecret("test-secret-key")
 
 	// Тестируем цепочку middleware
 	authMiddleware := WithAuthMiddleware()
@@ -274,3 +299,180 @@ func TestMiddlewareChaining(t *testing.T) {
 	defer response.Body.Close()
 	io.Copy(io.Discard, response.Body)
 }
+
+func TestInitialize_KeyRotation(t *testing.T) {
+	t.Cleanup(resetSettings)
+
+	cfg := &config.AppConfig{
+		SecretKey: "test-secret-key",
+		TokenTTL:  "1h",
+		JWTIssuer: "test",
+		JWTKeys:   []config.JWTKeyConfig{{KID: "k1", Alg: "HS256", Secret: "first-secret"}},
+	}
+	require.NoError(t, Initialize(cfg))
+
+	oldToken, err := buildJWTString()
+	require.NoError(t, err)
+
+	// Добавляем новый ключ и делаем его активным
+	cfg.JWTKeys = append(cfg.JWTKeys, config.JWTKeyConfig{KID: "k2", Alg: "HS256", Secret: "second-secret"})
+	cfg.JWTActiveKID = "k2"
+	require.NoError(t, Initialize(cfg))
+
+	// Старый токен все еще действителен, но его пора перевыпустить
+	claims, refresh := verifyToken(oldToken)
+	require.NotNil(t, claims)
+	assert.True(t, refresh)
+
+	newToken, err := buildJWTString()
+	require.NoError(t, err)
+	claims, refresh = verifyToken(newToken)
+	require.NotNil(t, claims)
+	assert.False(t, refresh)
+
+	// Ключ выведен из связки - токен больше не принимается
+	cfg.JWTKeys = cfg.JWTKeys[1:]
+	require.NoError(t, Initialize(cfg))
+	assert.Empty(t, getUserID(oldToken))
+}
+
+func TestVerifyToken_Expired(t *testing.T) {
+	useSecret("test-secret-key")
+
+	tokenString, err := signToken("user", "", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+
+	assert.Empty(t, getUserID(tokenString))
+}
+
+func TestVerifyToken_RefreshAfterHalfLife(t *testing.T) {
+	useSecret("test-secret-key")
+
+	tokenString, err := signToken("user", "", time.Now().Add(tokenTTL/4))
+	require.NoError(t, err)
+
+	claims, refresh := verifyToken(tokenString)
+	require.NotNil(t, claims)
+	assert.Equal(t, "user", claims.UserID)
+	assert.True(t, refresh)
+}
+
+func TestWithAuthMiddleware_RefreshesToken(t *testing.T) {
+	useSecret("test-secret-key")
+
+	tokenString, err := signToken("user", "", time.Now().Add(tokenTTL/4))
+	require.NoError(t, err)
+
+	handler := WithAuthMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "user", r.Context().Value(UserIDContextKey))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "AUTH_TOKEN", Value: tokenString})
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	response := w.Result()
+	defer response.Body.Close()
+
+	cookies := response.Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "user", getUserID(cookies[0].Value))
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+}
+
+func TestLoadKeyFile_Asymmetric(t *testing.T) {
+	t.Cleanup(resetSettings)
+
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPath := filepath.Join(dir, "rsa.pem")
+	require.NoError(t, os.WriteFile(rsaPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	}), 0600))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edBytes, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edPath := filepath.Join(dir, "ed.pem")
+	require.NoError(t, os.WriteFile(edPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edBytes}), 0600))
+
+	_, err = LoadKeyFile("bad", "EdDSA", rsaPath)
+	assert.Error(t, err)
+
+	for _, kc := range []config.JWTKeyConfig{
+		{KID: "rsa", Alg: "RS256", KeyFile: rsaPath},
+		{KID: "ed", Alg: "EdDSA", KeyFile: edPath},
+	} {
+		require.NoError(t, Initialize(&config.AppConfig{
+			SecretKey:    "test-secret-key",
+			TokenTTL:     "1h",
+			JWTActiveKID: kc.KID,
+			JWTKeys:      []config.JWTKeyConfig{kc},
+		}))
+
+		tokenString, err := buildJWTString()
+		require.NoError(t, err)
+		assert.NotEmpty(t, getUserID(tokenString), kc.Alg)
+	}
+}
+
+func TestInitialize_SecureCookie(t *testing.T) {
+	t.Cleanup(resetSettings)
+
+	require.NoError(t, Initialize(&config.AppConfig{
+		SecretKey:   "test-secret-key",
+		TokenTTL:    "1h",
+		EnableHTTPS: true,
+	}))
+
+	w := httptest.NewRecorder()
+	cookie, err := setAuthCookie(w)
+	require.NoError(t, err)
+	assert.True(t, cookie.Secure)
+	assert.False(t, cookie.Expires.IsZero())
+
+	assert.Error(t, Initialize(&config.AppConfig{SecretKey: "test-secret-key", TokenTTL: "forever"}))
+}
+
+func TestWithAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "valid token", token: "secret", authorization: "Bearer secret", wantStatus: http.StatusOK},
+		{name: "wrong token", token: "secret", authorization: "Bearer other", wantStatus: http.StatusUnauthorized},
+		{name: "no header", token: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "not bearer", token: "secret", authorization: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "token not configured", authorization: "Bearer ", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := WithAdminMiddleware(tt.token)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/backup", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestDummyPasswordHash(t *testing.T) {
+	// сверка с заглушкой должна стоить столько же, сколько с настоящим хэшем
+	cost, err := bcrypt.Cost([]byte(DummyPasswordHash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.DefaultCost, cost)
+	assert.False(t, CheckPassword(DummyPasswordHash, ""))
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// kid ключа, которым подписаны токены до появления ротации.
+const DefaultKeyID = "default"
+
+// ErrUnknownKey - в токене указан kid, которого нет в связке.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// ключ подписи токенов.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	sign   interface{} // nil, если ключ только для проверки
+	verify interface{}
+}
+
+// связка ключей, выбираемых по заголовку kid.
+type Keyring struct {
+	active string
+	keys   map[string]*SigningKey
+}
+
+// создать связку ключей. Подписывать можно только активным ключом.
+func NewKeyring(activeKID string, keys ...*SigningKey) (*Keyring, error) {
+	kr := &Keyring{
+		active: activeKID,
+		keys:   make(map[string]*SigningKey, len(keys)),
+	}
+
+	for _, k := range keys {
+		if _, ok := kr.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		kr.keys[k.ID] = k
+	}
+
+	active, ok := kr.keys[activeKID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q: %w", activeKID, ErrUnknownKey)
+	}
+	if active.sign == nil {
+		return nil, fmt.Errorf("active key %q has no private part", activeKID)
+	}
+
+	return kr, nil
+}
+
+// HMAC ключ из секрета.
+func NewHMACKey(kid string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:     kid,
+		Method: jwt.SigningMethodHS256,
+		sign:   secret,
+		verify: secret,
+	}
+}
+
+// загрузить ключ из файла. Для RS256 и EdDSA ожидается PEM,
+// публичный ключ годится только для проверки подписи.
+func LoadKeyFile(kid, alg, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		return NewHMACKey(kid, []byte(strings.TrimSpace(string(data)))), nil
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+		return parsePEMKey(kid, alg, data)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+func parsePEMKey(kid, alg string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in key file")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	key := &SigningKey{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.sign, key.verify = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.verify = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.sign, key.verify = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.verify = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	if key.Method.Alg() != alg {
+		return nil, fmt.Errorf("key file holds %s key, %s expected", key.Method.Alg(), alg)
+	}
+
+	return key, nil
+}
+
+// подписать claims активным ключом.
+func (kr *Keyring) sign(claims jwt.Claims) (string, error) {
+	key := kr.keys[kr.active]
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.sign)
+}
+
+// keyFunc для jwt.Parse. Токены без kid проверяются ключом по умолчанию.
+func (kr *Keyring) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		kid = DefaultKeyID
+	}
+
+	key, ok := kr.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+
+	return key.verify, nil
+}
+
+// подписан ли токен не активным ключом.
+func (kr *Keyring) isStale(t *jwt.Token) bool {
+	kid, _ := t.Header["kid"].(string)
+	return kid != kr.active
+}
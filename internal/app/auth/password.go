@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DummyPasswordHash - bcrypt-хэш случайного пароля той же стоимости, что у HashPassword.
+// С ним сверяют пароль, когда аккаунта нет, чтобы по времени ответа нельзя было
+// отличить неизвестный логин от неверного пароля.
+const DummyPasswordHash = "$2a$10$Oc3ch2oEw.VgtfnUh3u3d.Pwi3g.E1uiMskyV4PtYtuTBaq5ITTW2"
+
+// HashPassword возвращает bcrypt-хэш пароля.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword сверяет пароль с bcrypt-хэшем.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
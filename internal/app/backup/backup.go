@@ -0,0 +1,187 @@
+// Package backup снимает и восстанавливает резервные копии ссылок.
+//
+// Копия - tar.gz из двух файлов: manifest.json с версией формата, числом записей,
+// временем снимка и sha256 данных, и records.jsonl с записями по одной в строке.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+)
+
+// версия формата копии.
+const FormatVersion = 1
+
+// имена файлов внутри архива.
+const (
+	manifestName = "manifest.json"
+	recordsName  = "records.jsonl"
+)
+
+// ошибки чтения копии.
+var (
+	ErrFormat   = errors.New("malformed backup archive")
+	ErrVersion  = errors.New("unsupported backup version")
+	ErrChecksum = errors.New("backup checksum mismatch")
+)
+
+// описание копии.
+type Manifest struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	RecordCount int       `json:"record_count"`
+	// "sha256:<hex>" от records.jsonl
+	Checksum string `json:"checksum"`
+}
+
+// снять согласованную копию всех записей s и записать архив в w.
+// Сначала снимок целиком пишется во временный файл, поэтому ошибка
+// хранилища возвращается до того, как в w ушел хотя бы байт.
+func Write(w io.Writer, s storage.URLStorage, now time.Time) (Manifest, error) {
+	spool, err := os.CreateTemp("", "shortener-backup-*")
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	manifest := Manifest{Version: FormatVersion, CreatedAt: now.UTC()}
+	hash := sha256.New()
+	buf := bufio.NewWriter(io.MultiWriter(spool, hash))
+	encoder := json.NewEncoder(buf)
+	err = s.ExportRecords(func(record storage.ShortURLRecord) error {
+		manifest.RecordCount++
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		return Manifest{}, fmt.Errorf("snapshot: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return Manifest{}, err
+	}
+	manifest.Checksum = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return Manifest{}, err
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	if err := writeFile(tw, manifestName, int64(len(manifestData)), manifest.CreatedAt, bytes.NewReader(manifestData)); err != nil {
+		return manifest, err
+	}
+	if err := writeFile(tw, recordsName, size, manifest.CreatedAt, spool); err != nil {
+		return manifest, err
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, zw.Close()
+}
+
+func writeFile(tw *tar.Writer, name string, size int64, modTime time.Time, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, r, size)
+	return err
+}
+
+// прочитать архив и проверить его целостность. Записи возвращаются,
+// только если сошлись версия, число записей и контрольная сумма.
+func Read(r io.Reader) (Manifest, []storage.ShortURLRecord, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return Manifest{}, nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	var manifest Manifest
+	if err := nextFile(tr, manifestName); err != nil {
+		return Manifest{}, nil, err
+	}
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return Manifest{}, nil, fmt.Errorf("%w: manifest: %v", ErrFormat, err)
+	}
+	if manifest.Version != FormatVersion {
+		return manifest, nil, fmt.Errorf("%w: %d", ErrVersion, manifest.Version)
+	}
+
+	if err := nextFile(tr, recordsName); err != nil {
+		return manifest, nil, err
+	}
+	hash := sha256.New()
+	decoder := json.NewDecoder(io.TeeReader(tr, hash))
+	records := make([]storage.ShortURLRecord, 0, manifest.RecordCount)
+	for {
+		var record storage.ShortURLRecord
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return manifest, nil, fmt.Errorf("%w: record %d: %v", ErrFormat, len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+	// дочитываем хвост, чтобы хэш покрыл весь файл
+	if _, err := io.Copy(hash, tr); err != nil {
+		return manifest, nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+
+	if sum := "sha256:" + hex.EncodeToString(hash.Sum(nil)); sum != manifest.Checksum {
+		return manifest, nil, fmt.Errorf("%w: manifest %s, data %s", ErrChecksum, manifest.Checksum, sum)
+	}
+	if len(records) != manifest.RecordCount {
+		return manifest, nil, fmt.Errorf("%w: manifest has %d records, archive %d", ErrChecksum, manifest.RecordCount, len(records))
+	}
+	return manifest, records, nil
+}
+
+func nextFile(tr *tar.Reader, name string) error {
+	hdr, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("%w: expected %s: %v", ErrFormat, name, err)
+	}
+	if hdr.Name != name {
+		return fmt.Errorf("%w: expected %s, got %s", ErrFormat, name, hdr.Name)
+	}
+	return nil
+}
+
+// восстановить записи s из архива. Данные заменяются только после проверки всего архива.
+func Restore(r io.Reader, s storage.URLStorage) (Manifest, error) {
+	manifest, records, err := Read(r)
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, s.RestoreRecords(records)
+}
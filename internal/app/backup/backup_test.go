@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openStorage(t *testing.T, path string) *storage.InMemoryStorage {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	s := storage.NewInMemoryStorage(file)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func seed(t *testing.T, s storage.URLStorage, prefix string, n int) {
+	t.Helper()
+	records := make([]storage.ShortURLRecord, n)
+	for i := range records {
+		records[i] = storage.ShortURLRecord{
+			ShortCode:   fmt.Sprintf("%s%03d", prefix, i),
+			OriginalURL: fmt.Sprintf("https://example.com/%s/%d", prefix, i),
+			UserID:      "user",
+			DeletedFlag: i%4 == 0,
+			Tags:        []string{"t"},
+			CreatedAt:   time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC),
+		}
+	}
+	require.NoError(t, s.SaveBatch(records))
+}
+
+// переписать архив, подменив содержимое одного из файлов.
+func rewriteArchive(t *testing.T, archive []byte, change func(name string, data []byte) []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	tr := tar.NewReader(zr)
+
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(zw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		data = change(hdr.Name, data)
+		hdr.Size = int64(len(data))
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	return out.Bytes()
+}
+
+func TestWriteRestore(t *testing.T) {
+	dir := t.TempDir()
+	source := openStorage(t, filepath.Join(dir, "source.json"))
+	seed(t, source, "src", 10)
+
+	var archive bytes.Buffer
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	manifest, err := Write(&archive, source, now)
+	require.NoError(t, err)
+	assert.Equal(t, FormatVersion, manifest.Version)
+	assert.Equal(t, 10, manifest.RecordCount)
+	assert.Equal(t, now, manifest.CreatedAt)
+	assert.Contains(t, manifest.Checksum, "sha256:")
+
+	targetPath := filepath.Join(dir, "target.json")
+	target := openStorage(t, targetPath)
+	seed(t, target, "old", 3)
+	require.NoError(t, target.SaveAccount(storage.Account{UserID: "u1", Login: "alice", PasswordHash: "hash"}))
+
+	restored, err := Restore(bytes.NewReader(archive.Bytes()), target)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, restored)
+
+	check := func(s storage.URLStorage) {
+		t.Helper()
+		records, err := s.ListRecords("", 100)
+		require.NoError(t, err)
+		require.Len(t, records, 10)
+		assert.Equal(t, "src000", records[0].ShortCode)
+		assert.True(t, records[0].DeletedFlag)
+		assert.Equal(t, []string{"t"}, records[1].Tags)
+
+		_, err = s.GetRecord("old000")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+
+		// восстанавливаются только ссылки, аккаунты остаются
+		account, err := s.GetAccountByLogin("alice")
+		require.NoError(t, err)
+		assert.Equal(t, "u1", account.UserID)
+	}
+	check(target)
+
+	// после перезапуска файл читается в то же состояние
+	require.NoError(t, target.Close())
+	check(openStorage(t, targetPath))
+}
+
+func TestRestore_Rejected(t *testing.T) {
+	source := openStorage(t, filepath.Join(t.TempDir(), "source.json"))
+	seed(t, source, "src", 5)
+	var archive bytes.Buffer
+	_, err := Write(&archive, source, time.Now())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		archive []byte
+		wantErr error
+	}{
+		{
+			name: "tampered records",
+			archive: rewriteArchive(t, archive.Bytes(), func(name string, data []byte) []byte {
+				if name == recordsName {
+					return bytes.Replace(data, []byte("example.com"), []byte("evil.example"), 1)
+				}
+				return data
+			}),
+			wantErr: ErrChecksum,
+		},
+		{
+			name: "unknown version",
+			archive: rewriteArchive(t, archive.Bytes(), func(name string, data []byte) []byte {
+				if name == manifestName {
+					return bytes.Replace(data, []byte(`"version": 1`), []byte(`"version": 99`), 1)
+				}
+				return data
+			}),
+			wantErr: ErrVersion,
+		},
+		{
+			name:    "not an archive",
+			archive: []byte("hello"),
+			wantErr: ErrFormat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := openStorage(t, filepath.Join(t.TempDir(), "target.json"))
+			seed(t, target, "old", 3)
+
+			_, err := Restore(bytes.NewReader(tt.archive), target)
+			require.ErrorIs(t, err, tt.wantErr)
+
+			// данные не тронуты
+			records, err := target.ListRecords("", 100)
+			require.NoError(t, err)
+			assert.Len(t, records, 3)
+		})
+	}
+}
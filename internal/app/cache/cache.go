@@ -0,0 +1,256 @@
+// Package cache кэширует записи ссылок перед любым хранилищем, чтобы редиректы не ходили в базу.
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"golang.org/x/sync/singleflight"
+)
+
+// счетчики кэша.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+type item struct {
+	shortCode string
+	record    storage.ShortURLRecord
+	err       error // ErrNotFound и ErrDeleted тоже кэшируются
+	expires   time.Time
+}
+
+// Storage - хранилище с read-through LRU кэшем записей по коротким кодам.
+// Остальные методы идут в исходное хранилище как есть. Изменения через этот
+// экземпляр сбрасывают кэш сразу, изменения с других узлов видны не позже ttl.
+type Storage struct {
+	storage.URLStorage
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List // в начале - недавно использованные
+	size  int
+	ttl   time.Duration
+	// растет при каждом сбросе, чтобы загрузка, начатая до изменения, не положила в кэш старое
+	generation uint64
+
+	group     singleflight.Group
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+	now       func() time.Time
+}
+
+// обернуть хранилище кэшем на size записей, каждая живет не дольше ttl.
+func New(backend storage.URLStorage, size int, ttl time.Duration) *Storage {
+	return &Storage{
+		URLStorage: backend,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+		size:       size,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+// получить адрес.
+func (c *Storage) Get(shortCode string) (string, error) {
+	record, err := c.GetRecord(shortCode)
+	if err != nil {
+		return "", err
+	}
+	return record.OriginalURL, nil
+}
+
+// получить запись: из кэша или из хранилища. Одновременные промахи по одному коду
+// превращаются в один запрос к хранилищу.
+func (c *Storage) GetRecord(shortCode string) (storage.ShortURLRecord, error) {
+	if it, ok := c.lookup(shortCode); ok {
+		c.hits.Add(1)
+		return it.record, it.err
+	}
+	c.misses.Add(1)
+
+	v, _, _ := c.group.Do(shortCode, func() (interface{}, error) {
+		c.mu.Lock()
+		generation := c.generation
+		c.mu.Unlock()
+
+		record, err := c.URLStorage.GetRecord(shortCode)
+		it := &item{shortCode: shortCode, record: record, err: err}
+		if err == nil || errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrDeleted) {
+			c.store(it, generation)
+		}
+		return it, nil
+	})
+	it := v.(*item)
+	return it.record, it.err
+}
+
+func (c *Storage) lookup(shortCode string) (*item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[shortCode]
+	if !ok {
+		return nil, false
+	}
+	it := el.Value.(*item)
+	if !c.now().Before(it.expires) {
+		c.removeLocked(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return it, true
+}
+
+func (c *Storage) store(it *item, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	it.expires = c.now().Add(c.ttl)
+	if el, ok := c.items[it.shortCode]; ok {
+		el.Value = it
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[it.shortCode] = c.order.PushFront(it)
+	for c.order.Len() > c.size {
+		c.removeLocked(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *Storage) removeLocked(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*item).shortCode)
+}
+
+// сбросить записи кодов.
+func (c *Storage) Invalidate(shortCodes ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, code := range shortCodes {
+		if el, ok := c.items[code]; ok {
+			c.removeLocked(el)
+		}
+	}
+	// загрузка, которая идет прямо сейчас, не должна отдать старое следующим читателям
+	for _, code := range shortCodes {
+		c.group.Forget(code)
+	}
+}
+
+// сбросить весь кэш.
+func (c *Storage) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// текущие счетчики.
+func (c *Storage) Stats() Stats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+	}
+}
+
+// засчитать переход. Счетчик в кэшированной записи увеличиваем сами,
+// чтобы проверка лимита переходов не отставала.
+func (c *Storage) RegisterClick(shortCode, variant string) error {
+	err := c.URLStorage.RegisterClick(shortCode, variant)
+	if err != nil {
+		c.Invalidate(shortCode)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[shortCode]; ok {
+		it := *el.Value.(*item)
+		it.record.Clicks++
+		el.Value = &it
+	}
+	return nil
+}
+
+// записать пачку переходов, кэшированные счетчики увеличиваются так же, как в RegisterClick.
+func (c *Storage) AddClicks(counts []storage.ClickCount) error {
+	err := c.URLStorage.AddClicks(counts)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, count := range counts {
+		if el, ok := c.items[count.ShortCode]; ok {
+			it := *el.Value.(*item)
+			it.record.Clicks += count.Clicks
+			el.Value = &it
+		}
+	}
+	return nil
+}
+
+// обновить запись.
+func (c *Storage) UpdateRecord(record storage.ShortURLRecord) error {
+	defer c.Invalidate(record.ShortCode)
+	return c.URLStorage.UpdateRecord(record)
+}
+
+// прихранить; сбрасывает закэшированное "не найдено".
+func (c *Storage) Save(record storage.ShortURLRecord) error {
+	defer c.Invalidate(record.ShortCode)
+	return c.URLStorage.Save(record)
+}
+
+// прихранить много.
+func (c *Storage) SaveBatch(records []storage.ShortURLRecord) error {
+	codes := make([]string, 0, len(records))
+	for _, record := range records {
+		codes = append(codes, record.ShortCode)
+	}
+	defer c.Invalidate(codes...)
+	return c.URLStorage.SaveBatch(records)
+}
+
+// удалить ссылки.
+func (c *Storage) DeleteURLs(shortCodes []string, userID string) ([]storage.ShortURLRecord, error) {
+	defer c.Invalidate(shortCodes...)
+	return c.URLStorage.DeleteURLs(shortCodes, userID)
+}
+
+// передать ссылки другому пользаку. Какие коды затронуты, неизвестно, поэтому сбрасываем все.
+func (c *Storage) ReassignURLs(fromUserID, toUserID string) error {
+	defer c.Purge()
+	return c.URLStorage.ReassignURLs(fromUserID, toUserID)
+}
+
+// заменить все записи.
+func (c *Storage) RestoreRecords(records []storage.ShortURLRecord) error {
+	defer c.Purge()
+	return c.URLStorage.RestoreRecords(records)
+}
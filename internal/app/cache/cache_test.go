@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище, считающее чтения записей.
+type countingStorage struct {
+	storage.URLStorage
+	reads atomic.Int32
+	// если задан, прочитанное отдается только после его закрытия
+	block chan struct{}
+}
+
+func (s *countingStorage) GetRecord(shortCode string) (storage.ShortURLRecord, error) {
+	s.reads.Add(1)
+	record, err := s.URLStorage.GetRecord(shortCode)
+	if s.block != nil {
+		<-s.block
+	}
+	return record, err
+}
+
+func newBackend(t *testing.T) *countingStorage {
+	t.Helper()
+	file, err := os.OpenFile(filepath.Join(t.TempDir(), "storage.json"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	s := storage.NewInMemoryStorage(file)
+	t.Cleanup(func() { s.Close() })
+	return &countingStorage{URLStorage: s}
+}
+
+func TestStorage_ReadThrough(t *testing.T) {
+	backend := newBackend(t)
+	require.NoError(t, backend.Save(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-1"}))
+	c := New(backend, 10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		url, err := c.Get("abc123")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", url)
+	}
+	assert.Equal(t, int32(1), backend.reads.Load())
+
+	// неизвестный код тоже кэшируется
+	for i := 0; i < 3; i++ {
+		_, err := c.GetRecord("nope")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+	assert.Equal(t, int32(2), backend.reads.Load())
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(4), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, 2, stats.Size)
+}
+
+func TestStorage_Invalidation(t *testing.T) {
+	backend := newBackend(t)
+	c := New(backend, 10, time.Minute)
+
+	// сохранение сбрасывает закэшированное "не найдено"
+	_, err := c.GetRecord("abc123")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, c.Save(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-1"}))
+	record, err := c.GetRecord("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", record.OriginalURL)
+
+	// правка
+	record.Title = "Пример"
+	require.NoError(t, c.UpdateRecord(record))
+	record, err = c.GetRecord("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "Пример", record.Title)
+
+	// переходы считаются и в кэше
+	require.NoError(t, c.RegisterClick("abc123", "a"))
+	record, err = c.GetRecord("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, 1, record.Clicks)
+
+	// передача другому пользаку
+	require.NoError(t, c.ReassignURLs("user-1", "user-2"))
+	record, err = c.GetRecord("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "user-2", record.UserID)
+
+	// удаление
+	_, err = c.DeleteURLs([]string{"abc123"}, "user-2")
+	require.NoError(t, err)
+	_, err = c.GetRecord("abc123")
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+}
+
+func TestStorage_TTL(t *testing.T) {
+	backend := newBackend(t)
+	require.NoError(t, backend.Save(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com"}))
+
+	now := time.Now()
+	c := New(backend, 10, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.GetRecord("abc123")
+	now = now.Add(59 * time.Second)
+	c.GetRecord("abc123")
+	assert.Equal(t, int32(1), backend.reads.Load())
+
+	now = now.Add(time.Second)
+	c.GetRecord("abc123")
+	assert.Equal(t, int32(2), backend.reads.Load())
+}
+
+func TestStorage_EvictsLeastRecentlyUsed(t *testing.T) {
+	backend := newBackend(t)
+	c := New(backend, 2, time.Minute)
+
+	c.GetRecord("a")
+	c.GetRecord("b")
+	c.GetRecord("a") // b теперь самая старая
+	c.GetRecord("c")
+	assert.Equal(t, int32(3), backend.reads.Load())
+
+	c.GetRecord("a")
+	assert.Equal(t, int32(3), backend.reads.Load())
+	c.GetRecord("b")
+	assert.Equal(t, int32(4), backend.reads.Load())
+
+	stats := c.Stats()
+	assert.Equal(t, 2, stats.Size)
+	assert.Equal(t, uint64(2), stats.Evictions)
+}
+
+func TestStorage_CollapsesConcurrentMisses(t *testing.T) {
+	backend := newBackend(t)
+	require.NoError(t, backend.Save(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com"}))
+	backend.block = make(chan struct{})
+	c := New(backend, 10, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url, err := c.Get("abc123")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://example.com", url)
+		}()
+	}
+	// даем всем горутинам встать в ожидание
+	time.Sleep(50 * time.Millisecond)
+	close(backend.block)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), backend.reads.Load())
+}
+
+func TestStorage_StaleLoadIsNotCached(t *testing.T) {
+	backend := newBackend(t)
+	backend.block = make(chan struct{})
+	c := New(backend, 10, time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// чтение прошло до сохранения и вернет "не найдено"
+		_, err := c.GetRecord("abc123")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	require.NoError(t, c.Save(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com"}))
+	close(backend.block)
+	<-done
+
+	_, err := c.GetRecord("abc123")
+	assert.NoError(t, err)
+}
@@ -1,12 +1,31 @@
+// Package config собирает конфигурацию сервиса.
+//
+// Источники в порядке возрастания приоритета:
+//
+//  1. значения по умолчанию;
+//  2. файл из -c или CONFIG: JSON, YAML или TOML по расширению;
+//  3. флаги командной строки, только явно заданные;
+//  4. переменные окружения, только непустые.
+//
+// Файл накладывается поверх умолчаний, поэтому заданное в нем значение действует,
+// даже если оно нулевое, например "enable_https": false. Неизвестные ключи в файле,
+// значения не того типа и некорректные настройки возвращаются одной общей ошибкой.
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
-	"github.com/buharamanya/shortener/internal/app/logger"
-	"go.uber.org/zap"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -17,114 +36,298 @@ const (
 	defaultSecretKey       = "secret"
 	defaultEnableHTTPS     = false
 	defaultConfigFile      = ""
+	defaultTokenTTL        = "24h"
+	defaultJWTIssuer       = "shortener"
+	defaultLogLevel        = "info"
 )
 
+// ключ подписи JWT из конфига.
+type JWTKeyConfig struct {
+	KID     string `json:"kid"`
+	Alg     string `json:"alg"`
+	Secret  string `json:"secret,omitempty"`
+	KeyFile string `json:"key_file,omitempty"`
+}
+
+// лимит запросов группы маршрутов.
+type RateLimitConfig struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// настройки пула соединений с базой; пустые значения - умолчания драйвера.
+type DBPoolConfig struct {
+	MaxOpenConns    int    `json:"max_open_conns,omitempty"`
+	MaxIdleConns    int    `json:"max_idle_conns,omitempty"`
+	ConnMaxLifetime string `json:"conn_max_lifetime,omitempty"`
+	ConnMaxIdleTime string `json:"conn_max_idle_time,omitempty"`
+	// режим запросов pgx: cache_statement, cache_describe, describe_exec, exec, simple_protocol
+	StatementCacheMode string `json:"statement_cache_mode,omitempty"`
+}
+
 // структура для конфига.
 type AppConfig struct {
-	ServerBaseURL   string `json:"server_address"`
-	RedirectBaseURL string `json:"base_url"`
-	StorageFileName string `json:"file_storage_path"`
-	DataBaseDSN     string `json:"database_dsn"`
-	SecretKey       string `json:"secret_key"`
-	EnableHTTPS     bool   `json:"enable_https"`
+	ServerBaseURL   string         `json:"server_address"`
+	RedirectBaseURL string         `json:"base_url"`
+	StorageFileName string         `json:"file_storage_path"`
+	DataBaseDSN     string         `json:"database_dsn"`
+	SecretKey       string         `json:"secret_key"`
+	EnableHTTPS     bool           `json:"enable_https"`
+	TokenTTL        string         `json:"token_ttl"`
+	JWTIssuer       string         `json:"jwt_issuer"`
+	JWTActiveKID    string         `json:"jwt_active_kid,omitempty"`
+	JWTKeys         []JWTKeyConfig `json:"jwt_keys,omitempty"`
+	// лимиты по группам маршрутов: shorten, redirect, api
+	RateLimits     map[string]RateLimitConfig `json:"rate_limits,omitempty"`
+	TrustedProxies []string                   `json:"trusted_proxies,omitempty"`
+	// выданные ключи API; запросы с ними получают собственное ведро лимита
+	APIKeys []string `json:"api_keys,omitempty"`
+	// разрешенные схемы сокращаемых ссылок, по умолчанию http и https
+	AllowedSchemes  []string `json:"allowed_schemes,omitempty"`
+	SortQueryParams bool     `json:"sort_query_params,omitempty"`
+	// файл правил блокировки адресов назначения
+	BlocklistFile string `json:"blocklist_file,omitempty"`
+	// сколько помнить ввод пароля защищенной ссылки, по умолчанию час
+	UnlockTTL string `json:"unlock_ttl,omitempty"`
+	// html шаблон страницы для ссылок вне окна активности
+	InactivePageFile string `json:"inactive_page_file,omitempty"`
+	// заголовок со страной клиента от пограничного прокси, по умолчанию X-Country-Code
+	GeoHeader string `json:"geo_header,omitempty"`
+	// код редиректа по умолчанию: 301, 302, 307 или 308, без значения - 307
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// сколько кэшировать редиректы неизменяемых ссылок
+	RedirectCacheTTL string `json:"redirect_cache_ttl,omitempty"`
+	// html шаблон страницы для неизвестных кодов
+	NotFoundPageFile string `json:"not_found_page_file,omitempty"`
+	// сколько записей держать в кэше перед хранилищем, 0 - без кэша
+	StorageCacheSize int `json:"storage_cache_size,omitempty"`
+	// сколько живет запись в кэше, по умолчанию минута
+	StorageCacheTTL string `json:"storage_cache_ttl,omitempty"`
+	// реплики для чтения ссылок
+	DataBaseReplicaDSNs []string     `json:"database_replica_dsns,omitempty"`
+	DBPool              DBPoolConfig `json:"db_pool,omitempty"`
+	// токен для служебных ручек /api/admin, без него они закрыты
+	AdminToken string `json:"admin_token,omitempty"`
+	// связка ключей шифрования адресов назначения, без нее адреса хранятся открыто
+	URLKeysFile string `json:"url_keys_file,omitempty"`
+	// та же связка JSON-строкой, задается только через окружение
+	URLKeys string `json:"-"`
+	// уровень логирования: debug, info, warn, error
+	LogLevel string `json:"log_level,omitempty"`
+	// разрешить вебхуки на localhost и адреса частных сетей, по умолчанию запрещены
+	AllowInternalWebhooks bool `json:"allow_internal_webhooks,omitempty"`
+	// сколько хранить журнал завершенных доставок вебхуков, по умолчанию 30 дней
+	WebhookRetention string `json:"webhook_retention,omitempty"`
+	// сколько последних завершенных доставок хранить на подписку, по умолчанию 1000
+	WebhookDeliveriesKept int `json:"webhook_deliveries_kept,omitempty"`
 }
 
-// глобальный конфиг.
-var AppParams AppConfig
+// конфиг со значениями по умолчанию.
+func Default() AppConfig {
+	return AppConfig{
+		ServerBaseURL:   defaultServerBaseURL,
+		RedirectBaseURL: defaultRedirectBaseURL,
+		StorageFileName: defaultStorageFileName,
+		DataBaseDSN:     defaultDataBaseDSN,
+		SecretKey:       defaultSecretKey,
+		EnableHTTPS:     defaultEnableHTTPS,
+		TokenTTL:        defaultTokenTTL,
+		JWTIssuer:       defaultJWTIssuer,
+		LogLevel:        defaultLogLevel,
+	}
+}
 
-// инит глобального конфига.
-func InitConfiguration() *AppConfig {
-	var configFile string
+// параметры запуска, которые не входят в конфиг.
+type Launch struct {
+	// файл конфига из -c или CONFIG, пустой - без файла
+	File string
+	// напечатать итоговый конфиг без секретов и выйти
+	PrintConfig bool
+}
 
-	flag.StringVar(&AppParams.ServerBaseURL, "a", defaultServerBaseURL, "shortener server URL")
-	flag.StringVar(&AppParams.RedirectBaseURL, "b", defaultRedirectBaseURL, "shortener redirect URL")
-	flag.StringVar(&AppParams.StorageFileName, "f", defaultStorageFileName, "shortener storage filename")
-	flag.StringVar(&AppParams.DataBaseDSN, "d", defaultDataBaseDSN, "shortener database DSN")
-	flag.StringVar(&AppParams.SecretKey, "k", defaultSecretKey, "key for JWT")
-	flag.BoolVar(&AppParams.EnableHTTPS, "s", defaultEnableHTTPS, "enable HTTPS")
-	flag.StringVar(&configFile, "c", defaultConfigFile, "config file path")
+// собрать конфиг из умолчаний, файла, аргументов командной строки args (без имени программы)
+// и окружения lookupEnv, обычно os.LookupEnv, и проверить его. Все найденные ошибки
+// возвращаются вместе; конфиг возвращается и с ошибкой, чтобы его можно было показать.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*AppConfig, Launch, error) {
+	cfg := Default()
+	var launch Launch
 
-	flag.Parse()
+	fs := flag.NewFlagSet("shortener", flag.ContinueOnError)
+	flags := Default()
+	fs.StringVar(&flags.ServerBaseURL, "a", defaultServerBaseURL, "shortener server URL")
+	fs.StringVar(&flags.RedirectBaseURL, "b", defaultRedirectBaseURL, "shortener redirect URL")
+	fs.StringVar(&flags.StorageFileName, "f", defaultStorageFileName, "shortener storage filename")
+	fs.StringVar(&flags.DataBaseDSN, "d", defaultDataBaseDSN, "shortener database DSN")
+	fs.StringVar(&flags.SecretKey, "k", defaultSecretKey, "key for JWT")
+	fs.BoolVar(&flags.EnableHTTPS, "s", defaultEnableHTTPS, "enable HTTPS")
+	fs.StringVar(&launch.File, "c", defaultConfigFile, "config file path: .json, .yaml, .yml or .toml")
+	fs.BoolVar(&launch.PrintConfig, "print-config", false, "print the effective config with secrets redacted and exit")
+	if err := fs.Parse(args); err != nil {
+		return &cfg, launch, err
+	}
 
-	// Загружаем конфигурацию из файла, если указан
-	if configFile == "" {
-		configFile = os.Getenv("CONFIG")
+	if launch.File == "" {
+		launch.File, _ = lookupEnv("CONFIG")
 	}
 
-	if configFile != "" {
-		loadConfigFromFile(configFile)
+	var errs []error
+	if launch.File != "" {
+		if err := loadFile(launch.File, &cfg); err != nil {
+			// без файла остальное проверять бессмысленно
+			return &cfg, launch, err
+		}
 	}
 
-	// Переменные окружения имеют приоритет над файлом конфигурации
-	envServerBaseURL := os.Getenv("SERVER_ADDRESS")
-	envRedirectBaseURL := os.Getenv("BASE_URL")
-	envStorageFileName := os.Getenv("FILE_STORAGE_PATH")
-	envDataBaseDSN := os.Getenv("DATABASE_DSN")
-	envSecretKey := os.Getenv("SECRET_KEY")
-	envEnableHTTPS := os.Getenv("ENABLE_HTTPS")
+	// флаги перекрывают файл, только если заданы явно
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "a":
+			cfg.ServerBaseURL = flags.ServerBaseURL
+		case "b":
+			cfg.RedirectBaseURL = flags.RedirectBaseURL
+		case "f":
+			cfg.StorageFileName = flags.StorageFileName
+		case "d":
+			cfg.DataBaseDSN = flags.DataBaseDSN
+		case "k":
+			cfg.SecretKey = flags.SecretKey
+		case "s":
+			cfg.EnableHTTPS = flags.EnableHTTPS
+		}
+	})
 
-	if envServerBaseURL != "" {
-		AppParams.ServerBaseURL = envServerBaseURL
+	for _, v := range envVars {
+		value, ok := lookupEnv(v.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := v.apply(&cfg, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.name, err))
+		}
 	}
 
-	if envRedirectBaseURL != "" {
-		AppParams.RedirectBaseURL = envRedirectBaseURL
-	}
+	errs = append(errs, cfg.Validate())
+	return &cfg, launch, errors.Join(errs...)
+}
 
-	if envStorageFileName != "" {
-		AppParams.StorageFileName = envStorageFileName
+// наложить файл конфига на cfg. YAML и TOML приводятся к JSON,
+// чтобы у всех форматов были одни ключи и одни проверки типов.
+func loadFile(path string, cfg *AppConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
 	}
 
-	if envDataBaseDSN != "" {
-		AppParams.DataBaseDSN = envDataBaseDSN
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	case ".toml":
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q, want .json, .yaml, .yml or .toml", path, ext)
 	}
 
-	if envSecretKey != "" {
-		AppParams.SecretKey = envSecretKey
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
 	}
+	return nil
+}
 
-	if envEnableHTTPS == "true" || envEnableHTTPS == "1" {
-		AppParams.EnableHTTPS = true
-	}
+// переменная окружения и поле, которое она задает.
+type envVar struct {
+	name  string
+	apply func(c *AppConfig, value string) error
+}
 
-	return &AppParams
+// переменные окружения; CONFIG читается отдельно.
+var envVars = []envVar{
+	stringEnv("SERVER_ADDRESS", func(c *AppConfig) *string { return &c.ServerBaseURL }),
+	stringEnv("BASE_URL", func(c *AppConfig) *string { return &c.RedirectBaseURL }),
+	stringEnv("FILE_STORAGE_PATH", func(c *AppConfig) *string { return &c.StorageFileName }),
+	stringEnv("DATABASE_DSN", func(c *AppConfig) *string { return &c.DataBaseDSN }),
+	stringEnv("SECRET_KEY", func(c *AppConfig) *string { return &c.SecretKey }),
+	boolEnv("ENABLE_HTTPS", func(c *AppConfig) *bool { return &c.EnableHTTPS }),
+	stringEnv("TOKEN_TTL", func(c *AppConfig) *string { return &c.TokenTTL }),
+	stringEnv("JWT_ISSUER", func(c *AppConfig) *string { return &c.JWTIssuer }),
+	stringEnv("JWT_ACTIVE_KID", func(c *AppConfig) *string { return &c.JWTActiveKID }),
+	listEnv("TRUSTED_PROXIES", func(c *AppConfig) *[]string { return &c.TrustedProxies }, func(s string) []string {
+		return strings.Split(s, ",")
+	}),
+	listEnv("API_KEYS", func(c *AppConfig) *[]string { return &c.APIKeys }, func(s string) []string {
+		return strings.Split(s, ",")
+	}),
+	stringEnv("BLOCKLIST_FILE", func(c *AppConfig) *string { return &c.BlocklistFile }),
+	stringEnv("UNLOCK_TTL", func(c *AppConfig) *string { return &c.UnlockTTL }),
+	stringEnv("INACTIVE_PAGE_FILE", func(c *AppConfig) *string { return &c.InactivePageFile }),
+	stringEnv("GEO_HEADER", func(c *AppConfig) *string { return &c.GeoHeader }),
+	intEnv("REDIRECT_STATUS", func(c *AppConfig) *int { return &c.RedirectStatus }),
+	stringEnv("REDIRECT_CACHE_TTL", func(c *AppConfig) *string { return &c.RedirectCacheTTL }),
+	stringEnv("NOT_FOUND_PAGE_FILE", func(c *AppConfig) *string { return &c.NotFoundPageFile }),
+	intEnv("STORAGE_CACHE_SIZE", func(c *AppConfig) *int { return &c.StorageCacheSize }),
+	stringEnv("STORAGE_CACHE_TTL", func(c *AppConfig) *string { return &c.StorageCacheTTL }),
+	// в DSN бывают запятые в параметрах, поэтому реплики разделяются пробелами
+	listEnv("DATABASE_REPLICA_DSNS", func(c *AppConfig) *[]string { return &c.DataBaseReplicaDSNs }, strings.Fields),
+	intEnv("DB_MAX_OPEN_CONNS", func(c *AppConfig) *int { return &c.DBPool.MaxOpenConns }),
+	intEnv("DB_MAX_IDLE_CONNS", func(c *AppConfig) *int { return &c.DBPool.MaxIdleConns }),
+	stringEnv("DB_CONN_MAX_LIFETIME", func(c *AppConfig) *string { return &c.DBPool.ConnMaxLifetime }),
+	stringEnv("DB_CONN_MAX_IDLE_TIME", func(c *AppConfig) *string { return &c.DBPool.ConnMaxIdleTime }),
+	stringEnv("DB_STATEMENT_CACHE_MODE", func(c *AppConfig) *string { return &c.DBPool.StatementCacheMode }),
+	stringEnv("ADMIN_TOKEN", func(c *AppConfig) *string { return &c.AdminToken }),
+	stringEnv("URL_KEYS_FILE", func(c *AppConfig) *string { return &c.URLKeysFile }),
+	stringEnv("URL_KEYS", func(c *AppConfig) *string { return &c.URLKeys }),
+	stringEnv("LOG_LEVEL", func(c *AppConfig) *string { return &c.LogLevel }),
+	boolEnv("ALLOW_INTERNAL_WEBHOOKS", func(c *AppConfig) *bool { return &c.AllowInternalWebhooks }),
+	stringEnv("WEBHOOK_RETENTION", func(c *AppConfig) *string { return &c.WebhookRetention }),
+	intEnv("WEBHOOK_DELIVERIES_KEPT", func(c *AppConfig) *int { return &c.WebhookDeliveriesKept }),
 }
 
-// loadConfigFromFile загружает конфигурацию из JSON файла
-func loadConfigFromFile(filename string) {
-	file, err := os.Open(filename)
-	if err != nil {
-		logger.Log.Error("Warning: Cannot open config file ", zap.String("file", filename))
-		return
-	}
-	defer file.Close()
+func stringEnv(name string, field func(*AppConfig) *string) envVar {
+	return envVar{name, func(c *AppConfig, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
 
-	var fileConfig AppConfig
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&fileConfig); err != nil {
-		logger.Log.Error("Warning: Cannot open config file ", zap.String("file", filename))
-		return
-	}
+func boolEnv(name string, field func(*AppConfig) *bool) envVar {
+	return envVar{name, func(c *AppConfig, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
 
-	// Устанавливаем значения из файла только если они не пустые
-	if fileConfig.ServerBaseURL != "" {
-		AppParams.ServerBaseURL = fileConfig.ServerBaseURL
-	}
-	if fileConfig.RedirectBaseURL != "" {
-		AppParams.RedirectBaseURL = fileConfig.RedirectBaseURL
-	}
-	if fileConfig.StorageFileName != "" {
-		AppParams.StorageFileName = fileConfig.StorageFileName
-	}
-	if fileConfig.DataBaseDSN != "" {
-		AppParams.DataBaseDSN = fileConfig.DataBaseDSN
-	}
-	if fileConfig.SecretKey != "" {
-		AppParams.SecretKey = fileConfig.SecretKey
-	}
-	// Для булевых значений проверяем, было ли значение установлено в файле
-	// (в Go булево значение по умолчанию false, поэтому используем отдельный флаг)
-	if fileConfig.EnableHTTPS {
-		AppParams.EnableHTTPS = fileConfig.EnableHTTPS
-	}
+func intEnv(name string, field func(*AppConfig) *int) envVar {
+	return envVar{name, func(c *AppConfig, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func listEnv(name string, field func(*AppConfig) *[]string, split func(string) []string) envVar {
+	return envVar{name, func(c *AppConfig, value string) error {
+		*field(c) = split(value)
+		return nil
+	}}
 }
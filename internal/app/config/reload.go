@@ -0,0 +1,130 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// настройки, которые применяются без перезапуска. Остальные меняются только рестартом.
+var runtimeFields = map[string]bool{
+	"log_level":   true,
+	"base_url":    true,
+	"rate_limits": true,
+}
+
+// RestartRequiredError - в новом конфиге изменились настройки, которым нужен перезапуск.
+// Это предупреждение: остальное из нового конфига уже применено.
+type RestartRequiredError struct {
+	// изменения вида "server_address: \"a\" -> \"b\"", секреты скрыты
+	Diff []string
+}
+
+func (e *RestartRequiredError) Error() string {
+	return "restart required to change " + strings.Join(e.Diff, "; ")
+}
+
+// RestartDiff сравнивает конфиги и возвращает изменения настроек, которые
+// нельзя применить на лету. Значения секретов в диф не попадают.
+func RestartDiff(old, next *AppConfig) []string {
+	oldValue, nextValue := reflect.ValueOf(*old), reflect.ValueOf(*next)
+	oldShown, nextShown := reflect.ValueOf(old.Redacted()), reflect.ValueOf(next.Redacted())
+
+	var diff []string
+	t := oldValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := fieldName(t.Field(i))
+		if runtimeFields[name] || reflect.DeepEqual(oldValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			continue
+		}
+		from, to := showValue(oldShown.Field(i)), showValue(nextShown.Field(i))
+		if from == to {
+			// отличаются только скрытые значения
+			diff = append(diff, name+": changed")
+			continue
+		}
+		diff = append(diff, fmt.Sprintf("%s: %s -> %s", name, from, to))
+	}
+	return diff
+}
+
+// конфиг, в котором из next взяты только настройки, меняющиеся на лету.
+func withRuntimeFields(current, next *AppConfig) *AppConfig {
+	res := *current
+	resValue, nextValue := reflect.ValueOf(&res).Elem(), reflect.ValueOf(next).Elem()
+	t := resValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if runtimeFields[fieldName(t.Field(i))] {
+			resValue.Field(i).Set(nextValue.Field(i))
+		}
+	}
+	return &res
+}
+
+// имя настройки как в файле конфига.
+func fieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+func showValue(v reflect.Value) string {
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	return string(data)
+}
+
+// Reloader перечитывает конфиг и применяет настройки, которые меняются без перезапуска.
+// Текущий конфиг доступен только на чтение через Current.
+type Reloader struct {
+	mu      sync.Mutex
+	current atomic.Pointer[AppConfig]
+	load    func() (*AppConfig, error)
+	apply   func(*AppConfig) error
+}
+
+// создать перезагрузчик. load собирает новый конфиг, обычно через Load с теми же
+// аргументами запуска; apply применяет его и должен либо применить все, либо ничего.
+func NewReloader(current *AppConfig, load func() (*AppConfig, error), apply func(*AppConfig) error) *Reloader {
+	r := &Reloader{load: load, apply: apply}
+	r.current.Store(current)
+	return r
+}
+
+// действующий конфиг. Менять его нельзя.
+func (r *Reloader) Current() *AppConfig {
+	return r.current.Load()
+}
+
+// перечитать конфиг и применить. Если изменились настройки, которым нужен перезапуск,
+// применяется все остальное, а в ответ возвращается *RestartRequiredError с дифом:
+// действующий конфиг хранит прежние значения этих настроек, пока сервис не перезапущен.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := r.load()
+	if err != nil {
+		return err
+	}
+	current := r.current.Load()
+	diff := RestartDiff(current, next)
+	if len(diff) > 0 {
+		next = withRuntimeFields(current, next)
+	}
+	if err := r.apply(next); err != nil {
+		return err
+	}
+	r.current.Store(next)
+	if len(diff) > 0 {
+		return &RestartRequiredError{Diff: diff}
+	}
+	return nil
+}
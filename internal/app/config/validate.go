@@ -0,0 +1,348 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap/zapcore"
+)
+
+// минимальная длина секретов, с которыми можно работать в HTTPS.
+const minSecretLength = 16
+
+// ErrDefaultSecret - запуск в HTTPS с секретом по умолчанию.
+var ErrDefaultSecret = errors.New("default secret key is not allowed with HTTPS enabled")
+
+// ErrRedirectStatus - код редиректа не из 301, 302, 307, 308.
+var ErrRedirectStatus = errors.New("unsupported redirect status")
+
+// ErrWeakSecret - секрет короче minSecretLength.
+var ErrWeakSecret = fmt.Errorf("secret must be at least %d characters", minSecretLength)
+
+// ErrInvalid - значение настройки некорректно.
+var ErrInvalid = errors.New("invalid value")
+
+// группы маршрутов с отдельными лимитами.
+var rateLimitGroups = map[string]bool{"shorten": true, "redirect": true, "api": true}
+
+// режимы запросов pgx.
+var statementCacheModes = map[string]bool{
+	"cache_statement": true, "cache_describe": true, "describe_exec": true, "exec": true, "simple_protocol": true,
+}
+
+// алгоритмы подписи JWT.
+var jwtAlgs = map[string]bool{"HS256": true, "RS256": true, "EdDSA": true}
+
+// Validate проверяет, что с конфигом безопасно стартовать. Возвращает все найденные
+// ошибки сразу, каждая с именем настройки из файла.
+func (c *AppConfig) Validate() error {
+	var errs []error
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+
+	check("server_address", validateAddress(c.ServerBaseURL))
+	check("base_url", validateBaseURL(c.RedirectBaseURL))
+	if c.DataBaseDSN == "" && c.StorageFileName == "" {
+		check("file_storage_path", fmt.Errorf("%w: required without database_dsn", ErrInvalid))
+	}
+	if c.DataBaseDSN != "" {
+		check("database_dsn", validateDSN(c.DataBaseDSN))
+	}
+	for i, dsn := range c.DataBaseReplicaDSNs {
+		check(fmt.Sprintf("database_replica_dsns[%d]", i), validateDSN(dsn))
+	}
+	if len(c.DataBaseReplicaDSNs) > 0 && c.DataBaseDSN == "" {
+		check("database_replica_dsns", fmt.Errorf("%w: replicas require database_dsn", ErrInvalid))
+	}
+
+	switch {
+	case c.SecretKey == "":
+		check("secret_key", fmt.Errorf("%w: empty", ErrInvalid))
+	case c.EnableHTTPS && c.SecretKey == defaultSecretKey:
+		check("secret_key", ErrDefaultSecret)
+	case c.EnableHTTPS && len(c.SecretKey) < minSecretLength:
+		check("secret_key", ErrWeakSecret)
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < minSecretLength {
+		check("admin_token", ErrWeakSecret)
+	}
+
+	check("token_ttl", validateDuration(c.TokenTTL, true))
+	if c.JWTIssuer == "" {
+		check("jwt_issuer", fmt.Errorf("%w: empty", ErrInvalid))
+	}
+	check("jwt_keys", c.validateJWTKeys())
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		check("log_level", fmt.Errorf("%w: %q", ErrInvalid, c.LogLevel))
+	}
+
+	for group, l := range c.RateLimits {
+		if !rateLimitGroups[group] {
+			check("rate_limits", fmt.Errorf("%w: unknown group %q, want shorten, redirect or api", ErrInvalid, group))
+		}
+		if l.RPS <= 0 || l.Burst <= 0 {
+			check("rate_limits."+group, fmt.Errorf("%w: rps and burst must be positive", ErrInvalid))
+		}
+	}
+	for i, proxy := range c.TrustedProxies {
+		check(fmt.Sprintf("trusted_proxies[%d]", i), validateProxy(proxy))
+	}
+	for i, scheme := range c.AllowedSchemes {
+		if !validScheme(scheme) {
+			check(fmt.Sprintf("allowed_schemes[%d]", i), fmt.Errorf("%w: %q", ErrInvalid, scheme))
+		}
+	}
+
+	check("blocklist_file", validateFile(c.BlocklistFile))
+	check("inactive_page_file", validateFile(c.InactivePageFile))
+	check("not_found_page_file", validateFile(c.NotFoundPageFile))
+	check("url_keys_file", validateFile(c.URLKeysFile))
+
+	check("unlock_ttl", validateDuration(c.UnlockTTL, false))
+	check("redirect_cache_ttl", validateDuration(c.RedirectCacheTTL, false))
+	check("storage_cache_ttl", validateDuration(c.StorageCacheTTL, false))
+	check("webhook_retention", validateDuration(c.WebhookRetention, false))
+	if c.WebhookDeliveriesKept < 0 {
+		check("webhook_deliveries_kept", fmt.Errorf("%w: negative", ErrInvalid))
+	}
+	if c.GeoHeader != "" && !validHeader(c.GeoHeader) {
+		check("geo_header", fmt.Errorf("%w: %q", ErrInvalid, c.GeoHeader))
+	}
+	switch c.RedirectStatus {
+	case 0, 301, 302, 307, 308:
+	default:
+		check("redirect_status", fmt.Errorf("%w: %d", ErrRedirectStatus, c.RedirectStatus))
+	}
+	if c.StorageCacheSize < 0 {
+		check("storage_cache_size", fmt.Errorf("%w: negative", ErrInvalid))
+	}
+
+	if c.DBPool.MaxOpenConns < 0 || c.DBPool.MaxIdleConns < 0 {
+		check("db_pool", fmt.Errorf("%w: negative connection count", ErrInvalid))
+	}
+	check("db_pool.conn_max_lifetime", validateDuration(c.DBPool.ConnMaxLifetime, false))
+	check("db_pool.conn_max_idle_time", validateDuration(c.DBPool.ConnMaxIdleTime, false))
+	if mode := c.DBPool.StatementCacheMode; mode != "" && !statementCacheModes[mode] {
+		check("db_pool.statement_cache_mode", fmt.Errorf("%w: %q", ErrInvalid, mode))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *AppConfig) validateJWTKeys() error {
+	var errs []error
+	seen := make(map[string]bool, len(c.JWTKeys))
+	for i, k := range c.JWTKeys {
+		var err error
+		switch {
+		case k.KID == "":
+			err = fmt.Errorf("%w: kid is required", ErrInvalid)
+		case seen[k.KID]:
+			err = fmt.Errorf("%w: duplicate kid %q", ErrInvalid, k.KID)
+		case k.Alg != "" && !jwtAlgs[k.Alg]:
+			err = fmt.Errorf("%w: unsupported alg %q", ErrInvalid, k.Alg)
+		case (k.Secret == "") == (k.KeyFile == ""):
+			err = fmt.Errorf("%w: exactly one of secret and key_file is required", ErrInvalid)
+		case k.Secret != "" && len(k.Secret) < minSecretLength:
+			err = ErrWeakSecret
+		case k.KeyFile != "":
+			err = validateFile(k.KeyFile)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("[%d]: %w", i, err))
+		}
+		seen[k.KID] = true
+	}
+	if c.JWTActiveKID != "" && c.JWTActiveKID != "default" && !seen[c.JWTActiveKID] {
+		errs = append(errs, fmt.Errorf("%w: active kid %q not found", ErrInvalid, c.JWTActiveKID))
+	}
+	return errors.Join(errs...)
+}
+
+// адрес сервера host:port.
+func validateAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%w: port %q", ErrInvalid, port)
+	}
+	return nil
+}
+
+// адрес, к которому добавляются короткие коды.
+func validateBaseURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https":
+		return fmt.Errorf("%w: %q must be an http or https URL", ErrInvalid, raw)
+	case u.Host == "":
+		return fmt.Errorf("%w: %q has no host", ErrInvalid, raw)
+	case u.RawQuery != "" || u.Fragment != "" || strings.HasSuffix(u.Path, "/"):
+		return fmt.Errorf("%w: %q must not end with a slash, query or fragment", ErrInvalid, raw)
+	}
+	return nil
+}
+
+func validateDSN(dsn string) error {
+	if _, err := pgconn.ParseConfig(dsn); err != nil {
+		// текст ошибки pgconn может содержать пароль
+		return fmt.Errorf("%w: cannot parse database DSN", ErrInvalid)
+	}
+	return nil
+}
+
+// длительность в формате time.ParseDuration; пустая допустима, если не required.
+func validateDuration(s string, required bool) error {
+	if s == "" && !required {
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%w: duration %q", ErrInvalid, s)
+	}
+	if d <= 0 {
+		return fmt.Errorf("%w: duration %q must be positive", ErrInvalid, s)
+	}
+	return nil
+}
+
+// доверенный прокси - адрес или подсеть.
+func validateProxy(s string) error {
+	s = strings.TrimSpace(s)
+	if _, err := netip.ParsePrefix(s); err == nil {
+		return nil
+	}
+	if _, err := netip.ParseAddr(s); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %q is neither an IP nor a CIDR", ErrInvalid, s)
+}
+
+// файл, если задан, должен существовать.
+func validateFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%w: %s is a directory", ErrInvalid, path)
+	}
+	return nil
+}
+
+func validScheme(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		letter := r >= 'a' && r <= 'z'
+		if !letter && (i == 0 || !(r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.')) {
+			return false
+		}
+	}
+	return true
+}
+
+func validHeader(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// заглушка вместо секретов при печати конфига.
+const redacted = "REDACTED"
+
+// копия конфига без секретов: ключи и токены заменены, пароли в DSN скрыты.
+func (c *AppConfig) Redacted() AppConfig {
+	r := *c
+	hide := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return redacted
+	}
+
+	r.SecretKey = hide(r.SecretKey)
+	r.AdminToken = hide(r.AdminToken)
+	r.URLKeys = hide(r.URLKeys)
+	r.DataBaseDSN = redactDSN(r.DataBaseDSN)
+	if r.DataBaseReplicaDSNs != nil {
+		replicas := make([]string, len(r.DataBaseReplicaDSNs))
+		for i, dsn := range r.DataBaseReplicaDSNs {
+			replicas[i] = redactDSN(dsn)
+		}
+		r.DataBaseReplicaDSNs = replicas
+	}
+	if r.APIKeys != nil {
+		apiKeys := make([]string, len(r.APIKeys))
+		for i, k := range r.APIKeys {
+			apiKeys[i] = hide(k)
+		}
+		r.APIKeys = apiKeys
+	}
+	if r.JWTKeys != nil {
+		keys := make([]JWTKeyConfig, len(r.JWTKeys))
+		for i, k := range r.JWTKeys {
+			k.Secret = hide(k.Secret)
+			keys[i] = k
+		}
+		r.JWTKeys = keys
+	}
+	return r
+}
+
+// скрыть пароль в DSN вида URL или key=value.
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	fields := strings.Fields(dsn)
+	for i, f := range fields {
+		if strings.HasPrefix(f, "password=") {
+			fields[i] = "password=" + redacted
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// напечатать конфиг без секретов в JSON.
+func (c *AppConfig) Print(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c.Redacted())
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// минимальная длина пароля аккаунта.
+const minPasswordLength = 8
+
+// хранилище аккаунтов.
+type AccountStore interface {
+	SaveAccount(account storage.Account) error
+	GetAccountByLogin(login string) (storage.Account, error)
+	ReassignURLs(fromUserID, toUserID string) error
+}
+
+// тип хэндлер аккаунтов.
+type AccountHandler struct {
+	storage AccountStore
+}
+
+// создать хэндлер аккаунтов.
+func NewAccountHandler(storage AccountStore) *AccountHandler {
+	return &AccountHandler{
+		storage: storage,
+	}
+}
+
+// регистрация. Анонимная личность из куки становится аккаунтом вместе со своими урлами.
+func (ah *AccountHandler) Register(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAccountRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, "password is too short", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Context().Value(auth.UserIDContextKey).(string)
+	if login, _ := r.Context().Value(auth.LoginContextKey).(string); login != "" {
+		// текущая личность уже принадлежит другому аккаунту
+		userID = uuid.New().String()
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to hash password", zap.Error(err))
+		return
+	}
+
+	account := storage.Account{
+		UserID:       userID,
+		Login:        req.Login,
+		PasswordHash: hash,
+	}
+
+	if err := ah.storage.SaveAccount(account); err != nil {
+		if errors.Is(err, storage.ErrLoginTaken) {
+			http.Error(w, "login already taken", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("Ошибка сохранения аккаунта", zap.Error(err))
+		return
+	}
+
+	ah.respondWithAccount(w, account, http.StatusCreated)
+}
+
+// вход. Урлы текущей анонимной личности переходят аккаунту.
+func (ah *AccountHandler) Login(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAccountRequest(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := ah.storage.GetAccountByLogin(req.Login)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to fetch account", zap.Error(err))
+		return
+	}
+
+	hash := account.PasswordHash
+	if err != nil {
+		// неизвестный логин проверяется так же долго, как неверный пароль
+		hash = auth.DummyPasswordHash
+	}
+	if !auth.CheckPassword(hash, req.Password) || err != nil {
+		http.Error(w, "invalid login or password", http.StatusUnauthorized)
+		return
+	}
+
+	userID := r.Context().Value(auth.UserIDContextKey).(string)
+	login, _ := r.Context().Value(auth.LoginContextKey).(string)
+	if login == "" && userID != account.UserID {
+		if err := ah.storage.ReassignURLs(userID, account.UserID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Log.Error("Ошибка передачи урлов аккаунту", zap.Error(err))
+			return
+		}
+	}
+
+	ah.respondWithAccount(w, account, http.StatusOK)
+}
+
+func (ah *AccountHandler) respondWithAccount(w http.ResponseWriter, account storage.Account, status int) {
+	if err := auth.SetUserCookie(w, account.UserID, account.Login); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to build auth token", zap.Error(err))
+		return
+	}
+
+	writeJSON(w, status, AccountResponse{UserID: account.UserID, Login: account.Login})
+}
+
+func decodeAccountRequest(w http.ResponseWriter, r *http.Request) (AccountRequest, bool) {
+	var req AccountRequest
+
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		logger.Log.Error("failed to read request body", zap.Error(err))
+		return req, false
+	}
+
+	req.Login = strings.TrimSpace(req.Login)
+	if req.Login == "" || req.Password == "" {
+		http.Error(w, "login and password are required", http.StatusBadRequest)
+		return req, false
+	}
+
+	return req, true
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountHandler_Register(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		login          string
+		mockSetup      func(*storage.MockURLStorage)
+		expectedStatus int
+	}{
+		{
+			name: "Success:_Claim_anonymous_identity",
+			body: `{"login":"alice","password":"password123"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("SaveAccount", mock.MatchedBy(func(a storage.Account) bool {
+					return a.UserID == "anon-id" && a.Login == "alice" && auth.CheckPassword(a.PasswordHash, "password123")
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:  "Success:_Named_identity_gets_new_id",
+			body:  `{"login":"bob","password":"password123"}`,
+			login: "alice",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("SaveAccount", mock.MatchedBy(func(a storage.Account) bool {
+					return a.UserID != "anon-id" && a.Login == "bob"
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "Fail:_Login_taken",
+			body: `{"login":"alice","password":"password123"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("SaveAccount", mock.Anything).Return(storage.ErrLoginTaken)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Fail:_Short_password",
+			body:           `{"login":"alice","password":"short"}`,
+			mockSetup:      func(m *storage.MockURLStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Fail:_Invalid_JSON",
+			body:           `{"login":`,
+			mockSetup:      func(m *storage.MockURLStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			tt.mockSetup(mockStorage)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(tt.body))
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "anon-id")
+			ctx = context.WithValue(ctx, auth.LoginContextKey, tt.login)
+			rr := httptest.NewRecorder()
+
+			NewAccountHandler(mockStorage).Register(rr, req.WithContext(ctx))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Ошибка: некорректный статуса ответа")
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAccountHandler_Login(t *testing.T) {
+	hash, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	account := storage.Account{UserID: "account-id", Login: "alice", PasswordHash: hash}
+
+	tests := []struct {
+		name           string
+		body           string
+		login          string
+		mockSetup      func(*storage.MockURLStorage)
+		expectedStatus int
+	}{
+		{
+			name: "Success:_Anonymous_links_are_merged",
+			body: `{"login":"alice","password":"password123"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetAccountByLogin", "alice").Return(account, nil)
+				m.On("ReassignURLs", "anon-id", "account-id").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:  "Success:_Other_account_links_are_kept",
+			body:  `{"login":"alice","password":"password123"}`,
+			login: "bob",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetAccountByLogin", "alice").Return(account, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Fail:_Wrong_password",
+			body: `{"login":"alice","password":"wrong-password"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetAccountByLogin", "alice").Return(account, nil)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Fail:_Unknown_login",
+			body: `{"login":"nobody","password":"password123"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetAccountByLogin", "nobody").Return(storage.Account{}, storage.ErrNotFound)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			tt.mockSetup(mockStorage)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(tt.body))
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "anon-id")
+			ctx = context.WithValue(ctx, auth.LoginContextKey, tt.login)
+			rr := httptest.NewRecorder()
+
+			NewAccountHandler(mockStorage).Login(rr, req.WithContext(ctx))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Ошибка: некорректный статуса ответа")
+			if tt.expectedStatus == http.StatusOK {
+				cookies := rr.Result().Cookies()
+				require.Len(t, cookies, 1)
+				assert.Equal(t, "AUTH_TOKEN", cookies[0].Name)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
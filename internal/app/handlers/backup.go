@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/backup"
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"go.uber.org/zap"
+)
+
+// выгрузить резервную копию всех ссылок без остановки сервера.
+func BackupHandler(s storage.URLStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().UTC()
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="shortener-%s.tar.gz"`, now.Format("20060102T150405Z")))
+
+		manifest, err := backup.Write(w, s, now)
+		if err != nil {
+			logger.Log.Error("failed to write backup", zap.Error(err))
+			// если снимок не удался, в ответ еще ничего не ушло
+			if manifest.Checksum == "" {
+				w.Header().Del("Content-Disposition")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+		logger.Log.Info("backup written", zap.Int("records", manifest.RecordCount), zap.String("checksum", manifest.Checksum))
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import "sync/atomic"
+
+// BaseURL - адрес, от которого строятся короткие ссылки. Один экземпляр
+// разделяют все хэндлеры, чтобы смена адреса при перезагрузке конфига
+// применялась сразу везде.
+type BaseURL struct {
+	v atomic.Pointer[string]
+}
+
+// создать адрес коротких ссылок.
+func NewBaseURL(baseURL string) *BaseURL {
+	b := &BaseURL{}
+	b.Set(baseURL)
+	return b
+}
+
+// сменить адрес на лету.
+func (b *BaseURL) Set(baseURL string) {
+	b.v.Store(&baseURL)
+}
+
+// текущий адрес, для nil пустой.
+func (b *BaseURL) String() string {
+	if b == nil {
+		return ""
+	}
+	return *b.v.Load()
+}
+
+// короткая ссылка для кода.
+func (b *BaseURL) ShortURL(shortCode string) string {
+	return b.String() + "/" + shortCode
+}
@@ -6,16 +6,19 @@ import (
 
 	"github.com/buharamanya/shortener/internal/app/auth"
 	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/buharamanya/shortener/internal/app/webhooks"
 	"go.uber.org/zap"
 )
 
 // удалятор.
 type URLDeleter interface {
-	DeleteURLs(shortCodes []string, userID string) error
+	DeleteURLs(shortCodes []string, userID string) ([]storage.ShortURLRecord, error)
 }
 
-// Удаление сохраненных пользователем урлов.
-func APIDeleteUserURLsHandler(s URLDeleter) http.HandlerFunc {
+// Удаление сохраненных пользователем урлов. О каждой удаленной ссылке
+// сообщается events, если он задан.
+func APIDeleteUserURLsHandler(s URLDeleter, baseURL *BaseURL, events EventPublisher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		var req []string
@@ -25,10 +28,18 @@ func APIDeleteUserURLsHandler(s URLDeleter) http.HandlerFunc {
 			logger.Log.Error("Ошибка чтение запроса", zap.Error(err))
 			return
 		}
+		userID := r.Context().Value(auth.UserIDContextKey).(string)
 		go func() {
-			err := s.DeleteURLs(req, r.Context().Value(auth.UserIDContextKey).(string))
+			deleted, err := s.DeleteURLs(req, userID)
 			if err != nil {
 				logger.Log.Error("Ошибка удаления url", zap.Error(err))
+				return
+			}
+			if events == nil {
+				return
+			}
+			for _, record := range deleted {
+				events.Publish(record.UserID, webhooks.EventLinkDeleted, linkData(baseURL, record))
 			}
 		}()
 
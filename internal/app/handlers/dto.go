@@ -1,15 +1,24 @@
 package handlers
 
+import (
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+)
+
 // дто ответ.
 type UserURLsDataResponse struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	LinkMetadata
 }
 
 // дто на запрос для массового сокращения.
 type ShortenlURLBatchRequest struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	TeamID        string `json:"team_id,omitempty"`
+	LinkOptions
 }
 
 // дто ответ на запрос для массового сокращения.
@@ -17,3 +26,66 @@ type ShortenlURLBatchResponce struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
 }
+
+// дто запроса на регистрацию и вход.
+type AccountRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// дто ответа с данными аккаунта.
+type AccountResponse struct {
+	UserID string `json:"user_id"`
+	Login  string `json:"login"`
+}
+
+// дто запроса на создание команды.
+type TeamRequest struct {
+	Name string `json:"name"`
+}
+
+// дто запроса на приглашение в команду.
+type TeamMemberRequest struct {
+	Login string       `json:"login"`
+	Role  storage.Role `json:"role"`
+}
+
+// дто правил перенаправления ссылки.
+type RulesRequest struct {
+	Rules []storage.RedirectRule `json:"rules"`
+}
+
+// дто ответа с правилами и переходами по вариантам.
+type RulesResponse struct {
+	Rules  []storage.RedirectRule `json:"rules"`
+	Clicks map[string]int         `json:"clicks"`
+}
+
+// дто кода редиректа ссылки, 0 - код по умолчанию.
+type RedirectSettings struct {
+	RedirectStatus int `json:"redirect_status"`
+}
+
+// дто запроса на подписку. Без секрета он генерируется, без событий - подписка на все.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// дто подписки. Секрет показывается только при создании.
+type WebhookResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// дто результата перезагрузки конфига. При отказе RestartRequired перечисляет
+// изменения, которые требуют перезапуска.
+type ReloadResponse struct {
+	Status          string   `json:"status"`
+	Error           string   `json:"error,omitempty"`
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
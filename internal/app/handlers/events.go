@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/buharamanya/shortener/internal/app/webhooks"
+)
+
+// получатель событий о ссылках, например диспетчер вебхуков.
+type EventPublisher interface {
+	Publish(userID, eventType string, link webhooks.LinkData)
+}
+
+// несколько получателей событий, например вебхуки и поток SSE.
+type Publishers []EventPublisher
+
+// разослать событие всем получателям.
+func (ps Publishers) Publish(userID, eventType string, link webhooks.LinkData) {
+	for _, p := range ps {
+		p.Publish(userID, eventType, link)
+	}
+}
+
+// данные ссылки для события.
+func linkData(baseURL *BaseURL, record storage.ShortURLRecord) webhooks.LinkData {
+	return webhooks.LinkData{
+		ShortCode:   record.ShortCode,
+		ShortURL:    baseURL.ShortURL(record.ShortCode),
+		OriginalURL: record.OriginalURL,
+		TeamID:      record.TeamID,
+	}
+}
+
+// сообщить о новой ссылке ее владельцу.
+func (sh *ShortenHandler) publishCreated(record storage.ShortURLRecord) {
+	if sh.events != nil {
+		sh.events.Publish(record.UserID, webhooks.EventLinkCreated, linkData(sh.baseURL, record))
+	}
+}
+
+// сообщать о переходах по ссылкам.
+func WithRedirectEvents(p EventPublisher) RedirectOption {
+	return func(rh *RedirectHandler) {
+		rh.events = p
+	}
+}
+
+// сообщить владельцу ссылки о переходе.
+func (rh *RedirectHandler) publishClick(record storage.ShortURLRecord, variant string) {
+	if rh.events == nil {
+		return
+	}
+	link := linkData(rh.baseURL, record)
+	link.Variant = variant
+	rh.events.Publish(record.UserID, webhooks.EventLinkClicked, link)
+}
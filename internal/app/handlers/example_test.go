@@ -8,15 +8,11 @@ import (
 	"net/http/httptest"
 
 	"github.com/buharamanya/shortener/internal/app/auth"
-	"github.com/buharamanya/shortener/internal/app/config"
 	"github.com/buharamanya/shortener/internal/app/storage"
 )
 
 // ExampleAPIFetchUserURLsHandler демонстрирует использование APIFetchUserURLsHandler
 func ExampleAPIFetchUserURLsHandler() {
-	// Настройка базового URL для редиректов
-	config.AppParams.RedirectBaseURL = "http://localhost:8080"
-
 	// Создаем мок хранилища с тестовыми данными
 	mockStorage := &ExampleStorage{
 		Records: []storage.ShortURLRecord{
@@ -38,8 +34,8 @@ func ExampleAPIFetchUserURLsHandler() {
 		},
 	}
 
-	// Создаем обработчик
-	handler := APIFetchUserURLsHandler(mockStorage)
+	// Создаем обработчик с базовым URL для коротких ссылок
+	handler := APIFetchUserURLsHandler(mockStorage, NewBaseURL("http://localhost:8080"))
 
 	// Создаем тестовый HTTP запрос
 	req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
@@ -3,9 +3,9 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/buharamanya/shortener/internal/app/auth"
-	"github.com/buharamanya/shortener/internal/app/config"
 	"github.com/buharamanya/shortener/internal/app/logger"
 	"github.com/buharamanya/shortener/internal/app/storage"
 	"go.uber.org/zap"
@@ -16,11 +16,32 @@ type URLGetterByUserID interface {
 	GetURLsByUserID(userID string) ([]storage.ShortURLRecord, error)
 }
 
-// получить урлы.
-func APIFetchUserURLsHandler(s URLGetterByUserID) http.HandlerFunc {
+// поиск урлов пользака по тегу и тексту.
+type URLSearcher interface {
+	SearchURLsByUserID(userID string, filter storage.URLFilter) ([]storage.ShortURLRecord, error)
+}
+
+// получить урлы. Параметры tag и q фильтруют список, если хранилище умеет искать.
+func APIFetchUserURLsHandler(s URLGetterByUserID, baseURL *BaseURL) http.HandlerFunc {
+	searcher, _ := s.(URLSearcher)
 	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(auth.UserIDContextKey).(string)
+		filter := storage.URLFilter{
+			Tag:   strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag"))),
+			Query: strings.TrimSpace(r.URL.Query().Get("q")),
+		}
 
-		records, err := s.GetURLsByUserID(r.Context().Value(auth.UserIDContextKey).(string))
+		var records []storage.ShortURLRecord
+		var err error
+		if filter != (storage.URLFilter{}) {
+			if searcher == nil {
+				http.Error(w, "search is not supported", http.StatusNotImplemented)
+				return
+			}
+			records, err = searcher.SearchURLsByUserID(userID, filter)
+		} else {
+			records, err = s.GetURLsByUserID(userID)
+		}
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -37,8 +58,9 @@ func APIFetchUserURLsHandler(s URLGetterByUserID) http.HandlerFunc {
 
 		for _, v := range records {
 			resp = append(resp, UserURLsDataResponse{
-				ShortURL:    config.AppParams.RedirectBaseURL + "/" + v.ShortCode,
-				OriginalURL: v.OriginalURL,
+				ShortURL:     baseURL.ShortURL(v.ShortCode),
+				OriginalURL:  v.OriginalURL,
+				LinkMetadata: metadataOf(v),
 			})
 		}
 
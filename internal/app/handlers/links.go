@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/routing"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// хранилище для правки ссылок.
+type LinkStore interface {
+	GetRecord(shortCode string) (storage.ShortURLRecord, error)
+	UpdateRecord(record storage.ShortURLRecord) error
+	GetVariantClicks(shortCode string) (map[string]int, error)
+}
+
+// хэндлер настроек существующих ссылок.
+type LinkHandler struct {
+	storage   LinkStore
+	teams     TeamRoleGetter
+	shortener *ShortenHandler
+}
+
+// создать хэндлер. Адреса проверяются по тем же правилам, что и при сокращении.
+func NewLinkHandler(storage LinkStore, shortener *ShortenHandler) *LinkHandler {
+	teams, _ := storage.(TeamRoleGetter)
+	return &LinkHandler{
+		storage:   storage,
+		teams:     teams,
+		shortener: shortener,
+	}
+}
+
+// сохранить изменения. Ссылка без настроек не может совпасть с уже
+// существующей обычной ссылкой на тот же адрес.
+func (lh *LinkHandler) update(w http.ResponseWriter, record storage.ShortURLRecord) bool {
+	err := lh.storage.UpdateRecord(record)
+	var conflict *storage.ConflictError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &conflict):
+		http.Error(w, "a plain link to this URL already exists: "+lh.shortener.baseURL.ShortURL(conflict.ShortCode), http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to update link", zap.String("short_code", record.ShortCode), zap.Error(err))
+	}
+	return false
+}
+
+// заменить окно активности ссылки.
+func (lh *LinkHandler) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	record, ok := lh.editable(w, r)
+	if !ok {
+		return
+	}
+
+	var schedule storage.Schedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := lh.shortener.checkSchedule(&schedule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record.Schedule = schedule
+	if !lh.update(w, record) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record.Schedule)
+}
+
+// заменить настройки проброса параметров и пути.
+func (lh *LinkHandler) UpdateForwarding(w http.ResponseWriter, r *http.Request) {
+	record, ok := lh.editable(w, r)
+	if !ok {
+		return
+	}
+
+	var forwarding storage.Forwarding
+	if err := json.NewDecoder(r.Body).Decode(&forwarding); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := routing.ValidateForwarding(forwarding); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record.Forwarding = forwarding
+	if !lh.update(w, record) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record.Forwarding)
+}
+
+// сменить код редиректа ссылки.
+func (lh *LinkHandler) UpdateRedirect(w http.ResponseWriter, r *http.Request) {
+	record, ok := lh.editable(w, r)
+	if !ok {
+		return
+	}
+
+	var req RedirectSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.RedirectStatus != 0 && !IsRedirectStatus(req.RedirectStatus) {
+		http.Error(w, errUnsupportedStatus.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record.RedirectStatus = req.RedirectStatus
+	if !lh.update(w, record) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, req)
+}
+
+// заменить заголовок, теги и заметки ссылки.
+func (lh *LinkHandler) UpdateMetadata(w http.ResponseWriter, r *http.Request) {
+	record, ok := lh.editable(w, r)
+	if !ok {
+		return
+	}
+
+	var req LinkMetadata
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.apply(&record)
+	if !lh.update(w, record) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, metadataOf(record))
+}
+
+// правила перенаправления ссылки и переходы по вариантам.
+func (lh *LinkHandler) GetRules(w http.ResponseWriter, r *http.Request) {
+	record, ok := lh.editable(w, r)
+	if !ok {
+		return
+	}
+	lh.writeRules(w, record)
+}
+
+// заменить правила перенаправления ссылки.
+func (lh *LinkHandler) UpdateRules(w http.ResponseWriter, r *http.Request) {
+	record, ok := lh.editable(w, r)
+	if !ok {
+		return
+	}
+
+	var req RulesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := routing.Validate(req.Rules); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i, rule := range req.Rules {
+		dest, err := lh.shortener.normalize(rule.URL)
+		if err != nil {
+			http.Error(w, "rule "+rule.Variant+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Rules[i].URL = dest
+	}
+
+	record.Rules = req.Rules
+	if !lh.update(w, record) {
+		return
+	}
+
+	lh.writeRules(w, record)
+}
+
+func (lh *LinkHandler) writeRules(w http.ResponseWriter, record storage.ShortURLRecord) {
+	clicks, err := lh.storage.GetVariantClicks(record.ShortCode)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to get variant clicks", zap.String("short_code", record.ShortCode), zap.Error(err))
+		return
+	}
+
+	resp := RulesResponse{Rules: record.Rules, Clicks: clicks}
+	if resp.Rules == nil {
+		resp.Rules = []storage.RedirectRule{}
+	}
+	if resp.Clicks == nil {
+		resp.Clicks = map[string]int{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// найти ссылку из пути и проверить право ее менять, при отказе ответ уже записан.
+func (lh *LinkHandler) editable(w http.ResponseWriter, r *http.Request) (storage.ShortURLRecord, bool) {
+	record, err := lh.storage.GetRecord(chi.URLParam(r, "shortCode"))
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrDeleted) {
+		w.WriteHeader(http.StatusNotFound)
+		return record, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to get link", zap.Error(err))
+		return record, false
+	}
+
+	ok, err := hasAccess(lh.teams, record, userIDFromContext(r), storage.RoleEditor)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to check team role", zap.Error(err))
+		return record, false
+	}
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return record, false
+	}
+
+	return record, true
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLinkHandler_UpdateSchedule(t *testing.T) {
+	own := storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-1"}
+	team := storage.ShortURLRecord{ShortCode: "team12", OriginalURL: "https://example.com", UserID: "user-2", TeamID: "team-1"}
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		mockSetup      func(*storage.MockURLStorage)
+		expectedStatus int
+	}{
+		{
+			name: "Success:_Owner_sets_window",
+			path: "/api/user/urls/abc123/schedule",
+			body: `{"active_from":"2030-01-01T10:00:00Z","active_until":"2030-02-01T10:00:00Z","fallback_url":"HTTPS://Example.com/soon"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(own, nil)
+				m.On("UpdateRecord", mock.MatchedBy(func(r storage.ShortURLRecord) bool {
+					return r.ActiveFrom != nil && r.ActiveUntil != nil && r.FallbackURL == "https://example.com/soon"
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Fail:_Window_ends_before_start",
+			path: "/api/user/urls/abc123/schedule",
+			body: `{"active_from":"2030-02-01T10:00:00Z","active_until":"2030-01-01T10:00:00Z"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(own, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Fail:_Not_owner",
+			path: "/api/user/urls/abc123/schedule",
+			body: `{}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123", UserID: "user-2"}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "Success:_Team_editor_clears_window",
+			path: "/api/user/urls/team12/schedule",
+			body: `{}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "team12").Return(team, nil)
+				m.On("GetTeamRole", "team-1", "user-1").Return(storage.RoleEditor, nil)
+				m.On("UpdateRecord", team).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Fail:_Plain_link_already_exists",
+			path: "/api/user/urls/abc123/schedule",
+			body: `{}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(own, nil)
+				m.On("UpdateRecord", own).Return(&storage.ConflictError{ShortCode: "stored01"})
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name: "Fail:_Unknown_link",
+			path: "/api/user/urls/nope/schedule",
+			body: `{}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "nope").Return(storage.ShortURLRecord{}, storage.ErrNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			tt.mockSetup(mockStorage)
+
+			lh := NewLinkHandler(mockStorage, NewShortenHandler(mockStorage, NewBaseURL("http://localhost")))
+			r := chi.NewRouter()
+			r.Put("/api/user/urls/{shortCode}/schedule", lh.UpdateSchedule)
+
+			req := httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader(tt.body))
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "user-1")
+			rr := httptest.NewRecorder()
+
+			r.ServeHTTP(rr, req.WithContext(ctx))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Ошибка: некорректный статуса ответа")
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLinkHandler_Rules(t *testing.T) {
+	own := storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-1"}
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		mockSetup      func(*storage.MockURLStorage)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:   "Success:_Get_rules_with_clicks",
+			method: http.MethodGet,
+			mockSetup: func(m *storage.MockURLStorage) {
+				rec := own
+				rec.Rules = []storage.RedirectRule{{Variant: "b", Weight: 50, URL: "https://example.com/b"}}
+				m.On("GetRecord", "abc123").Return(rec, nil)
+				m.On("GetVariantClicks", "abc123").Return(map[string]int{"default": 3, "b": 2}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"clicks":{"b":2,"default":3}`,
+		},
+		{
+			name:   "Success:_Replace_rules",
+			method: http.MethodPut,
+			body:   `{"rules":[{"variant":"ios","platforms":["iOS"],"url":"HTTPS://Apps.Apple.com/app"}]}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(own, nil)
+				m.On("UpdateRecord", mock.MatchedBy(func(r storage.ShortURLRecord) bool {
+					return len(r.Rules) == 1 && r.Rules[0].URL == "https://apps.apple.com/app" && r.Rules[0].Platforms[0] == "ios"
+				})).Return(nil)
+				m.On("GetVariantClicks", "abc123").Return(map[string]int{}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"variant":"ios"`,
+		},
+		{
+			name:   "Fail:_Invalid_rule",
+			method: http.MethodPut,
+			body:   `{"rules":[{"variant":"a","url":"https://example.com/a"}]}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(own, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Fail:_Invalid_destination",
+			method: http.MethodPut,
+			body:   `{"rules":[{"variant":"a","weight":10,"url":"javascript:alert(1)"}]}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(own, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			tt.mockSetup(mockStorage)
+
+			lh := NewLinkHandler(mockStorage, NewShortenHandler(mockStorage, NewBaseURL("http://localhost")))
+			r := chi.NewRouter()
+			r.Get("/api/user/urls/{shortCode}/rules", lh.GetRules)
+			r.Put("/api/user/urls/{shortCode}/rules", lh.UpdateRules)
+
+			req := httptest.NewRequest(tt.method, "/api/user/urls/abc123/rules", strings.NewReader(tt.body))
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "user-1")
+			rr := httptest.NewRecorder()
+
+			r.ServeHTTP(rr, req.WithContext(ctx))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Ошибка: некорректный статуса ответа")
+			assert.Contains(t, rr.Body.String(), tt.expectedBody)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
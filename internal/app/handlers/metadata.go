@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+)
+
+// ограничения описания ссылки.
+const (
+	maxTitleLength = 200
+	maxNotesLength = 2000
+	maxTags        = 20
+	maxTagLength   = 50
+)
+
+// дто описания ссылки: заголовок, теги и заметки.
+type LinkMetadata struct {
+	Title string   `json:"title,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Notes string   `json:"notes,omitempty"`
+}
+
+// проверить описание. Теги приводятся к нижнему регистру, повторы выкидываются.
+func (m *LinkMetadata) normalize() error {
+	m.Title = strings.TrimSpace(m.Title)
+	if utf8.RuneCountInString(m.Title) > maxTitleLength {
+		return fmt.Errorf("title is longer than %d characters", maxTitleLength)
+	}
+	m.Notes = strings.TrimSpace(m.Notes)
+	if utf8.RuneCountInString(m.Notes) > maxNotesLength {
+		return fmt.Errorf("notes are longer than %d characters", maxNotesLength)
+	}
+
+	var tags []string
+	for _, tag := range m.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return errors.New("tag must not be empty")
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTags {
+		return fmt.Errorf("link can have at most %d tags", maxTags)
+	}
+	slices.Sort(tags)
+	m.Tags = tags
+	return nil
+}
+
+// перенести описание в запись.
+func (m LinkMetadata) apply(record *storage.ShortURLRecord) {
+	record.Title = m.Title
+	record.Tags = m.Tags
+	record.Notes = m.Notes
+}
+
+// описание из записи.
+func metadataOf(record storage.ShortURLRecord) LinkMetadata {
+	return LinkMetadata{Title: record.Title, Tags: record.Tags, Notes: record.Notes}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkHandler_UpdateMetadata(t *testing.T) {
+	own := storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-1"}
+
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(*storage.MockURLStorage)
+		expectedStatus int
+		expected       LinkMetadata
+	}{
+		{
+			name: "Success:_Tags_normalized",
+			body: `{"title":"  Отчет ","tags":["Reports","q1","reports"," Q1 "],"notes":"для совета директоров"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(own, nil)
+				m.On("UpdateRecord", mock.MatchedBy(func(r storage.ShortURLRecord) bool {
+					return r.Title == "Отчет" && strings.Join(r.Tags, ",") == "q1,reports" && r.Notes == "для совета директоров"
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expected:       LinkMetadata{Title: "Отчет", Tags: []string{"q1", "reports"}, Notes: "для совета директоров"},
+		},
+		{
+			name: "Success:_Clear_metadata",
+			body: `{}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				rec := own
+				rec.Title, rec.Tags, rec.Notes = "Старое", []string{"old"}, "старое"
+				m.On("GetRecord", "abc123").Return(rec, nil)
+				m.On("UpdateRecord", own).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Fail:_Empty_tag",
+			body: `{"tags":["ok","  "]}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(own, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Fail:_Tag_too_long",
+			body: `{"tags":["` + strings.Repeat("a", maxTagLength+1) + `"]}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(own, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Fail:_Not_owner",
+			body: `{"title":"чужое"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123", UserID: "user-2"}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			tt.mockSetup(mockStorage)
+
+			lh := NewLinkHandler(mockStorage, NewShortenHandler(mockStorage, NewBaseURL("http://localhost")))
+			r := chi.NewRouter()
+			r.Put("/api/user/urls/{shortCode}/metadata", lh.UpdateMetadata)
+
+			req := httptest.NewRequest(http.MethodPut, "/api/user/urls/abc123/metadata", strings.NewReader(tt.body))
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "user-1")
+			rr := httptest.NewRecorder()
+
+			r.ServeHTTP(rr, req.WithContext(ctx))
+
+			require.Equal(t, tt.expectedStatus, rr.Code, "Ошибка: некорректный статуса ответа")
+			if tt.expectedStatus == http.StatusOK {
+				var got LinkMetadata
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+				assert.Equal(t, tt.expected, got)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAPIFetchUserURLsHandler_Filter(t *testing.T) {
+	tagged := storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", Title: "Отчет", Tags: []string{"reports"}}
+
+	tests := []struct {
+		name      string
+		query     string
+		mockSetup func(*storage.MockURLStorage)
+	}{
+		{
+			name:  "No_filter",
+			query: "",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetURLsByUserID", "user-1").Return([]storage.ShortURLRecord{tagged}, nil)
+			},
+		},
+		{
+			name:  "By_tag",
+			query: "?tag=Reports",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("SearchURLsByUserID", "user-1", storage.URLFilter{Tag: "reports"}).Return([]storage.ShortURLRecord{tagged}, nil)
+			},
+		},
+		{
+			name:  "By_text_and_tag",
+			query: "?tag=reports&q=%D0%BE%D1%82%D1%87%D0%B5%D1%82",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("SearchURLsByUserID", "user-1", storage.URLFilter{Tag: "reports", Query: "отчет"}).Return([]storage.ShortURLRecord{tagged}, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			tt.mockSetup(mockStorage)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls"+tt.query, nil)
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "user-1")
+			rr := httptest.NewRecorder()
+
+			APIFetchUserURLsHandler(mockStorage, NewBaseURL("http://localhost")).ServeHTTP(rr, req.WithContext(ctx))
+
+			require.Equal(t, http.StatusOK, rr.Code)
+			var resp []UserURLsDataResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Len(t, resp, 1)
+			assert.Equal(t, "http://localhost/"+tagged.ShortCode, resp[0].ShortURL)
+			assert.Equal(t, "Отчет", resp[0].Title)
+			assert.Equal(t, []string{"reports"}, resp[0].Tags)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"go.uber.org/zap"
+)
+
+// страница-предупреждение вместо редиректа на заблокированный адрес.
+var blockedPage = template.Must(template.New("blocked").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Ссылка заблокирована</title></head>
+<body>
+<h1>Переход заблокирован</h1>
+<p>Короткая ссылка ведет на адрес, который признан опасным (фишинг или вредоносное ПО).</p>
+<p>Адрес назначения: <code>{{.Host}}</code></p>
+</body>
+</html>
+`))
+
+// форма ввода пароля защищенной ссылки.
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Ссылка защищена паролем</title></head>
+<body>
+<h1>Ссылка защищена паролем</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post">
+<label>Пароль: <input type="password" name="password" autofocus required></label>
+<button type="submit">Перейти</button>
+</form>
+</body>
+</html>
+`))
+
+// заглушка для ссылки вне окна активности. Шаблон можно заменить своим,
+// ему передаются Pending, ActiveFrom и ActiveUntil.
+var inactivePage = template.Must(template.New("inactive").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Ссылка недоступна</title></head>
+<body>
+{{if .Pending}}
+<h1>Ссылка пока не активна</h1>
+{{with .ActiveFrom}}<p>Переход станет доступен {{.Format "02.01.2006 15:04 MST"}}.</p>{{end}}
+{{else}}
+<h1>Срок действия ссылки истек</h1>
+{{end}}
+</body>
+</html>
+`))
+
+// предпросмотр ссылки: куда она ведет, без редиректа.
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Предпросмотр ссылки</title></head>
+<body>
+<h1>{{if .Title}}{{.Title}}{{else}}Куда ведет ссылка{{end}}</h1>
+<p>Короткая ссылка: <code>{{.ShortURL}}</code></p>
+{{if .Protected}}
+<p>Ссылка защищена паролем, адрес назначения скрыт.</p>
+{{else if .Limited}}
+<p>Число переходов по ссылке ограничено, адрес назначения скрыт.</p>
+{{else}}
+<p>Адрес назначения: <code>{{.OriginalURL}}</code></p>
+{{end}}
+{{if .Conditional}}<p>Адрес может зависеть от устройства, языка или страны.</p>{{end}}
+{{if .Blocked}}<p role="alert">Адрес назначения признан опасным, переход заблокирован.</p>{{end}}
+{{if not .Active}}<p>Сейчас ссылка не активна.</p>{{end}}
+{{with .CreatedAt}}<p>Создана: {{.Format "02.01.2006 15:04 MST"}}</p>{{end}}
+<p>Переходов: {{.Clicks}}</p>
+{{if and .Active (not .Blocked)}}<p><a href="{{.ShortURL}}" rel="nofollow">Перейти</a></p>{{end}}
+</body>
+</html>
+`))
+
+// отрисовать html страницу.
+func renderPage(w http.ResponseWriter, status int, page *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := page.Execute(w, data); err != nil {
+		logger.Log.Error("failed to render page", zap.String("page", page.Name()), zap.Error(err))
+	}
+}
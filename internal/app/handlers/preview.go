@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// дто сведений о ссылке для предпросмотра.
+type LinkPreview struct {
+	ShortURL string `json:"short_url"`
+	// у ссылок с паролем и с лимитом переходов адрес не раскрывается
+	OriginalURL string     `json:"original_url,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	Clicks      int        `json:"clicks"`
+	Protected   bool       `json:"protected"`
+	Limited     bool       `json:"limited"`
+	Blocked     bool       `json:"blocked"`
+	Active      bool       `json:"active"`
+	// адрес зависит от устройства, языка или страны посетителя
+	Conditional bool `json:"conditional"`
+}
+
+// собрать сведения о ссылке.
+func (rh *RedirectHandler) preview(record storage.ShortURLRecord) LinkPreview {
+	p := LinkPreview{
+		ShortURL:    rh.baseURL.ShortURL(record.ShortCode),
+		Title:       record.Title,
+		Clicks:      record.Clicks,
+		Protected:   record.PasswordHash != "",
+		Limited:     record.MaxClicks > 0,
+		Active:      record.State(time.Now()) == storage.ScheduleActive,
+		Conditional: len(record.Rules) > 0,
+	}
+	if !p.Protected && !p.Limited {
+		p.OriginalURL = record.OriginalURL
+	}
+	if !record.CreatedAt.IsZero() {
+		p.CreatedAt = &record.CreatedAt
+	}
+	if rh.screener != nil {
+		p.Blocked = rh.screener.Check(record.OriginalURL).Blocked
+	}
+	return p
+}
+
+// сведения о ссылке в json, без редиректа и без учета перехода.
+func (rh *RedirectHandler) LinkInfo(w http.ResponseWriter, r *http.Request) {
+	shortCode := chi.URLParam(r, "shortCode")
+
+	record, err := rh.storage.GetRecord(shortCode)
+	switch {
+	case errors.Is(err, storage.ErrDeleted):
+		w.WriteHeader(http.StatusGone)
+		return
+	case errors.Is(err, storage.ErrNotFound):
+		http.Error(w, "short URL not found", http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Log.Error("failed to get link", zap.String("short_code", shortCode), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, rh.preview(record))
+}
+
+// html страница предпросмотра вместо редиректа.
+func (rh *RedirectHandler) servePreview(w http.ResponseWriter, record storage.ShortURLRecord) {
+	renderPage(w, http.StatusOK, previewPage, rh.preview(record))
+}
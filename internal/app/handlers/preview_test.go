@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectByShortURL_Preview(t *testing.T) {
+	record := storage.ShortURLRecord{
+		ShortCode:   "abc123",
+		OriginalURL: "https://example.com/report",
+		Title:       "Квартальный отчет",
+		Clicks:      7,
+		CreatedAt:   time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	for _, path := range []string{"/abc123+", "/abc123?preview=1"} {
+		t.Run(path, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			mockStorage.On("GetRecord", "abc123").Return(record, nil)
+
+			rr := httptest.NewRecorder()
+			NewRedirectHandler(mockStorage, WithBaseURL(NewBaseURL("http://localhost"))).RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Empty(t, rr.Header().Get("Location"))
+			body := rr.Body.String()
+			assert.Contains(t, body, "https://example.com/report")
+			assert.Contains(t, body, "Квартальный отчет")
+			assert.Contains(t, body, "01.03.2025")
+			assert.Contains(t, body, "Переходов: 7")
+			// предпросмотр не считается переходом
+			mockStorage.AssertNotCalled(t, "RegisterClick", "abc123", "default")
+		})
+	}
+}
+
+func TestLinkInfo(t *testing.T) {
+	hash := "$2a$10$abcdefghijklmnopqrstuv"
+	tests := []struct {
+		name           string
+		record         storage.ShortURLRecord
+		err            error
+		expectedStatus int
+		check          func(t *testing.T, p LinkPreview)
+	}{
+		{
+			name:           "open_link",
+			record:         storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", Title: "Пример", Clicks: 3},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, p LinkPreview) {
+				assert.Equal(t, "http://localhost/abc123", p.ShortURL)
+				assert.Equal(t, "https://example.com", p.OriginalURL)
+				assert.Equal(t, "Пример", p.Title)
+				assert.Equal(t, 3, p.Clicks)
+				assert.True(t, p.Active)
+				assert.False(t, p.Protected)
+			},
+		},
+		{
+			name:           "protected_link_hides_destination",
+			record:         storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com/secret", PasswordHash: hash},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, p LinkPreview) {
+				assert.True(t, p.Protected)
+				assert.Empty(t, p.OriginalURL)
+			},
+		},
+		{
+			name:           "limited_link_hides_destination",
+			record:         storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com/invite", MaxClicks: 1},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, p LinkPreview) {
+				assert.True(t, p.Limited)
+				assert.Empty(t, p.OriginalURL)
+			},
+		},
+		{
+			name:           "deleted",
+			err:            storage.ErrDeleted,
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:           "unknown",
+			err:            storage.ErrNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			mockStorage.On("GetRecord", "abc123").Return(tt.record, tt.err)
+
+			rh := NewRedirectHandler(mockStorage, WithBaseURL(NewBaseURL("http://localhost")))
+			r := chi.NewRouter()
+			r.Get("/api/urls/{shortCode}", rh.LinkInfo)
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/urls/abc123", nil))
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.check != nil {
+				var p LinkPreview
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+				tt.check(t, p)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/ratelimit"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// сколько помнить ввод пароля, если не задано.
+	defaultUnlockTTL = time.Hour
+	// префикс cookie, подтверждающей ввод пароля ссылки.
+	unlockCookiePrefix = "unlock_"
+	// метка ключа подписи cookie, выводимого из общего секрета.
+	unlockKeyLabel = "shortener unlock cookie v1"
+)
+
+// попытки ввода пароля: 5 подряд, дальше одна в 12 секунд на ссылку и IP.
+var unlockAttemptLimit = ratelimit.Limit{Rate: 1.0 / 12, Burst: 5}
+
+// защита ссылок паролем.
+type unlocker struct {
+	secret   []byte
+	ttl      time.Duration
+	secure   bool
+	trusted  []*net.IPNet
+	attempts *ratelimit.Store
+	now      func() time.Time
+}
+
+// по умолчанию ключ подписи случайный: после перезапуска пароль придется ввести заново.
+func newUnlocker() *unlocker {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		logger.Log.Fatal("failed to generate unlock secret", zap.Error(err))
+	}
+	return &unlocker{
+		secret:   secret,
+		ttl:      defaultUnlockTTL,
+		attempts: ratelimit.NewStore(time.Hour, 100000),
+		now:      time.Now,
+	}
+}
+
+// запоминать ввод пароля в cookie на ttl. Ключ подписи выводится из secret через HKDF,
+// так что подпись cookie не подходит для токенов, подписанных тем же секретом.
+func WithUnlockCookie(secret string, ttl time.Duration) RedirectOption {
+	return func(rh *RedirectHandler) {
+		if secret != "" {
+			rh.unlock.secret = deriveUnlockKey(secret)
+		}
+		if ttl > 0 {
+			rh.unlock.ttl = ttl
+		}
+	}
+}
+
+// отдавать cookie ввода пароля только по HTTPS.
+func WithSecureUnlockCookie(secure bool) RedirectOption {
+	return func(rh *RedirectHandler) {
+		rh.unlock.secure = secure
+	}
+}
+
+// ключ подписи cookie из общего секрета.
+func deriveUnlockKey(secret string) []byte {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte(unlockKeyLabel)), key); err != nil {
+		logger.Log.Fatal("failed to derive unlock key", zap.Error(err))
+	}
+	return key
+}
+
+// считать попытки ввода пароля в store, которое чистится по расписанию. Хранилище
+// не стоит делить с лимитером запросов: его ведра вытесняют счетчики попыток.
+// Без опции у хэндлера свое хранилище, его держит в рамках только ограничение на число ведер.
+func WithUnlockAttemptStore(store *ratelimit.Store) RedirectOption {
+	return func(rh *RedirectHandler) {
+		if store != nil {
+			rh.unlock.attempts = store
+		}
+	}
+}
+
+// доверенные прокси для определения IP при подсчете попыток ввода пароля.
+func WithTrustedProxies(trusted []*net.IPNet) RedirectOption {
+	return func(rh *RedirectHandler) {
+		rh.unlock.trusted = trusted
+	}
+}
+
+// подпись cookie. В нее входит хэш пароля, так что смена пароля сбрасывает доступ.
+func (u *unlocker) sign(record storage.ShortURLRecord, expires int64) string {
+	mac := hmac.New(sha256.New, u.secret)
+	mac.Write([]byte(record.ShortCode + "|" + strconv.FormatInt(expires, 10) + "|" + record.PasswordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// введен ли уже пароль ссылки.
+func (u *unlocker) unlocked(r *http.Request, record storage.ShortURLRecord) bool {
+	cookie, err := r.Cookie(unlockCookiePrefix + record.ShortCode)
+	if err != nil {
+		return false
+	}
+
+	expStr, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || u.now().Unix() >= expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(u.sign(record, expires)))
+}
+
+// запомнить ввод пароля.
+func (u *unlocker) setCookie(w http.ResponseWriter, record storage.ShortURLRecord) {
+	expiresAt := u.now().Add(u.ttl)
+	expires := expiresAt.Unix()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookiePrefix + record.ShortCode,
+		Value:    strconv.FormatInt(expires, 10) + "." + u.sign(record, expires),
+		Path:     "/" + record.ShortCode,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   u.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// данные формы ввода пароля.
+type passwordFormData struct {
+	Error string
+}
+
+// форма ввода пароля: GET показывает ее, POST проверяет пароль.
+// Возвращает true, если пароль верный и можно делать редирект.
+func (u *unlocker) serveForm(w http.ResponseWriter, r *http.Request, record storage.ShortURLRecord) bool {
+	var data passwordFormData
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		renderPage(w, http.StatusOK, passwordPage, data)
+		return false
+	case http.MethodPost:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+
+	res := u.attempts.Take("unlock|"+record.ShortCode+"|"+ratelimit.ClientIP(r, u.trusted), unlockAttemptLimit)
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(res.RetryAfter.Seconds())+1))
+		data.Error = "Слишком много попыток, попробуйте позже."
+		renderPage(w, http.StatusTooManyRequests, passwordPage, data)
+		return false
+	}
+
+	if !auth.CheckPassword(record.PasswordHash, r.PostFormValue("password")) {
+		logger.Log.Info("wrong link password", zap.String("short_code", record.ShortCode))
+		data.Error = "Неверный пароль."
+		renderPage(w, http.StatusForbidden, passwordPage, data)
+		return false
+	}
+
+	u.setCookie(w, record)
+	return true
+}
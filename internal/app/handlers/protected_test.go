@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/ratelimit"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func protectedRecord(t *testing.T) storage.ShortURLRecord {
+	hash, err := auth.HashPassword("s3cret")
+	require.NoError(t, err)
+	return storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com/doc", PasswordHash: hash}
+}
+
+func postPassword(handler *RedirectHandler, password string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/abc123", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr := httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, req)
+	return rr
+}
+
+func TestRedirectByShortURL_Protected(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(protectedRecord(t), nil)
+	mockStorage.On("RegisterClick", "abc123", "default").Return(nil).Maybe()
+	handler := NewRedirectHandler(mockStorage, WithUnlockCookie("test-secret", time.Minute))
+
+	// без пароля отдается форма
+	rr := httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Location"))
+	assert.Contains(t, rr.Body.String(), `type="password"`)
+
+	// неверный пароль
+	rr = postPassword(handler, "wrong")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Неверный пароль")
+
+	// верный пароль ведет на адрес назначения и ставит cookie
+	rr = postPassword(handler, "s3cret")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "https://example.com/doc", rr.Header().Get("Location"))
+
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "/abc123", cookies[0].Path)
+
+	// с cookie редирект сразу
+	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	assert.Equal(t, "https://example.com/doc", rr.Header().Get("Location"))
+
+	// подделанная подпись не принимается
+	forged := *cookies[0]
+	forged.Value = strings.Split(forged.Value, ".")[0] + ".forged"
+	req = httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	req.AddCookie(&forged)
+	rr = httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestRedirectByShortURL_ProtectedCookieExpires(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(protectedRecord(t), nil)
+	mockStorage.On("RegisterClick", "abc123", "default").Return(nil).Maybe()
+	handler := NewRedirectHandler(mockStorage, WithUnlockCookie("test-secret", time.Minute))
+
+	rr := postPassword(handler, "s3cret")
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	cookie := rr.Result().Cookies()[0]
+
+	handler.unlock.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Location"))
+}
+
+func TestRedirectByShortURL_ProtectedCookieSecure(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(protectedRecord(t), nil)
+	mockStorage.On("RegisterClick", "abc123", "default").Return(nil).Maybe()
+	handler := NewRedirectHandler(mockStorage, WithUnlockCookie("test-secret", time.Minute), WithSecureUnlockCookie(true))
+
+	rr := postPassword(handler, "s3cret")
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].Secure)
+
+	// cookie подписывается не самим секретом токенов, а выведенным из него ключом
+	assert.NotEqual(t, []byte("test-secret"), handler.unlock.secret)
+	assert.Len(t, handler.unlock.secret, 32)
+}
+
+func TestRedirectByShortURL_ProtectedAttemptsLimited(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(protectedRecord(t), nil)
+	mockStorage.On("RegisterClick", "abc123", "default").Return(nil).Maybe()
+	handler := NewRedirectHandler(mockStorage)
+
+	for i := 0; i < unlockAttemptLimit.Burst; i++ {
+		rr := postPassword(handler, "wrong")
+		require.Equal(t, http.StatusForbidden, rr.Code)
+	}
+
+	// даже верный пароль не проверяется, пока попытки не восстановятся
+	rr := postPassword(handler, "s3cret")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.Empty(t, rr.Header().Get("Location"))
+}
+
+func TestRedirectByShortURL_ProtectedAttemptsSharedStore(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(protectedRecord(t), nil)
+	store := ratelimit.NewStore(time.Minute, 0)
+	handler := NewRedirectHandler(mockStorage, WithUnlockAttemptStore(store))
+
+	rr := postPassword(handler, "wrong")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+
+	// попытка учтена в общем хранилище
+	assert.Equal(t, 1, store.Len())
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/qrcode"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// сколько клиенту хранить картинку: адрес короткой ссылки не меняется.
+const qrCacheMaxAge = 24 * 60 * 60
+
+// etag картинки. Картинка зависит только от адреса и параметров, так что рисовать ее для проверки не нужно.
+func qrETag(text string, o qrcode.Options) string {
+	sum := sha256.Sum256([]byte(text + "|" + o.Format + "|" + strconv.Itoa(o.Size) + "|" + o.Level))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// совпадает ли etag из If-None-Match.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, v := range r.Header.Values("If-None-Match") {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || tag == etag || tag == "W/"+etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// QR-код короткой ссылки.
+func (rh *RedirectHandler) QRCode(w http.ResponseWriter, r *http.Request) {
+	shortCode := chi.URLParam(r, "shortCode")
+
+	q := r.URL.Query()
+	o, err := qrcode.ParseOptions(q.Get("format"), q.Get("size"), q.Get("ecc"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = rh.storage.GetRecord(shortCode)
+	switch {
+	case errors.Is(err, storage.ErrDeleted):
+		w.WriteHeader(http.StatusGone)
+		return
+	case errors.Is(err, storage.ErrNotFound):
+		http.Error(w, "short URL not found", http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Log.Error("failed to get link", zap.String("short_code", shortCode), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	text := rh.baseURL.ShortURL(shortCode)
+	etag := qrETag(text, o)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(qrCacheMaxAge))
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	img, err := qrcode.Render(text, o)
+	if errors.Is(err, qrcode.ErrSize) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("failed to render QR code", zap.String("short_code", shortCode), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", o.ContentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(img)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(img)
+	}
+}
+
+// QR-код в data URI для ответа на сокращение.
+func qrDataURI(text string) (string, error) {
+	o, _ := qrcode.ParseOptions("", "", "")
+	img, err := qrcode.Render(text, o)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + o.ContentType() + ";base64," + base64.StdEncoding.EncodeToString(img), nil
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQRCode(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		err            error
+		expectedStatus int
+		contentType    string
+	}{
+		{"png_by_default", "", nil, http.StatusOK, "image/png"},
+		{"svg", "?format=svg&size=128&ecc=H", nil, http.StatusOK, "image/svg+xml"},
+		{"bad_format", "?format=gif", nil, http.StatusBadRequest, ""},
+		{"bad_size", "?size=1", nil, http.StatusBadRequest, ""},
+		{"bad_ecc", "?ecc=Z", nil, http.StatusBadRequest, ""},
+		{"deleted", "", storage.ErrDeleted, http.StatusGone, ""},
+		{"unknown", "", storage.ErrNotFound, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			mockStorage.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123"}, tt.err).Maybe()
+
+			rh := NewRedirectHandler(mockStorage, WithBaseURL(NewBaseURL("http://localhost")))
+			r := chi.NewRouter()
+			r.Get("/api/urls/{shortCode}/qr", rh.QRCode)
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/urls/abc123/qr"+tt.query, nil))
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, rr.Header().Get("Content-Type"))
+				assert.NotEmpty(t, rr.Header().Get("ETag"))
+				assert.NotEmpty(t, rr.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestQRCode_Gzip(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123"}, nil)
+
+	rh := NewRedirectHandler(mockStorage, WithBaseURL(NewBaseURL("http://localhost")))
+	r := chi.NewRouter()
+	r.Use(WithGzipMiddleware)
+	r.Get("/api/urls/{shortCode}/qr", rh.QRCode)
+	// настоящий сервер проверяет, что тело совпадает с Content-Length
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/urls/abc123/qr", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	_, err = png.Decode(bytes.NewReader(body))
+	assert.NoError(t, err)
+}
+
+func TestQRCode_ETag(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123"}, nil)
+
+	rh := NewRedirectHandler(mockStorage, WithBaseURL(NewBaseURL("http://localhost")))
+	r := chi.NewRouter()
+	r.Get("/api/urls/{shortCode}/qr", rh.QRCode)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/urls/abc123/qr?size=300", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+	etag := rr.Header().Get("ETag")
+
+	// тот же etag - картинка не передается
+	req := httptest.NewRequest(http.MethodGet, "/api/urls/abc123/qr?size=300", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.Bytes())
+
+	// другие параметры - другая картинка
+	req = httptest.NewRequest(http.MethodGet, "/api/urls/abc123/qr?size=400", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+}
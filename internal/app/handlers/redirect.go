@@ -2,54 +2,352 @@ package handlers
 
 import (
 	"errors"
+	"html/template"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/routing"
+	"github.com/buharamanya/shortener/internal/app/screening"
 	"github.com/buharamanya/shortener/internal/app/storage"
+	"go.uber.org/zap"
 )
 
 // получатель.
 type URLGetter interface {
-	Get(shortCode string) (string, error)
+	GetRecord(shortCode string) (storage.ShortURLRecord, error)
+	RegisterClick(shortCode, variant string) error
+}
+
+// накопитель переходов по ссылкам без лимита.
+type ClickCounter interface {
+	Add(shortCode, variant string)
+}
+
+// сколько кэшировать редиректы, если не задано.
+const defaultRedirectCacheTTL = 5 * time.Minute
+
+// проверяльщик адресов назначения по списку блокировок.
+type DestinationScreener interface {
+	Check(rawURL string) screening.Verdict
 }
 
 // тип хэндлер редиректор.
 type RedirectHandler struct {
-	storage URLGetter
+	storage      URLGetter
+	screener     DestinationScreener
+	unlock       *unlocker
+	inactive     *template.Template
+	notFoundPage *template.Template
+	geoHeader    string
+	status       int
+	cacheTTL     time.Duration
+	baseURL      *BaseURL
+	events       EventPublisher
+	clicks       ClickCounter
+}
+
+// опция редиректора.
+type RedirectOption func(rh *RedirectHandler)
+
+// перепроверять адрес назначения перед редиректом.
+func WithRedirectScreener(s DestinationScreener) RedirectOption {
+	return func(rh *RedirectHandler) {
+		rh.screener = s
+	}
+}
+
+// своя страница для ссылок вне окна активности.
+func WithInactivePage(page *template.Template) RedirectOption {
+	return func(rh *RedirectHandler) {
+		rh.inactive = page
+	}
+}
+
+// заголовок, в котором прокси передает страну клиента.
+func WithGeoHeader(header string) RedirectOption {
+	return func(rh *RedirectHandler) {
+		if header != "" {
+			rh.geoHeader = header
+		}
+	}
+}
+
+// код редиректа по умолчанию, недопустимый код игнорируется.
+func WithRedirectStatus(status int) RedirectOption {
+	return func(rh *RedirectHandler) {
+		if IsRedirectStatus(status) {
+			rh.status = status
+		}
+	}
+}
+
+// сколько кэшировать редиректы, 0 - не кэшировать.
+func WithCacheTTL(ttl time.Duration) RedirectOption {
+	return func(rh *RedirectHandler) {
+		rh.cacheTTL = ttl
+	}
+}
+
+// своя страница для неизвестных кодов.
+func WithNotFoundPage(page *template.Template) RedirectOption {
+	return func(rh *RedirectHandler) {
+		rh.notFoundPage = page
+	}
+}
+
+// базовый адрес коротких ссылок для предпросмотра.
+func WithBaseURL(baseURL *BaseURL) RedirectOption {
+	return func(rh *RedirectHandler) {
+		rh.baseURL = baseURL
+	}
+}
+
+// считать переходы по ссылкам без лимита через накопитель, а не записью на каждый редирект.
+func WithClickCounter(c ClickCounter) RedirectOption {
+	return func(rh *RedirectHandler) {
+		rh.clicks = c
+	}
 }
 
 // создать хэндлер редиректор.
-func NewRedirectHandler(storage URLGetter) *RedirectHandler {
-	return &RedirectHandler{
-		storage: storage,
+func NewRedirectHandler(storage URLGetter, opts ...RedirectOption) *RedirectHandler {
+	rh := &RedirectHandler{
+		storage:   storage,
+		unlock:    newUnlocker(),
+		inactive:  inactivePage,
+		geoHeader: routing.DefaultGeoHeader,
+		status:    http.StatusTemporaryRedirect,
+		cacheTTL:  defaultRedirectCacheTTL,
+	}
+	for _, opt := range opts {
+		opt(rh)
 	}
+	return rh
 }
 
-// редирект.
+// редирект. Для ссылки с паролем вместо редиректа отдается форма ввода,
+// POST формы принимается только такими ссылками. HEAD отвечает так же, как GET,
+// но переход не засчитывается, а для ссылок с лимитом переходов адрес не отдается. /{shortCode}+ и ?preview=1 показывают предпросмотр.
 func (rh *RedirectHandler) RedirectByShortURL(w http.ResponseWriter, r *http.Request) {
 	// проверяем метод запроса
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	shortCode := strings.TrimPrefix(r.URL.Path, "/")
+	// /{shortCode}/extra/path: подпуть берем экранированным, чтобы не потерять %2F
+	shortCode, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	_, extraPath, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+
+	preview := r.URL.Query().Get("preview") == "1"
+	if strings.HasSuffix(shortCode, "+") && extraPath == "" {
+		shortCode, preview = strings.TrimSuffix(shortCode, "+"), true
+	}
 
 	if shortCode == "" {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
 
-	originalURL, err := rh.storage.Get(shortCode)
+	record, err := rh.storage.GetRecord(shortCode)
 	if err != nil {
-		if errors.Is(err, storage.ErrDeleted) {
+		switch {
+		case errors.Is(err, storage.ErrDeleted):
 			w.WriteHeader(http.StatusGone)
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
+		case errors.Is(err, storage.ErrNotFound):
+			rh.notFound(w)
+		default:
+			logger.Log.Error("failed to get link", zap.String("short_code", shortCode), zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
 		}
 		return
 	}
 
-	w.Header().Set("Location", originalURL)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	if preview && r.Method != http.MethodPost {
+		rh.servePreview(w, record)
+		return
+	}
+
+	if rh.blocked(w, shortCode, record.OriginalURL) {
+		return
+	}
+
+	if state := record.State(time.Now()); state != storage.ScheduleActive {
+		rh.serveInactive(w, shortCode, record, state)
+		return
+	}
+
+	status := rh.statusFor(record)
+	if record.PasswordHash != "" && !rh.unlock.unlocked(r, record) {
+		if !rh.unlock.serveForm(w, r, record) {
+			return
+		}
+		// после формы браузер должен перейти GET запросом
+		status = http.StatusSeeOther
+	} else if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	dest, variant := record.OriginalURL, routing.DefaultVariant
+	if len(record.Rules) > 0 {
+		dest, variant = routing.Select(record.Rules, routing.FromHTTP(r, rh.geoHeader), record.OriginalURL, rand.IntN)
+		if dest != record.OriginalURL && rh.blocked(w, shortCode, dest) {
+			return
+		}
+	}
+
+	dest, err = routing.Forward(dest, record.Forwarding, r.URL.Query(), extraPath)
+	if errors.Is(err, routing.ErrPathNotAllowed) {
+		rh.notFound(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		// проверяльщики ссылок не должны тратить одноразовые переходы и не должны
+		// узнавать адрес, не потратив переход
+		if record.MaxClicks > 0 {
+			w.Header().Set("Cache-Control", "no-store")
+			if record.Clicks >= record.MaxClicks {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	} else {
+		if !rh.countClick(w, shortCode, record, variant) {
+			return
+		}
+		rh.publishClick(record, variant)
+	}
+
+	rh.setCacheHeaders(w, record, status)
+	w.Header().Set("Location", dest)
+	w.WriteHeader(status)
+}
+
+// засчитать переход. Лимит переходов проверяется атомарно в хранилище,
+// остальные переходы копятся в накопителе, если он задан.
+func (rh *RedirectHandler) countClick(w http.ResponseWriter, shortCode string, record storage.ShortURLRecord, variant string) bool {
+	if record.MaxClicks == 0 && rh.clicks != nil {
+		rh.clicks.Add(shortCode, variant)
+		return true
+	}
+
+	err := rh.storage.RegisterClick(shortCode, variant)
+	if errors.Is(err, storage.ErrExhausted) || errors.Is(err, storage.ErrDeleted) {
+		w.WriteHeader(http.StatusGone)
+		return false
+	}
+	if err != nil {
+		logger.Log.Error("failed to register click", zap.String("short_code", shortCode), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// код редиректа ссылки: свой или общий.
+func (rh *RedirectHandler) statusFor(record storage.ShortURLRecord) int {
+	if IsRedirectStatus(record.RedirectStatus) {
+		return record.RedirectStatus
+	}
+	return rh.status
+}
+
+// допустимый код редиректа.
+func IsRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// заголовки кэширования. Ссылки, ответ которых зависит от запроса или
+// от числа переходов, не кэшируются. Остальные кэшируются на cacheTTL,
+// но не дольше конца окна активности, и только в браузере: ссылку могут изменить
+// или удалить, а общий кэш прокси сбросить нельзя. Переходы из кэша в счетчик не попадают.
+func (rh *RedirectHandler) setCacheHeaders(w http.ResponseWriter, record storage.ShortURLRecord, status int) {
+	ttl := rh.cacheTTL
+	if record.ActiveUntil != nil {
+		if left := time.Until(*record.ActiveUntil); left < ttl {
+			ttl = left
+		}
+	}
+
+	uncacheable := record.PasswordHash != "" || record.MaxClicks > 0 || len(record.Rules) > 0 || status == http.StatusSeeOther
+	if uncacheable || ttl <= 0 {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+
+	seconds := int(ttl / time.Second)
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(seconds))
+	w.Header().Set("Expires", time.Now().Add(time.Duration(seconds)*time.Second).UTC().Format(http.TimeFormat))
+}
+
+// неизвестный код: своя страница, если задана.
+func (rh *RedirectHandler) notFound(w http.ResponseWriter) {
+	if rh.notFoundPage == nil {
+		http.Error(w, "short URL not found", http.StatusNotFound)
+		return
+	}
+	renderPage(w, http.StatusNotFound, rh.notFoundPage, nil)
+}
+
+// адрес в списке блокировок: показываем предупреждение вместо редиректа.
+func (rh *RedirectHandler) blocked(w http.ResponseWriter, shortCode, dest string) bool {
+	if rh.screener == nil {
+		return false
+	}
+	verdict := rh.screener.Check(dest)
+	if !verdict.Blocked {
+		return false
+	}
+
+	logger.Log.Warn("redirect to blocked destination",
+		zap.String("short_code", shortCode),
+		zap.String("rule", verdict.Rule),
+	)
+	var host string
+	if u, err := url.Parse(dest); err == nil {
+		host = u.Host
+	}
+	renderPage(w, http.StatusForbidden, blockedPage, struct{ Host string }{host})
+	return true
+}
+
+// ссылка вне окна активности: ведем на резервный адрес или показываем заглушку.
+// Резервный адрес проверяется по списку блокировок так же, как основной.
+func (rh *RedirectHandler) serveInactive(w http.ResponseWriter, shortCode string, record storage.ShortURLRecord, state storage.ScheduleState) {
+	if record.FallbackURL != "" {
+		if rh.blocked(w, shortCode, record.FallbackURL) {
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Location", record.FallbackURL)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	status := http.StatusGone
+	if state == storage.SchedulePending {
+		status = http.StatusNotFound
+	}
+
+	renderPage(w, status, rh.inactive, struct {
+		Pending bool
+		storage.Schedule
+	}{state == storage.SchedulePending, record.Schedule})
 }
@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"html/template"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/buharamanya/shortener/internal/app/screening"
 	"github.com/buharamanya/shortener/internal/app/storage"
 	"github.com/stretchr/testify/assert"
 )
@@ -23,7 +27,8 @@ func TestRedirectByShortURL(t *testing.T) {
 			method: http.MethodGet,
 			path:   "/abc123",
 			mockSetup: func(m *storage.MockURLStorage) {
-				m.On("Get", "abc123").Return("https://example.com", nil)
+				m.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com"}, nil)
+				m.On("RegisterClick", "abc123", "default").Return(nil)
 			},
 			expectedStatus: http.StatusTemporaryRedirect,
 			expectedHeader: "https://example.com",
@@ -41,16 +46,29 @@ func TestRedirectByShortURL(t *testing.T) {
 			method: http.MethodGet,
 			path:   "/invalid",
 			mockSetup: func(m *storage.MockURLStorage) {
-				m.On("Get", "invalid").Return("", storage.ErrNotFound)
+				m.On("GetRecord", "invalid").Return(storage.ShortURLRecord{}, storage.ErrNotFound)
 			},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusNotFound,
 			expectedHeader: "",
 		},
 		{
-			name:           "Fail:_Wrong_HTTP_method_(POST)",
-			method:         http.MethodPost,
-			path:           "/abc123",
-			mockSetup:      func(m *storage.MockURLStorage) {}, // Мок не должен вызываться
+			name:   "Success:_HEAD_request",
+			method: http.MethodHead,
+			path:   "/abc123",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com"}, nil)
+			},
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeader: "https://example.com",
+		},
+		{
+			name:   "Fail:_Wrong_HTTP_method_(POST)",
+			method: http.MethodPost,
+			path:   "/abc123",
+			mockSetup: func(m *storage.MockURLStorage) {
+				// POST принимается только ссылками с паролем
+				m.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com"}, nil)
+			},
 			expectedStatus: http.StatusMethodNotAllowed,
 			expectedHeader: "",
 		},
@@ -88,3 +106,313 @@ func TestRedirectByShortURL(t *testing.T) {
 		})
 	}
 }
+
+// стаб проверяльщика, блокирующий один хост.
+type stubScreener struct {
+	host string
+}
+
+func (s stubScreener) Check(rawURL string) screening.Verdict {
+	if strings.Contains(rawURL, "://"+s.host+"/") {
+		return screening.Verdict{Blocked: true, Rule: "domain " + s.host}
+	}
+	return screening.Verdict{}
+}
+
+func TestRedirectByShortURL_Blocked(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://evil.com/login"}, nil)
+
+	handler := NewRedirectHandler(mockStorage, WithRedirectScreener(stubScreener{host: "evil.com"}))
+
+	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	rr := httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, req)
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Empty(t, rr.Header().Get("Location"))
+	assert.Contains(t, rr.Body.String(), "evil.com")
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRedirectByShortURL_BlockedFallback(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(storage.ShortURLRecord{
+		ShortCode:   "abc123",
+		OriginalURL: "https://example.com/launch",
+		Schedule:    storage.Schedule{ActiveFrom: &future, FallbackURL: "https://evil.com/soon"},
+	}, nil)
+
+	handler := NewRedirectHandler(mockStorage, WithRedirectScreener(stubScreener{host: "evil.com"}))
+
+	rr := httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Empty(t, rr.Header().Get("Location"))
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRedirectByShortURL_MaxClicks(t *testing.T) {
+	record := storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", MaxClicks: 1}
+
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(record, nil)
+	mockStorage.On("RegisterClick", "abc123", "default").Return(nil).Once()
+	mockStorage.On("RegisterClick", "abc123", "default").Return(storage.ErrExhausted).Once()
+
+	handler := NewRedirectHandler(mockStorage)
+
+	rr := httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+	assert.Equal(t, http.StatusGone, rr.Code)
+	assert.Empty(t, rr.Header().Get("Location"))
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRedirectByShortURL_ClickCounter(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "plain1").Return(storage.ShortURLRecord{ShortCode: "plain1", OriginalURL: "https://example.com"}, nil)
+	mockStorage.On("GetRecord", "limit1").Return(storage.ShortURLRecord{ShortCode: "limit1", OriginalURL: "https://example.com", MaxClicks: 5}, nil)
+	mockStorage.On("RegisterClick", "limit1", "default").Return(nil).Once()
+	mockStorage.On("AddClicks", []storage.ClickCount{{ShortCode: "plain1", Variant: "default", Clicks: 2}}).Return(nil).Once()
+
+	batcher := storage.NewClickBatcher(mockStorage, time.Minute)
+	handler := NewRedirectHandler(mockStorage, WithClickCounter(batcher))
+
+	for _, path := range []string{"/plain1", "/plain1", "/limit1"} {
+		rr := httptest.NewRecorder()
+		handler.RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	}
+
+	// обычные переходы попадают в хранилище только при сбросе накопителя
+	mockStorage.AssertNotCalled(t, "RegisterClick", "plain1", "default")
+	assert.NoError(t, batcher.Flush())
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRedirectByShortURL_Schedule(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name           string
+		schedule       storage.Schedule
+		expectedStatus int
+		expectedHeader string
+	}{
+		{
+			name:           "active",
+			schedule:       storage.Schedule{ActiveFrom: &past, ActiveUntil: &future},
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeader: "https://example.com/launch",
+		},
+		{
+			name:           "pending",
+			schedule:       storage.Schedule{ActiveFrom: &future},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "ended",
+			schedule:       storage.Schedule{ActiveUntil: &past},
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:           "pending_with_fallback",
+			schedule:       storage.Schedule{ActiveFrom: &future, FallbackURL: "https://example.com/soon"},
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedHeader: "https://example.com/soon",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com/launch", Schedule: tt.schedule}
+			mockStorage := new(storage.MockURLStorage)
+			mockStorage.On("GetRecord", "abc123").Return(record, nil)
+			mockStorage.On("RegisterClick", "abc123", "default").Return(nil).Maybe()
+
+			rr := httptest.NewRecorder()
+			NewRedirectHandler(mockStorage).RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedHeader, rr.Header().Get("Location"))
+		})
+	}
+}
+
+func TestRedirectByShortURL_Rules(t *testing.T) {
+	record := storage.ShortURLRecord{
+		ShortCode:   "abc123",
+		OriginalURL: "https://example.com/",
+		Rules: []storage.RedirectRule{
+			{Variant: "ios", Platforms: []string{"ios"}, URL: "https://apps.apple.com/app"},
+		},
+	}
+
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(record, nil)
+	mockStorage.On("RegisterClick", "abc123", "ios").Return(nil).Once()
+	mockStorage.On("RegisterClick", "abc123", "default").Return(nil).Once()
+
+	handler := NewRedirectHandler(mockStorage)
+
+	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)")
+	rr := httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	assert.Equal(t, "https://apps.apple.com/app", rr.Header().Get("Location"))
+
+	rr = httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+	assert.Equal(t, "https://example.com/", rr.Header().Get("Location"))
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRedirectByShortURL_Forwarding(t *testing.T) {
+	record := storage.ShortURLRecord{
+		ShortCode:   "abc123",
+		OriginalURL: "https://example.com/docs",
+		Forwarding:  storage.Forwarding{ForwardQuery: true, ForwardPath: true},
+	}
+
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(record, nil)
+	mockStorage.On("RegisterClick", "abc123", "default").Return(nil).Maybe()
+	handler := NewRedirectHandler(mockStorage)
+
+	rr := httptest.NewRecorder()
+	handler.RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/abc123/guide/intro?utm_source=newsletter", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	assert.Equal(t, "https://example.com/docs/guide/intro?utm_source=newsletter", rr.Header().Get("Location"))
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRedirectByShortURL_SubPathNotForwarded(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com"}, nil)
+
+	rr := httptest.NewRecorder()
+	NewRedirectHandler(mockStorage).RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/abc123/extra?x=1", nil))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Empty(t, rr.Header().Get("Location"))
+}
+
+func TestRedirectByShortURL_HeadDoesNotCountClick(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", MaxClicks: 1}, nil)
+	mockStorage.On("GetRecord", "used01").Return(storage.ShortURLRecord{ShortCode: "used01", OriginalURL: "https://example.com", MaxClicks: 1, Clicks: 1}, nil)
+	mockStorage.On("GetRecord", "open01").Return(storage.ShortURLRecord{ShortCode: "open01", OriginalURL: "https://example.com"}, nil)
+
+	tests := []struct {
+		code          string
+		expectedCode  int
+		location      string
+		expectedCache string
+	}{
+		// адрес одноразовой ссылки не отдается без перехода
+		{code: "abc123", expectedCode: http.StatusNoContent, expectedCache: "no-store"},
+		{code: "used01", expectedCode: http.StatusGone, expectedCache: "no-store"},
+		{code: "open01", expectedCode: http.StatusTemporaryRedirect, location: "https://example.com", expectedCache: "private, max-age=300"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			NewRedirectHandler(mockStorage).RedirectByShortURL(rr, httptest.NewRequest(http.MethodHead, "/"+tt.code, nil))
+
+			assert.Equal(t, tt.expectedCode, rr.Code)
+			assert.Equal(t, tt.location, rr.Header().Get("Location"))
+			assert.Equal(t, tt.expectedCache, rr.Header().Get("Cache-Control"))
+		})
+	}
+	mockStorage.AssertNotCalled(t, "RegisterClick", "abc123", "default")
+}
+
+func TestRedirectByShortURL_StatusAndCaching(t *testing.T) {
+	soon := time.Now().Add(30 * time.Second)
+
+	tests := []struct {
+		name          string
+		opts          []RedirectOption
+		record        storage.ShortURLRecord
+		expectedCode  int
+		expectedCache string
+	}{
+		{
+			name:          "default",
+			record:        storage.ShortURLRecord{},
+			expectedCode:  http.StatusTemporaryRedirect,
+			expectedCache: "private, max-age=300",
+		},
+		{
+			name:          "global_permanent",
+			opts:          []RedirectOption{WithRedirectStatus(http.StatusMovedPermanently), WithCacheTTL(time.Hour)},
+			record:        storage.ShortURLRecord{},
+			expectedCode:  http.StatusMovedPermanently,
+			expectedCache: "private, max-age=3600",
+		},
+		{
+			name:          "per_link_overrides_global",
+			opts:          []RedirectOption{WithRedirectStatus(http.StatusMovedPermanently)},
+			record:        storage.ShortURLRecord{RedirectStatus: http.StatusFound},
+			expectedCode:  http.StatusFound,
+			expectedCache: "private, max-age=300",
+		},
+		{
+			name:          "capped_by_expiry",
+			opts:          []RedirectOption{WithRedirectStatus(http.StatusPermanentRedirect)},
+			record:        storage.ShortURLRecord{Schedule: storage.Schedule{ActiveUntil: &soon}},
+			expectedCode:  http.StatusPermanentRedirect,
+			expectedCache: "private, max-age=29",
+		},
+		{
+			name:          "cache_disabled",
+			opts:          []RedirectOption{WithCacheTTL(0)},
+			record:        storage.ShortURLRecord{},
+			expectedCode:  http.StatusTemporaryRedirect,
+			expectedCache: "no-store",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.record.ShortCode = "abc123"
+			tt.record.OriginalURL = "https://example.com"
+			mockStorage := new(storage.MockURLStorage)
+			mockStorage.On("GetRecord", "abc123").Return(tt.record, nil)
+			mockStorage.On("RegisterClick", "abc123", "default").Return(nil).Maybe()
+
+			rr := httptest.NewRecorder()
+			NewRedirectHandler(mockStorage, tt.opts...).RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/abc123", nil))
+
+			assert.Equal(t, tt.expectedCode, rr.Code)
+			assert.Equal(t, tt.expectedCache, rr.Header().Get("Cache-Control"))
+			if tt.expectedCache != "no-store" {
+				assert.NotEmpty(t, rr.Header().Get("Expires"))
+			}
+		})
+	}
+}
+
+func TestRedirectByShortURL_NotFoundPage(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetRecord", "nope").Return(storage.ShortURLRecord{}, storage.ErrNotFound)
+
+	page := template.Must(template.New("404").Parse("<h1>Нет такой ссылки</h1>"))
+	rr := httptest.NewRecorder()
+	NewRedirectHandler(mockStorage, WithNotFoundPage(page)).RedirectByShortURL(rr, httptest.NewRequest(http.MethodGet, "/nope", nil))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Нет такой ссылки")
+}
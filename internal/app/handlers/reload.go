@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/buharamanya/shortener/internal/app/config"
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"go.uber.org/zap"
+)
+
+// перезагрузчик конфига.
+type ConfigReloader interface {
+	Reload() error
+}
+
+// перечитать конфиг по запросу администратора. Если изменились настройки,
+// которым нужен перезапуск, остальное применяется, а их список уходит в ответе.
+func ReloadHandler(reloader ConfigReloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := reloader.Reload()
+
+		var restartErr *config.RestartRequiredError
+		switch {
+		case err == nil:
+			logger.Log.Info("config reloaded")
+			writeJSON(w, http.StatusOK, ReloadResponse{Status: "reloaded"})
+		case errors.As(err, &restartErr):
+			logger.Log.Warn("config reloaded, some changes require restart", zap.Strings("diff", restartErr.Diff))
+			writeJSON(w, http.StatusOK, ReloadResponse{Status: "reloaded", RestartRequired: restartErr.Diff})
+		default:
+			logger.Log.Error("config reload failed", zap.Error(err))
+			writeJSON(w, http.StatusUnprocessableEntity, ReloadResponse{Status: "failed", Error: err.Error()})
+		}
+	}
+}
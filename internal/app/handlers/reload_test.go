@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// перезагрузчик с заранее заданным результатом.
+type stubReloader struct{ err error }
+
+func (s stubReloader) Reload() error { return s.err }
+
+func TestReloadHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		want       ReloadResponse
+	}{
+		{
+			name:       "Reloaded",
+			wantStatus: http.StatusOK,
+			want:       ReloadResponse{Status: "reloaded"},
+		},
+		{
+			name:       "Restart_required",
+			err:        &config.RestartRequiredError{Diff: []string{`server_address: "a:1" -> "b:2"`}},
+			wantStatus: http.StatusOK,
+			want:       ReloadResponse{Status: "reloaded", RestartRequired: []string{`server_address: "a:1" -> "b:2"`}},
+		},
+		{
+			name:       "Invalid_config",
+			err:        errors.New("log_level: invalid value"),
+			wantStatus: http.StatusUnprocessableEntity,
+			want:       ReloadResponse{Status: "failed", Error: "log_level: invalid value"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			ReloadHandler(stubReloader{err: tt.err}).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/admin/reload", nil))
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			var resp ReloadResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			assert.Equal(t, tt.want, resp)
+		})
+	}
+}
@@ -6,15 +6,17 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
 
 	"github.com/buharamanya/shortener/internal/app/auth"
 	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/routing"
 	"github.com/buharamanya/shortener/internal/app/storage"
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/buharamanya/shortener/internal/app/urlnorm"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -24,18 +26,99 @@ type URLSaver interface {
 	SaveBatch(records []storage.ShortURLRecord) error
 }
 
+// адрес назначения в списке блокировок.
+var ErrBlockedDestination = errors.New("destination is blocked")
+
+var errUnsupportedStatus = errors.New("redirect_status must be one of 301, 302, 307, 308")
+
 // тип сократитель.
 type ShortenHandler struct {
-	storage URLSaver
-	baseURL string
+	storage    URLSaver
+	teams      TeamRoleGetter
+	normalizer *urlnorm.Normalizer
+	screener   DestinationScreener
+	events     EventPublisher
+	baseURL    *BaseURL
+}
+
+// опция сократителя.
+type ShortenOption func(sh *ShortenHandler)
+
+// задать правила нормализации URL.
+func WithNormalizer(n *urlnorm.Normalizer) ShortenOption {
+	return func(sh *ShortenHandler) {
+		sh.normalizer = n
+	}
+}
+
+// проверять адреса назначения по списку блокировок.
+func WithScreener(s DestinationScreener) ShortenOption {
+	return func(sh *ShortenHandler) {
+		sh.screener = s
+	}
 }
 
-// создатель сократителя.
-func NewShortenHandler(storage URLSaver, baseURL string) *ShortenHandler {
-	return &ShortenHandler{
+// сообщать о созданных ссылках.
+func WithShortenEvents(p EventPublisher) ShortenOption {
+	return func(sh *ShortenHandler) {
+		sh.events = p
+	}
+}
+
+// создатель сократителя. Если хранилище знает про команды,
+// ссылки можно создавать от имени команды.
+func NewShortenHandler(storage URLSaver, baseURL *BaseURL, opts ...ShortenOption) *ShortenHandler {
+	teams, _ := storage.(TeamRoleGetter)
+	sh := &ShortenHandler{
 		storage: storage,
+		teams:   teams,
 		baseURL: baseURL,
 	}
+	for _, opt := range opts {
+		opt(sh)
+	}
+	return sh
+}
+
+// проверить и нормализовать URL и сверить его со списком блокировок.
+// По умолчанию разрешены http и https, ссылки на сам сократитель запрещены.
+func (sh *ShortenHandler) normalize(raw string) (string, error) {
+	n := sh.normalizer
+	if n == nil {
+		n = urlnorm.New(urlnorm.Options{SelfBaseURL: sh.baseURL.String()})
+	}
+
+	urlStr, err := n.Normalize(raw)
+	if err != nil {
+		return "", err
+	}
+
+	if sh.screener != nil {
+		if verdict := sh.screener.Check(urlStr); verdict.Blocked {
+			logger.Log.Warn("shorten of blocked destination", zap.String("url", urlStr), zap.String("rule", verdict.Rule))
+			return "", ErrBlockedDestination
+		}
+	}
+
+	return urlStr, nil
+}
+
+// может ли пользак создавать ссылки команды.
+func (sh *ShortenHandler) canCreateFor(w http.ResponseWriter, teamID, userID string) bool {
+	if teamID == "" {
+		return true
+	}
+	ok, err := hasTeamRole(sh.teams, teamID, userID, storage.RoleEditor)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to check team role", zap.Error(err))
+		return false
+	}
+	if !ok {
+		http.Error(w, "not allowed to create links for this team", http.StatusForbidden)
+		return false
+	}
+	return true
 }
 
 // хэш вычислитель.
@@ -45,6 +128,104 @@ func getHash(urlStr string) string {
 	return strings.TrimRight(shortCode, "=")
 }
 
+// случайный код для адреса, не совпадающий с кодом обычной ссылки на него.
+func randomCode(urlStr string) string {
+	return getHash(urlStr + "\x00" + uuid.NewString())
+}
+
+// сохранить запись. Если код обычной ссылки занят ссылкой, которой потом добавили
+// настройки, запись получает случайный код.
+func (sh *ShortenHandler) save(record *storage.ShortURLRecord) error {
+	err := sh.storage.Save(*record)
+	var taken *storage.CodeTakenError
+	if errors.As(err, &taken) {
+		record.ShortCode = randomCode(record.OriginalURL)
+		err = sh.storage.Save(*record)
+	}
+	return err
+}
+
+// сохранить пачку, занятые коды заменяются случайными.
+func (sh *ShortenHandler) saveBatch(records []storage.ShortURLRecord) error {
+	for attempt := 0; ; attempt++ {
+		err := sh.storage.SaveBatch(records)
+		var taken *storage.CodeTakenError
+		if !errors.As(err, &taken) || attempt == len(records) {
+			return err
+		}
+		for i := range records {
+			if records[i].ShortCode == taken.ShortCode {
+				records[i].ShortCode = randomCode(records[i].OriginalURL)
+			}
+		}
+	}
+}
+
+// дто настроек ссылки, общих для одиночного и пакетного сокращения.
+type LinkOptions struct {
+	Password  string `json:"password,omitempty"`
+	MaxClicks int    `json:"max_clicks,omitempty"`
+	// 301, 302, 307 или 308, без значения - код по умолчанию
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	LinkMetadata
+	storage.Schedule
+	storage.Forwarding
+}
+
+// проверить настройки ссылки, резервный адрес проверяется как основной.
+func (sh *ShortenHandler) checkOptions(o *LinkOptions) error {
+	if o.MaxClicks < 0 {
+		return errors.New("max_clicks must not be negative")
+	}
+	if o.RedirectStatus != 0 && !IsRedirectStatus(o.RedirectStatus) {
+		return errUnsupportedStatus
+	}
+	if err := o.LinkMetadata.normalize(); err != nil {
+		return err
+	}
+	if err := routing.ValidateForwarding(o.Forwarding); err != nil {
+		return err
+	}
+	return sh.checkSchedule(&o.Schedule)
+}
+
+// проверить окно активности и нормализовать резервный адрес.
+func (sh *ShortenHandler) checkSchedule(s *storage.Schedule) error {
+	if s.ActiveFrom != nil && s.ActiveUntil != nil && !s.ActiveUntil.After(*s.ActiveFrom) {
+		return errors.New("active_until must be after active_from")
+	}
+	if s.FallbackURL != "" {
+		fallback, err := sh.normalize(s.FallbackURL)
+		if err != nil {
+			return fmt.Errorf("fallback_url: %w", err)
+		}
+		s.FallbackURL = fallback
+	}
+	return nil
+}
+
+// применить настройки к записи. Ссылка с особыми настройками получает
+// уникальный код, чтобы не совпасть с обычной ссылкой на тот же адрес.
+func (o LinkOptions) apply(record *storage.ShortURLRecord) error {
+	if o.Password != "" {
+		hash, err := auth.HashPassword(o.Password)
+		if err != nil {
+			return err
+		}
+		record.PasswordHash = hash
+	}
+	record.MaxClicks = o.MaxClicks
+	record.Schedule = o.Schedule
+	record.Forwarding = o.Forwarding
+	record.RedirectStatus = o.RedirectStatus
+	o.LinkMetadata.apply(record)
+
+	if !record.Plain() {
+		record.ShortCode = randomCode(record.OriginalURL)
+	}
+	return nil
+}
+
 // сократитель.
 func (sh *ShortenHandler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 	// проверяем метод запроса
@@ -61,53 +242,54 @@ func (sh *ShortenHandler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	urlStr := strings.TrimSpace(string(originalURL))
-
-	// проверяем что URL не пустой
-	if urlStr == "" {
+	// проверяем и нормализуем URL
+	urlStr, err := sh.normalize(string(originalURL))
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("URL cannot be empty"))
+		w.Write([]byte(err.Error()))
 		return
 	}
 
-	// генерируем короткий код
-	shortCode := getHash(urlStr)
-
-	// сохраняем в хранилище
-	shortURL := sh.baseURL + "/" + shortCode
-
+	// генерируем короткий код и сохраняем в хранилище
 	record := storage.ShortURLRecord{
-		ShortCode:   shortCode,
+		ShortCode:   getHash(urlStr),
 		OriginalURL: urlStr,
 		UserID:      r.Context().Value(auth.UserIDContextKey).(string),
 	}
 
-	err = sh.storage.Save(record)
+	err = sh.save(&record)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		var conflict *storage.ConflictError
+		if errors.As(err, &conflict) {
+			// отдаем ссылку, которая уже сохранена на этот адрес
 			w.Header().Set("Content-Type", "text/plain")
 			w.WriteHeader(http.StatusConflict)
-			w.Write([]byte(shortURL))
+			w.Write([]byte(sh.baseURL.ShortURL(conflict.ShortCode)))
 			return
 		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	sh.publishCreated(record)
 
 	// возвращаем ответ
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(shortURL))
+	w.Write([]byte(sh.baseURL.ShortURL(record.ShortCode)))
 }
 
 // дто запроса на сокращение.
 type ShortenlURLRequest struct {
-	URL string `json:"url"`
+	URL    string `json:"url"`
+	TeamID string `json:"team_id,omitempty"`
+	QR     bool   `json:"qr,omitempty"` // вернуть QR-код в ответе
+	LinkOptions
 }
 
 // дто ответа на сокращение.
 type ShortenlURLResponce struct {
 	Result string `json:"result"`
+	QR     string `json:"qr,omitempty"` // data URI с PNG
 }
 
 // проверка на json.
@@ -146,58 +328,78 @@ func (sh *ShortenHandler) JSONShortenURL(w http.ResponseWriter, r *http.Request)
 	}
 	defer r.Body.Close()
 
-	// читаем тело запроса
-
-	urlStr := strings.TrimSpace(string(reqDto.URL))
-
-	// проверяем что URL не пустой
-	if urlStr == "" {
+	// проверяем и нормализуем URL
+	urlStr, err := sh.normalize(reqDto.URL)
+	if err == nil {
+		err = sh.checkOptions(&reqDto.LinkOptions)
+	}
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("URL cannot be empty"))
+		w.Write([]byte(err.Error()))
 		return
 	}
 
-	// генерируем короткий код
-	shortCode := getHash(urlStr)
+	userID := r.Context().Value(auth.UserIDContextKey).(string)
+	if !sh.canCreateFor(w, reqDto.TeamID, userID) {
+		return
+	}
 
 	// создаем и сохраняем в хранилище короткую ссылку
-	shortURL := sh.baseURL + "/" + shortCode
-
 	record := storage.ShortURLRecord{
-		ShortCode:   shortCode,
+		ShortCode:   getHash(urlStr),
 		OriginalURL: urlStr,
-		UserID:      r.Context().Value(auth.UserIDContextKey).(string),
+		UserID:      userID,
+		TeamID:      reqDto.TeamID,
 	}
 
-	err = sh.storage.Save(record)
+	if err = reqDto.apply(&record); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to hash link password", zap.Error(err))
+		return
+	}
 
-	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusConflict)
+	err = sh.save(&record)
 
-			var respDto = ShortenlURLResponce{
-				Result: shortURL,
-			}
-			resp, _ := json.Marshal(respDto)
-			w.Write(resp)
-			return
-		}
+	status := http.StatusCreated
+	shortCode := record.ShortCode
+	var conflict *storage.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		// отдаем ссылку, которая уже сохранена на этот адрес
+		status, shortCode = http.StatusConflict, conflict.ShortCode
+	case err != nil:
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	default:
+		sh.publishCreated(record)
+	}
+
+	respDto, err := sh.shortenResponse(shortCode, reqDto.QR)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to render QR code", zap.Error(err))
+		return
 	}
 
 	// возвращаем ответ
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(status)
 
-	var respDto = ShortenlURLResponce{
-		Result: shortURL,
-	}
 	resp, _ := json.Marshal(respDto)
 	w.Write(resp)
 }
 
+// ответ на сокращение для кода, с QR-кодом по запросу.
+func (sh *ShortenHandler) shortenResponse(shortCode string, withQR bool) (ShortenlURLResponce, error) {
+	resp := ShortenlURLResponce{Result: sh.baseURL.ShortURL(shortCode)}
+	if !withQR {
+		return resp, nil
+	}
+	var err error
+	resp.QR, err = qrDataURI(resp.Result)
+	return resp, err
+}
+
 // json batch shortener.
 func (sh *ShortenHandler) JSONShortenBatchURL(w http.ResponseWriter, r *http.Request) {
 
@@ -211,25 +413,56 @@ func (sh *ShortenHandler) JSONShortenBatchURL(w http.ResponseWriter, r *http.Req
 	}
 
 	var records []storage.ShortURLRecord
+	userID := r.Context().Value(auth.UserIDContextKey).(string)
+	checkedTeams := make(map[string]bool)
 
 	for _, v := range req {
-		records = append(
-			records,
-			storage.ShortURLRecord{
-				OriginalURL:   v.OriginalURL,
-				CorrelationID: v.CorrelationID,
-				ShortCode:     getHash(v.OriginalURL),
-				UserID:        r.Context().Value(auth.UserIDContextKey).(string),
-			},
-		)
+		urlStr, err := sh.normalize(v.OriginalURL)
+		if err == nil {
+			err = sh.checkOptions(&v.LinkOptions)
+		}
+		if err != nil {
+			http.Error(w, "correlation_id "+v.CorrelationID+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !checkedTeams[v.TeamID] {
+			if !sh.canCreateFor(w, v.TeamID, userID) {
+				return
+			}
+			checkedTeams[v.TeamID] = true
+		}
+
+		record := storage.ShortURLRecord{
+			OriginalURL:   urlStr,
+			CorrelationID: v.CorrelationID,
+			ShortCode:     getHash(urlStr),
+			UserID:        userID,
+			TeamID:        v.TeamID,
+		}
+		if err := v.apply(&record); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Log.Error("failed to hash link password", zap.Error(err))
+			return
+		}
+
+		records = append(records, record)
 	}
 
-	err := sh.storage.SaveBatch(records)
+	err := sh.saveBatch(records)
+	var conflict *storage.ConflictError
+	if errors.As(err, &conflict) {
+		http.Error(w, "a plain link to this URL already exists: "+sh.baseURL.ShortURL(conflict.ShortCode), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		logger.Log.Error("Ошибка сохранения группы записей", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	for _, v := range records {
+		sh.publishCreated(v)
+	}
 
 	var resp []ShortenlURLBatchResponce
 
@@ -238,7 +471,7 @@ func (sh *ShortenHandler) JSONShortenBatchURL(w http.ResponseWriter, r *http.Req
 			resp,
 			ShortenlURLBatchResponce{
 				CorrelationID: v.CorrelationID,
-				ShortURL:      sh.baseURL + "/" + v.ShortCode,
+				ShortURL:      sh.baseURL.ShortURL(v.ShortCode),
 			},
 		)
 	}
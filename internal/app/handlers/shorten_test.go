@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +14,7 @@ import (
 	"github.com/buharamanya/shortener/internal/app/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestShortenURL(t *testing.T) {
@@ -35,6 +37,16 @@ func TestShortenURL(t *testing.T) {
 			expectedStatus: http.StatusCreated,
 			expectedBody:   "http://localhost/", // Без кода, так как он рандомный
 		},
+		{
+			name:   "Conflict:_Existing_link_returned",
+			method: http.MethodPost,
+			body:   "https://example.com",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("Save", mock.Anything).Return(&storage.ConflictError{ShortCode: "stored01"})
+			},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   "http://localhost//stored01",
+		},
 		{
 			name:           "Fail:_Empty_URL",
 			method:         http.MethodPost,
@@ -43,6 +55,30 @@ func TestShortenURL(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   "URL cannot be empty",
 		},
+		{
+			name:           "Fail:_Javascript_scheme",
+			method:         http.MethodPost,
+			body:           "javascript:alert(1)",
+			mockSetup:      func(m *storage.MockURLStorage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "URL scheme is not allowed: javascript",
+		},
+		{
+			name:           "Fail:_Not_a_URL",
+			method:         http.MethodPost,
+			body:           "not a url",
+			mockSetup:      func(m *storage.MockURLStorage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "URL is not valid: missing scheme",
+		},
+		{
+			name:           "Fail:_Self_reference",
+			method:         http.MethodPost,
+			body:           "http://LOCALHOST/abc",
+			mockSetup:      func(m *storage.MockURLStorage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "URL points to the shortener itself",
+		},
 		{
 			name:           "Fail:_Wrong_HTTP_method_(GET)",
 			method:         http.MethodGet,
@@ -64,7 +100,7 @@ func TestShortenURL(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockStorage := new(storage.MockURLStorage)
-			handler := NewShortenHandler(mockStorage, "http://localhost/")
+			handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost/"))
 
 			tt.mockSetup(mockStorage)
 
@@ -167,7 +203,7 @@ func TestJSONShortenURL(t *testing.T) {
 			// Создаем новый мок для каждого теста
 			mockStorage := new(storage.MockURLStorage)
 			sh := &ShortenHandler{
-				baseURL: "http://localhost",
+				baseURL: NewBaseURL("http://localhost"),
 				storage: mockStorage,
 			}
 
@@ -210,3 +246,199 @@ func TestJSONShortenURL(t *testing.T) {
 		})
 	}
 }
+
+func TestShortenURL_NormalizedHash(t *testing.T) {
+	var saved []storage.ShortURLRecord
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("Save", mock.Anything).Run(func(args mock.Arguments) {
+		saved = append(saved, args.Get(0).(storage.ShortURLRecord))
+	}).Return(nil)
+
+	handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"))
+
+	for _, body := range []string{"HTTP://Example.com/", "http://example.com"} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID"))
+		rr := httptest.NewRecorder()
+		handler.ShortenURL(rr, req)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+	}
+
+	if assert.Len(t, saved, 2) {
+		assert.Equal(t, "http://example.com/", saved[0].OriginalURL)
+		assert.Equal(t, saved[0].ShortCode, saved[1].ShortCode)
+	}
+}
+
+func TestJSONShortenBatchURL_Validation(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"))
+
+	body := `[{"correlation_id":"1","original_url":"https://example.com"},{"correlation_id":"2","original_url":"ftp://example.com"}]`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID"))
+	rr := httptest.NewRecorder()
+
+	handler.JSONShortenBatchURL(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "correlation_id 2: URL scheme is not allowed: ftp", strings.TrimSpace(rr.Body.String()))
+	mockStorage.AssertExpectations(t)
+}
+
+func TestShortenURL_Blocked(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"), WithScreener(stubScreener{host: "evil.com"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://EVIL.com/"))
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID"))
+	rr := httptest.NewRecorder()
+	handler.ShortenURL(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, ErrBlockedDestination.Error(), rr.Body.String())
+	mockStorage.AssertExpectations(t)
+}
+
+func TestJSONShortenURL_Password(t *testing.T) {
+	var saved storage.ShortURLRecord
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("Save", mock.Anything).Run(func(args mock.Arguments) {
+		saved = args.Get(0).(storage.ShortURLRecord)
+	}).Return(nil)
+
+	handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/doc","password":"s3cret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID"))
+	rr := httptest.NewRecorder()
+	handler.JSONShortenURL(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.True(t, auth.CheckPassword(saved.PasswordHash, "s3cret"))
+	// защищенная ссылка не совпадает с открытой на тот же адрес
+	assert.NotEqual(t, getHash("https://example.com/doc"), saved.ShortCode)
+	assert.Contains(t, rr.Body.String(), saved.ShortCode)
+}
+
+func TestJSONShortenURL_MaxClicks(t *testing.T) {
+	var saved storage.ShortURLRecord
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("Save", mock.Anything).Run(func(args mock.Arguments) {
+		saved = args.Get(0).(storage.ShortURLRecord)
+	}).Return(nil)
+
+	handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"))
+
+	shorten := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID"))
+		rr := httptest.NewRecorder()
+		handler.JSONShortenURL(rr, req)
+		return rr
+	}
+
+	rr := shorten(`{"url":"https://example.com/invite","max_clicks":-1}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = shorten(`{"url":"https://example.com/invite","max_clicks":1}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, 1, saved.MaxClicks)
+	first := saved.ShortCode
+
+	// каждая одноразовая ссылка получает свой код
+	shorten(`{"url":"https://example.com/invite","max_clicks":1}`)
+	assert.NotEqual(t, first, saved.ShortCode)
+	assert.NotEqual(t, getHash("https://example.com/invite"), first)
+}
+
+func TestJSONShortenURL_TeamLinkNotDeduped(t *testing.T) {
+	var saved storage.ShortURLRecord
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetTeamRole", "team-1", "SuperUserID").Return(storage.RoleEditor, nil)
+	mockStorage.On("Save", mock.Anything).Run(func(args mock.Arguments) {
+		saved = args.Get(0).(storage.ShortURLRecord)
+	}).Return(nil)
+
+	handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/doc","team_id":"team-1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID"))
+	rr := httptest.NewRecorder()
+	handler.JSONShortenURL(rr, req)
+
+	// ссылка команды не получает код личной ссылки на тот же адрес
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "team-1", saved.TeamID)
+	assert.NotEqual(t, getHash("https://example.com/doc"), saved.ShortCode)
+}
+
+func TestJSONShortenURL_Conflict(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("Save", mock.Anything).Return(&storage.ConflictError{ShortCode: "stored01"})
+
+	handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/doc","qr":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID"))
+	rr := httptest.NewRecorder()
+	handler.JSONShortenURL(rr, req)
+
+	// в ответе код, который действительно сохранен, а не вычисленный заново
+	require.Equal(t, http.StatusConflict, rr.Code)
+	var resp ShortenlURLResponce
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "http://localhost/stored01", resp.Result)
+	assert.NotEmpty(t, resp.QR)
+}
+
+func TestJSONShortenURL_QR(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("Save", mock.Anything).Return(nil)
+
+	handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"))
+
+	for _, body := range []string{`{"url":"https://example.com/poster","qr":true}`, `{"url":"https://example.com/poster"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID"))
+		rr := httptest.NewRecorder()
+		handler.JSONShortenURL(rr, req)
+
+		require.Equal(t, http.StatusCreated, rr.Code)
+		var resp ShortenlURLResponce
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		if strings.Contains(body, `"qr":true`) {
+			assert.True(t, strings.HasPrefix(resp.QR, "data:image/png;base64,"))
+		} else {
+			assert.Empty(t, resp.QR)
+		}
+	}
+}
+
+func TestJSONShortenURL_CodeTaken(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("Save", mock.MatchedBy(func(r storage.ShortURLRecord) bool {
+		return r.ShortCode == getHash("https://example.com/doc")
+	})).Return(&storage.CodeTakenError{ShortCode: getHash("https://example.com/doc")})
+	mockStorage.On("Save", mock.Anything).Return(nil)
+
+	handler := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/doc"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID"))
+	rr := httptest.NewRecorder()
+	handler.JSONShortenURL(rr, req)
+
+	// занятый код заменяется случайным
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var resp ShortenlURLResponce
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.NotEqual(t, "http://localhost/"+getHash("https://example.com/doc"), resp.Result)
+	mockStorage.AssertNumberOfCalls(t, "Save", 2)
+}
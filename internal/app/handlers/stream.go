@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/stream"
+	"go.uber.org/zap"
+)
+
+// как часто слать комментарий, чтобы прокси не рвали тихое соединение.
+const streamHeartbeat = 15 * time.Second
+
+// подписка на события пользака.
+type EventSubscriber interface {
+	Subscribe(userID, lastEventID string) (*stream.Subscription, []stream.Message)
+}
+
+// хэндлер потока событий.
+type EventStreamHandler struct {
+	broker    EventSubscriber
+	heartbeat time.Duration
+}
+
+// создать хэндлер потока событий.
+func NewEventStreamHandler(broker EventSubscriber) *EventStreamHandler {
+	return &EventStreamHandler{
+		broker:    broker,
+		heartbeat: streamHeartbeat,
+	}
+}
+
+// события ссылок текущего пользака в формате Server-Sent Events.
+// После переподключения с Last-Event-ID досылаются пропущенные события из буфера.
+func (eh *EventStreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	sub, backlog := eh.broker.Subscribe(userIDFromContext(r), lastEventID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for _, msg := range backlog {
+		if err := writeEvent(w, msg); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		logger.Log.Error("event stream is not flushable", zap.Error(err))
+		return
+	}
+
+	heartbeat := time.NewTicker(eh.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-sub.C:
+			if !ok {
+				// брокер закрыт или клиент не успевал читать
+				return
+			}
+			if err := writeEvent(w, msg); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// записать событие SSE.
+func writeEvent(w http.ResponseWriter, msg stream.Message) error {
+	data, err := json.Marshal(msg.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", msg.ID, msg.Event.Type, data)
+	return err
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/stream"
+	"github.com/buharamanya/shortener/internal/app/webhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// сервер потока событий с теми же мидлварями, что и в main.
+func newStreamServer(t *testing.T, broker *stream.Broker) *httptest.Server {
+	h := NewEventStreamHandler(broker)
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), auth.UserIDContextKey, "user-1")
+		h.Stream(w, r.WithContext(ctx))
+	})
+	srv := httptest.NewServer(logger.WithRequestLogging(WithGzipMiddleware(handler)))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// прочитать одно событие SSE, пропуская комментарии и retry.
+func readEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	event := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if _, ok := event["data"]; ok {
+				return event
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") || strings.HasPrefix(line, "retry:") {
+			continue
+		}
+		k, v, _ := strings.Cut(line, ": ")
+		event[k] = v
+	}
+}
+
+func openStream(t *testing.T, srv *httptest.Server, lastEventID string, gzipped bool) *bufio.Reader {
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/user/events", nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	if gzipped {
+		// явный заголовок отключает прозрачную распаковку в клиенте
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	var body io.Reader = resp.Body
+	if gzipped {
+		require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		zr, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		body = zr
+	}
+	return bufio.NewReader(body)
+}
+
+func TestEventStream(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		name := "plain"
+		if gzipped {
+			name = "gzip"
+		}
+		t.Run(name, func(t *testing.T) {
+			broker := stream.NewBroker(16)
+			srv := newStreamServer(t, broker)
+
+			r := openStream(t, srv, "", gzipped)
+			// заголовки уже пришли, значит подписка оформлена
+			broker.Publish("user-2", webhooks.EventLinkCreated, webhooks.LinkData{ShortCode: "other"})
+			broker.Publish("user-1", webhooks.EventLinkCreated, webhooks.LinkData{ShortCode: "abc123"})
+			broker.Publish("user-1", webhooks.EventLinkClicked, webhooks.LinkData{ShortCode: "abc123", Variant: "b"})
+
+			first := readEvent(t, r)
+			assert.Equal(t, webhooks.EventLinkCreated, first["event"])
+			assert.Contains(t, first["data"], `"short_code":"abc123"`)
+			second := readEvent(t, r)
+			assert.Equal(t, webhooks.EventLinkClicked, second["event"])
+
+			// переподключение досылает пропущенное
+			resumed := openStream(t, srv, first["id"], gzipped)
+			again := readEvent(t, resumed)
+			assert.Equal(t, second["id"], again["id"])
+		})
+	}
+}
+
+func TestEventStream_ClosedOnShutdown(t *testing.T) {
+	broker := stream.NewBroker(16)
+	srv := newStreamServer(t, broker)
+
+	r := openStream(t, srv, "", false)
+	broker.Close()
+
+	_, err := io.ReadAll(r)
+	assert.NoError(t, err)
+}
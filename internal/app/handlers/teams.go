@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// получатель роли в команде.
+type TeamRoleGetter interface {
+	GetTeamRole(teamID, userID string) (storage.Role, error)
+}
+
+// хранилище команд.
+type TeamStore interface {
+	TeamRoleGetter
+	SaveTeam(team storage.Team, ownerID string) error
+	SaveTeamMember(member storage.TeamMember) error
+	DeleteTeamMember(teamID, userID string) error
+	GetTeamMembers(teamID string) ([]storage.TeamMember, error)
+	GetTeamsByUserID(userID string) ([]storage.TeamMember, error)
+	GetURLsByTeamID(teamID string) ([]storage.ShortURLRecord, error)
+	GetAccountByLogin(login string) (storage.Account, error)
+}
+
+// есть ли у пользака в команде роль не ниже need.
+func hasTeamRole(s TeamRoleGetter, teamID, userID string, need storage.Role) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	role, err := s.GetTeamRole(teamID, userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return role.Allows(need), nil
+}
+
+// тип хэндлер команд.
+type TeamHandler struct {
+	storage TeamStore
+	baseURL *BaseURL
+}
+
+// создать хэндлер команд.
+func NewTeamHandler(storage TeamStore, baseURL *BaseURL) *TeamHandler {
+	return &TeamHandler{
+		storage: storage,
+		baseURL: baseURL,
+	}
+}
+
+// создать команду, автор становится владельцем.
+func (th *TeamHandler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	var req TeamRequest
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		logger.Log.Error("failed to read request body", zap.Error(err))
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "team name cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	team := storage.Team{
+		ID:   uuid.New().String(),
+		Name: req.Name,
+	}
+
+	if err := th.storage.SaveTeam(team, userIDFromContext(r)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("Ошибка создания команды", zap.Error(err))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, team)
+}
+
+// команды пользака.
+func (th *TeamHandler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	teams, err := th.storage.GetTeamsByUserID(userIDFromContext(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to fetch teams", zap.Error(err))
+		return
+	}
+
+	if len(teams) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, teams)
+}
+
+// участники команды, видны любому участнику.
+func (th *TeamHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	teamID := chi.URLParam(r, "teamID")
+	if !th.authorize(w, r, teamID, storage.RoleViewer) {
+		return
+	}
+
+	members, err := th.storage.GetTeamMembers(teamID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to fetch team members", zap.Error(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, members)
+}
+
+// пригласить участника по логину или сменить ему роль. Только для владельца.
+// Последнего владельца понизить нельзя.
+func (th *TeamHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	teamID := chi.URLParam(r, "teamID")
+	if !th.authorize(w, r, teamID, storage.RoleOwner) {
+		return
+	}
+
+	var req TeamMemberRequest
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		logger.Log.Error("failed to read request body", zap.Error(err))
+		return
+	}
+
+	if !req.Role.Valid() {
+		http.Error(w, "role must be one of owner, editor, viewer", http.StatusBadRequest)
+		return
+	}
+
+	account, err := th.storage.GetAccountByLogin(strings.TrimSpace(req.Login))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to fetch account", zap.Error(err))
+		return
+	}
+
+	member := storage.TeamMember{
+		TeamID: teamID,
+		UserID: account.UserID,
+		Role:   req.Role,
+	}
+
+	err = th.storage.SaveTeamMember(member)
+	if errors.Is(err, storage.ErrLastOwner) {
+		http.Error(w, "team must keep at least one owner", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("Ошибка добавления участника", zap.Error(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, member)
+}
+
+// исключить участника. Владелец исключает любого, остальные могут выйти сами.
+// Последний владелец выйти не может, сначала нужно назначить другого.
+func (th *TeamHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	teamID := chi.URLParam(r, "teamID")
+	memberID := chi.URLParam(r, "userID")
+
+	need := storage.RoleOwner
+	if memberID == userIDFromContext(r) {
+		need = storage.RoleViewer
+	}
+	if !th.authorize(w, r, teamID, need) {
+		return
+	}
+
+	err := th.storage.DeleteTeamMember(teamID, memberID)
+	if errors.Is(err, storage.ErrLastOwner) {
+		http.Error(w, "team must keep at least one owner", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("Ошибка исключения участника", zap.Error(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// урлы команды, видны любому участнику.
+func (th *TeamHandler) ListURLs(w http.ResponseWriter, r *http.Request) {
+	teamID := chi.URLParam(r, "teamID")
+	if !th.authorize(w, r, teamID, storage.RoleViewer) {
+		return
+	}
+
+	records, err := th.storage.GetURLsByTeamID(teamID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to fetch URLs from storage", zap.Error(err))
+		return
+	}
+
+	if len(records) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var resp []UserURLsDataResponse
+	for _, v := range records {
+		resp = append(resp, UserURLsDataResponse{
+			ShortURL:     th.baseURL.ShortURL(v.ShortCode),
+			OriginalURL:  v.OriginalURL,
+			LinkMetadata: metadataOf(v),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// проверить роль текущего пользака, при отказе ответ уже записан.
+func (th *TeamHandler) authorize(w http.ResponseWriter, r *http.Request, teamID string, need storage.Role) bool {
+	ok, err := hasTeamRole(th.storage, teamID, userIDFromContext(r), need)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to check team role", zap.Error(err))
+		return false
+	}
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// может ли пользак работать со ссылкой: личная доступна только владельцу,
+// командная - участнику с ролью не ниже need.
+func hasAccess(s TeamRoleGetter, record storage.ShortURLRecord, userID string, need storage.Role) (bool, error) {
+	if record.TeamID == "" {
+		return record.UserID == userID, nil
+	}
+	return hasTeamRole(s, record.TeamID, userID, need)
+}
+
+// идентификатор пользака из контекста.
+func userIDFromContext(r *http.Request) string {
+	userID, _ := r.Context().Value(auth.UserIDContextKey).(string)
+	return userID
+}
+
+// записать json ответ.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(v); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTeamHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		mockSetup      func(*storage.MockURLStorage)
+		expectedStatus int
+	}{
+		{
+			name:   "Success:_Create_team",
+			method: http.MethodPost,
+			path:   "/api/teams",
+			body:   `{"name":"marketing"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("SaveTeam", mock.MatchedBy(func(t storage.Team) bool { return t.Name == "marketing" }), "user-1").Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Fail:_Empty_team_name",
+			method:         http.MethodPost,
+			path:           "/api/teams",
+			body:           `{"name":" "}`,
+			mockSetup:      func(m *storage.MockURLStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Success:_Owner_invites_editor",
+			method: http.MethodPost,
+			path:   "/api/teams/team-1/members",
+			body:   `{"login":"bob","role":"editor"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetTeamRole", "team-1", "user-1").Return(storage.RoleOwner, nil)
+				m.On("GetAccountByLogin", "bob").Return(storage.Account{UserID: "user-2", Login: "bob"}, nil)
+				m.On("SaveTeamMember", storage.TeamMember{TeamID: "team-1", UserID: "user-2", Role: storage.RoleEditor}).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Fail:_Editor_cannot_invite",
+			method: http.MethodPost,
+			path:   "/api/teams/team-1/members",
+			body:   `{"login":"bob","role":"editor"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetTeamRole", "team-1", "user-1").Return(storage.RoleEditor, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Fail:_Unknown_role",
+			method: http.MethodPost,
+			path:   "/api/teams/team-1/members",
+			body:   `{"login":"bob","role":"admin"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetTeamRole", "team-1", "user-1").Return(storage.RoleOwner, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Success:_Viewer_lists_team_urls",
+			method: http.MethodGet,
+			path:   "/api/teams/team-1/urls",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetTeamRole", "team-1", "user-1").Return(storage.RoleViewer, nil)
+				m.On("GetURLsByTeamID", "team-1").Return([]storage.ShortURLRecord{{ShortCode: "abc", OriginalURL: "https://example.com", TeamID: "team-1"}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Fail:_Stranger_lists_team_urls",
+			method: http.MethodGet,
+			path:   "/api/teams/team-1/urls",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetTeamRole", "team-1", "user-1").Return(storage.Role(""), storage.ErrNotFound)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Success:_Member_leaves_team",
+			method: http.MethodDelete,
+			path:   "/api/teams/team-1/members/user-1",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetTeamRole", "team-1", "user-1").Return(storage.RoleViewer, nil)
+				m.On("DeleteTeamMember", "team-1", "user-1").Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:   "Fail:_Last_owner_leaves_team",
+			method: http.MethodDelete,
+			path:   "/api/teams/team-1/members/user-1",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetTeamRole", "team-1", "user-1").Return(storage.RoleOwner, nil)
+				m.On("DeleteTeamMember", "team-1", "user-1").Return(storage.ErrLastOwner)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:   "Fail:_Last_owner_demotes_self",
+			method: http.MethodPost,
+			path:   "/api/teams/team-1/members",
+			body:   `{"login":"alice","role":"viewer"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetTeamRole", "team-1", "user-1").Return(storage.RoleOwner, nil)
+				m.On("GetAccountByLogin", "alice").Return(storage.Account{UserID: "user-1", Login: "alice"}, nil)
+				m.On("SaveTeamMember", storage.TeamMember{TeamID: "team-1", UserID: "user-1", Role: storage.RoleViewer}).Return(storage.ErrLastOwner)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			tt.mockSetup(mockStorage)
+
+			th := NewTeamHandler(mockStorage, NewBaseURL("http://localhost"))
+			r := chi.NewRouter()
+			r.Post("/api/teams", th.CreateTeam)
+			r.Post("/api/teams/{teamID}/members", th.AddMember)
+			r.Delete("/api/teams/{teamID}/members/{userID}", th.RemoveMember)
+			r.Get("/api/teams/{teamID}/urls", th.ListURLs)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "user-1")
+			rr := httptest.NewRecorder()
+
+			r.ServeHTTP(rr, req.WithContext(ctx))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Ошибка: некорректный статуса ответа")
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestJSONShortenURL_Team(t *testing.T) {
+	tests := []struct {
+		name           string
+		role           storage.Role
+		roleErr        error
+		expectedStatus int
+	}{
+		{"Editor_creates_team_link", storage.RoleEditor, nil, http.StatusCreated},
+		{"Viewer_is_forbidden", storage.RoleViewer, nil, http.StatusForbidden},
+		{"Stranger_is_forbidden", "", storage.ErrNotFound, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			mockStorage.On("GetTeamRole", "team-1", "SuperUserID").Return(tt.role, tt.roleErr)
+			if tt.expectedStatus == http.StatusCreated {
+				mockStorage.On("Save", mock.MatchedBy(func(r storage.ShortURLRecord) bool { return r.TeamID == "team-1" })).Return(nil)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com","team_id":"team-1"}`))
+			req.Header.Set("Content-Type", "application/json")
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "SuperUserID")
+			rr := httptest.NewRecorder()
+
+			NewShortenHandler(mockStorage, NewBaseURL("http://localhost")).JSONShortenURL(rr, req.WithContext(ctx))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/buharamanya/shortener/internal/app/webhooks"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ограничения подписок.
+const (
+	maxWebhooksPerUser   = 10
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+// хранилище подписок.
+type WebhookStore interface {
+	SaveWebhook(webhook storage.Webhook) error
+	GetWebhook(id string) (storage.Webhook, error)
+	GetWebhooksByUserID(userID string) ([]storage.Webhook, error)
+	DeleteWebhook(id, userID string) error
+	GetWebhookDeliveries(webhookID string, limit int) ([]storage.WebhookDelivery, error)
+}
+
+// хэндлер подписок на события.
+type WebhookHandler struct {
+	storage       WebhookStore
+	allowInternal bool
+}
+
+// опция хэндлера подписок.
+type WebhookOption func(wh *WebhookHandler)
+
+// разрешить подписки на внутренние адреса: localhost, частные сети и т.п.
+func WithInternalWebhookTargets() WebhookOption {
+	return func(wh *WebhookHandler) {
+		wh.allowInternal = true
+	}
+}
+
+// создать хэндлер подписок.
+func NewWebhookHandler(storage WebhookStore, opts ...WebhookOption) *WebhookHandler {
+	wh := &WebhookHandler{storage: storage}
+	for _, opt := range opts {
+		opt(wh)
+	}
+	return wh
+}
+
+// подписаться на события своих ссылок.
+func (wh *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, err := webhooks.CheckTarget(req.URL, wh.allowInternal)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events := req.Events
+	if len(events) == 0 {
+		events = webhooks.Events
+	}
+	for _, e := range events {
+		if !webhooks.ValidEvent(e) {
+			http.Error(w, "unknown event type "+strconv.Quote(e), http.StatusBadRequest)
+			return
+		}
+	}
+	events = slices.Compact(slices.Sorted(slices.Values(events)))
+
+	userID := userIDFromContext(r)
+	existing, err := wh.storage.GetWebhooksByUserID(userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to get webhooks", zap.Error(err))
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		http.Error(w, "too many webhooks", http.StatusConflict)
+		return
+	}
+
+	if req.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			logger.Log.Error("failed to generate webhook secret", zap.Error(err))
+			return
+		}
+		req.Secret = hex.EncodeToString(secret)
+	}
+
+	webhook := storage.Webhook{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		URL:       u.String(),
+		Secret:    req.Secret,
+		Events:    events,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := wh.storage.SaveWebhook(webhook); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to save webhook", zap.Error(err))
+		return
+	}
+
+	resp := webhookResponse(webhook)
+	resp.Secret = webhook.Secret
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// подписки текущего пользака.
+func (wh *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := wh.storage.GetWebhooksByUserID(userIDFromContext(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to get webhooks", zap.Error(err))
+		return
+	}
+
+	resp := make([]WebhookResponse, 0, len(list))
+	for _, v := range list {
+		resp = append(resp, webhookResponse(v))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// отписаться. Недоставленные события подписки пропадают.
+func (wh *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	err := wh.storage.DeleteWebhook(chi.URLParam(r, "webhookID"), userIDFromContext(r))
+	if errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to delete webhook", zap.Error(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// журнал доставок подписки, новые первыми. Размер задается параметром limit.
+func (wh *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, err := wh.storage.GetWebhook(chi.URLParam(r, "webhookID"))
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && webhook.UserID != userIDFromContext(r)) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to get webhook", zap.Error(err))
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxDeliveryLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxDeliveryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := wh.storage.GetWebhookDeliveries(webhook.ID, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Error("failed to get webhook deliveries", zap.Error(err))
+		return
+	}
+	if deliveries == nil {
+		deliveries = []storage.WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func webhookResponse(webhook storage.Webhook) WebhookResponse {
+	return WebhookResponse{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    webhook.Events,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
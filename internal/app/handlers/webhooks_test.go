@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/buharamanya/shortener/internal/app/webhooks"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func webhookRouter(m *storage.MockURLStorage) http.Handler {
+	wh := NewWebhookHandler(m)
+	r := chi.NewRouter()
+	r.Post("/api/user/webhooks", wh.Create)
+	r.Get("/api/user/webhooks", wh.List)
+	r.Delete("/api/user/webhooks/{webhookID}", wh.Delete)
+	r.Get("/api/user/webhooks/{webhookID}/deliveries", wh.Deliveries)
+	return r
+}
+
+func TestWebhookHandler_Create(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(*storage.MockURLStorage)
+		expectedStatus int
+		check          func(t *testing.T, resp WebhookResponse)
+	}{
+		{
+			name: "Success:_All_events_and_generated_secret",
+			body: `{"url":"https://crm.example.com/hooks"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetWebhooksByUserID", "user-1").Return([]storage.Webhook{}, nil)
+				m.On("SaveWebhook", mock.MatchedBy(func(w storage.Webhook) bool {
+					return w.UserID == "user-1" && len(w.Secret) == 64 && len(w.Events) == len(webhooks.Events)
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+			check: func(t *testing.T, resp WebhookResponse) {
+				assert.NotEmpty(t, resp.ID)
+				assert.Len(t, resp.Secret, 64)
+			},
+		},
+		{
+			name: "Success:_Chosen_events",
+			body: `{"url":"https://crm.example.com/in","secret":"s3cret","events":["link.clicked","link.created","link.clicked"]}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetWebhooksByUserID", "user-1").Return([]storage.Webhook{}, nil)
+				m.On("SaveWebhook", mock.Anything).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+			check: func(t *testing.T, resp WebhookResponse) {
+				assert.Equal(t, []string{"link.clicked", "link.created"}, resp.Events)
+				assert.Equal(t, "s3cret", resp.Secret)
+			},
+		},
+		{
+			name:           "Fail:_Unknown_event",
+			body:           `{"url":"https://crm.example.com/hooks","events":["link.renamed"]}`,
+			mockSetup:      func(m *storage.MockURLStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Fail:_Internal_address",
+			body:           `{"url":"http://169.254.169.254/latest/meta-data"}`,
+			mockSetup:      func(m *storage.MockURLStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Fail:_Not_http_url",
+			body:           `{"url":"ftp://crm.example.com/hooks"}`,
+			mockSetup:      func(m *storage.MockURLStorage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Fail:_Too_many_webhooks",
+			body: `{"url":"https://crm.example.com/hooks"}`,
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetWebhooksByUserID", "user-1").Return(make([]storage.Webhook, maxWebhooksPerUser), nil)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			tt.mockSetup(mockStorage)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/webhooks", strings.NewReader(tt.body))
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "user-1")
+			rr := httptest.NewRecorder()
+			webhookRouter(mockStorage).ServeHTTP(rr, req.WithContext(ctx))
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.check != nil {
+				var resp WebhookResponse
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+				tt.check(t, resp)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWebhookHandler_ListHidesSecret(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("GetWebhooksByUserID", "user-1").Return([]storage.Webhook{
+		{ID: "wh-1", UserID: "user-1", URL: "https://crm.example.com/hooks", Secret: "s3cret", Events: webhooks.Events},
+	}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/webhooks", nil)
+	ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "user-1")
+	rr := httptest.NewRecorder()
+	webhookRouter(mockStorage).ServeHTTP(rr, req.WithContext(ctx))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "s3cret")
+	assert.Contains(t, rr.Body.String(), "wh-1")
+}
+
+func TestWebhookHandler_Deliveries(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		mockSetup      func(*storage.MockURLStorage)
+		expectedStatus int
+	}{
+		{
+			name: "Success:_Own_webhook",
+			path: "/api/user/webhooks/wh-1/deliveries?limit=5",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetWebhook", "wh-1").Return(storage.Webhook{ID: "wh-1", UserID: "user-1"}, nil)
+				m.On("GetWebhookDeliveries", "wh-1", 5).Return([]storage.WebhookDelivery{{ID: "ev.wh-1", Status: storage.DeliveryDelivered}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Fail:_Someone_elses_webhook",
+			path: "/api/user/webhooks/wh-2/deliveries",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetWebhook", "wh-2").Return(storage.Webhook{ID: "wh-2", UserID: "user-2"}, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Fail:_Bad_limit",
+			path: "/api/user/webhooks/wh-1/deliveries?limit=0",
+			mockSetup: func(m *storage.MockURLStorage) {
+				m.On("GetWebhook", "wh-1").Return(storage.Webhook{ID: "wh-1", UserID: "user-1"}, nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(storage.MockURLStorage)
+			tt.mockSetup(mockStorage)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "user-1")
+			rr := httptest.NewRecorder()
+			webhookRouter(mockStorage).ServeHTTP(rr, req.WithContext(ctx))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWebhookHandler_Delete(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("DeleteWebhook", "wh-1", "user-1").Return(nil)
+	mockStorage.On("DeleteWebhook", "wh-2", "user-1").Return(storage.ErrNotFound)
+
+	for path, expected := range map[string]int{
+		"/api/user/webhooks/wh-1": http.StatusNoContent,
+		"/api/user/webhooks/wh-2": http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodDelete, path, nil)
+		ctx := context.WithValue(req.Context(), auth.UserIDContextKey, "user-1")
+		rr := httptest.NewRecorder()
+		webhookRouter(mockStorage).ServeHTTP(rr, req.WithContext(ctx))
+		assert.Equal(t, expected, rr.Code, path)
+	}
+}
+
+// публикатор, запоминающий события.
+type recordingPublisher struct {
+	events []string
+	links  []webhooks.LinkData
+}
+
+func (p *recordingPublisher) Publish(userID, eventType string, link webhooks.LinkData) {
+	p.events = append(p.events, userID+" "+eventType)
+	p.links = append(p.links, link)
+}
+
+func TestEvents_ShortenAndRedirect(t *testing.T) {
+	mockStorage := new(storage.MockURLStorage)
+	mockStorage.On("Save", mock.Anything).Return(nil)
+	mockStorage.On("GetRecord", "abc123").Return(storage.ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "owner"}, nil)
+	mockStorage.On("RegisterClick", "abc123", "default").Return(nil)
+
+	events := &recordingPublisher{}
+
+	sh := NewShortenHandler(mockStorage, NewBaseURL("http://localhost"), WithShortenEvents(events))
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, "user-1"))
+	sh.JSONShortenURL(httptest.NewRecorder(), req)
+
+	rh := NewRedirectHandler(mockStorage, WithBaseURL(NewBaseURL("http://localhost")), WithRedirectEvents(events))
+	// HEAD не считается переходом
+	rh.RedirectByShortURL(httptest.NewRecorder(), httptest.NewRequest(http.MethodHead, "/abc123", nil))
+	rh.RedirectByShortURL(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abc123", nil))
+
+	assert.Equal(t, []string{"user-1 link.created", "owner link.clicked"}, events.events)
+	assert.Equal(t, "http://localhost/abc123", events.links[1].ShortURL)
+	assert.Equal(t, "default", events.links[1].Variant)
+}
@@ -12,17 +12,18 @@ import (
 // По умолчанию установлен no-op-логер, который не выводит никаких сообщений.
 var Log *zap.Logger = zap.NewNop()
 
+// уровень логера из Initialize, меняется на лету через SetLevel.
+var level = zap.NewAtomicLevel()
+
 // Initialize инициализирует синглтон логера с необходимым уровнем логирования.
-func Initialize(level string) error {
-	// преобразуем текстовый уровень логирования в zap.AtomicLevel
-	lvl, err := zap.ParseAtomicLevel(level)
-	if err != nil {
+func Initialize(lvl string) error {
+	if err := SetLevel(lvl); err != nil {
 		return err
 	}
 	// создаём новую конфигурацию логера
 	cfg := zap.NewProductionConfig()
-	// устанавливаем уровень
-	cfg.Level = lvl
+	// устанавливаем уровень, общий для всех логеров из Initialize
+	cfg.Level = level
 	// создаём логер на основе конфигурации
 	zl, err := cfg.Build()
 	if err != nil {
@@ -33,6 +34,11 @@ func Initialize(level string) error {
 	return nil
 }
 
+// SetLevel меняет уровень логирования без пересоздания логера.
+func SetLevel(lvl string) error {
+	return level.UnmarshalText([]byte(lvl))
+}
+
 // кастомный респонсрайтер.
 type ResponseWriter interface {
 	Header() http.Header
@@ -69,6 +75,11 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
+// Unwrap отдает исходный ResponseWriter, чтобы работали Flush и прочее через http.ResponseController.
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // мидлварь для логирования запросов.
 func WithRequestLogging(h http.Handler) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
@@ -190,3 +190,34 @@ func BenchmarkConcurrentWithRequestLogging(b *testing.B) {
 		}
 	})
 }
+
+func TestSetLevel(t *testing.T) {
+	originalLog := Log
+	t.Cleanup(func() {
+		Log = originalLog
+		_ = SetLevel("info")
+	})
+
+	if err := Initialize("info"); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if Log.Core().Enabled(zap.DebugLevel) {
+		t.Fatal("Debug should be disabled at info level")
+	}
+
+	// тот же логер начинает писать debug без пересоздания
+	if err := SetLevel("debug"); err != nil {
+		t.Fatalf("SetLevel failed: %v", err)
+	}
+	if !Log.Core().Enabled(zap.DebugLevel) {
+		t.Error("Debug should be enabled after SetLevel")
+	}
+
+	// некорректный уровень не меняет текущий
+	if err := SetLevel("loud"); err == nil {
+		t.Error("Expected error for invalid log level")
+	}
+	if !Log.Core().Enabled(zap.DebugLevel) {
+		t.Error("Invalid level should not change the current one")
+	}
+}
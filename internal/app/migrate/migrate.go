@@ -0,0 +1,248 @@
+// Package migrate переносит ссылки из одного хранилища в другое, например из файла в Postgres.
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"go.uber.org/zap"
+)
+
+// значения по умолчанию.
+const (
+	DefaultBatchSize = 500
+	DefaultSample    = 100
+)
+
+// проверка нашла расхождения.
+var ErrVerify = errors.New("migration verification failed")
+
+// параметры переноса.
+type Options struct {
+	BatchSize int
+	// файл с последним перенесенным кодом; пустой - начинать сначала
+	Checkpoint string
+	// только посчитать, ничего не записывая
+	DryRun bool
+}
+
+// итог переноса.
+type Result struct {
+	Migrated      int // записано в этом запуске; в пробном - было бы записано
+	Skipped       int // уже были в целевом хранилище
+	LastShortCode string
+}
+
+// что хранится в файле контрольной точки.
+type checkpoint struct {
+	LastShortCode string `json:"last_short_code"`
+	Migrated      int    `json:"migrated"`
+}
+
+// перенести все записи, включая удаленные, из source в target пачками через SaveBatch.
+// Записи идут по возрастанию кода; после каждой пачки код последней сохраняется
+// в контрольную точку, и прерванный перенос продолжается с нее. Записи, которые
+// уже есть в target, пропускаются, поэтому повторный запуск безопасен.
+func Run(ctx context.Context, source, target storage.URLStorage, o Options) (Result, error) {
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+
+	var res Result
+	cp, err := loadCheckpoint(o.Checkpoint)
+	if err != nil {
+		return res, err
+	}
+	res.LastShortCode = cp.LastShortCode
+	if cp.LastShortCode != "" {
+		logger.Log.Info("resuming from checkpoint", zap.String("after", cp.LastShortCode), zap.Int("migrated", cp.Migrated))
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		batch, err := source.ListRecords(res.LastShortCode, o.BatchSize)
+		if err != nil {
+			return res, fmt.Errorf("read source after %q: %w", res.LastShortCode, err)
+		}
+		if len(batch) == 0 {
+			return res, nil
+		}
+
+		missing, err := missingIn(target, batch)
+		if err != nil {
+			return res, err
+		}
+		res.Skipped += len(batch) - len(missing)
+
+		if !o.DryRun && len(missing) > 0 {
+			if err := target.SaveBatch(missing); err != nil {
+				return res, fmt.Errorf("write batch %q..%q: %w", batch[0].ShortCode, batch[len(batch)-1].ShortCode, err)
+			}
+		}
+		res.Migrated += len(missing)
+		res.LastShortCode = batch[len(batch)-1].ShortCode
+
+		if !o.DryRun {
+			cp = checkpoint{LastShortCode: res.LastShortCode, Migrated: cp.Migrated + len(missing)}
+			if err := saveCheckpoint(o.Checkpoint, cp); err != nil {
+				return res, err
+			}
+		}
+		logger.Log.Info("batch done",
+			zap.String("last", res.LastShortCode), zap.Int("migrated", res.Migrated), zap.Int("skipped", res.Skipped))
+	}
+}
+
+// записи пачки, которых нет в target.
+func missingIn(target storage.URLStorage, batch []storage.ShortURLRecord) ([]storage.ShortURLRecord, error) {
+	missing := make([]storage.ShortURLRecord, 0, len(batch))
+	for _, record := range batch {
+		_, err := target.GetRecord(record.ShortCode)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			missing = append(missing, record)
+		case err != nil && !errors.Is(err, storage.ErrDeleted):
+			return nil, fmt.Errorf("read target %q: %w", record.ShortCode, err)
+		}
+	}
+	return missing, nil
+}
+
+func loadCheckpoint(path string) (checkpoint, error) {
+	var cp checkpoint
+	if path == "" {
+		return cp, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("bad checkpoint %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// записать контрольную точку через временный файл, чтобы обрыв не оставил ее битой.
+func saveCheckpoint(path string, cp checkpoint) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// результат проверки.
+type Report struct {
+	SourceCount int
+	TargetCount int
+	Sampled     int
+	// коды выборки, которые в target отличаются или отсутствуют
+	Mismatches []string
+}
+
+// сверить хранилища: посчитать записи в обоих и сравнить случайную выборку из sample записей.
+// В target могут быть и свои записи, поэтому ошибкой считается только нехватка.
+func Verify(source, target storage.URLStorage, sample, batchSize int) (Report, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	var rep Report
+	var picked []storage.ShortURLRecord
+	err := each(source, batchSize, func(record storage.ShortURLRecord) {
+		rep.SourceCount++
+		// выборка без возвращения за один проход
+		if len(picked) < sample {
+			picked = append(picked, record)
+		} else if i := rand.IntN(rep.SourceCount); i < sample {
+			picked[i] = record
+		}
+	})
+	if err != nil {
+		return rep, fmt.Errorf("count source: %w", err)
+	}
+	err = each(target, batchSize, func(storage.ShortURLRecord) { rep.TargetCount++ })
+	if err != nil {
+		return rep, fmt.Errorf("count target: %w", err)
+	}
+
+	for _, want := range picked {
+		got, err := target.GetRecord(want.ShortCode)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			rep.Mismatches = append(rep.Mismatches, want.ShortCode)
+		case err != nil && !errors.Is(err, storage.ErrDeleted):
+			return rep, fmt.Errorf("read target %q: %w", want.ShortCode, err)
+		case !same(want, got):
+			rep.Mismatches = append(rep.Mismatches, want.ShortCode)
+		}
+		rep.Sampled++
+	}
+
+	if rep.TargetCount < rep.SourceCount || len(rep.Mismatches) > 0 {
+		return rep, fmt.Errorf("%w: source %d, target %d, %d of %d sampled records differ",
+			ErrVerify, rep.SourceCount, rep.TargetCount, len(rep.Mismatches), rep.Sampled)
+	}
+	return rep, nil
+}
+
+// обойти все записи хранилища.
+func each(s storage.URLStorage, batchSize int, fn func(storage.ShortURLRecord)) error {
+	after := ""
+	for {
+		batch, err := s.ListRecords(after, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		for _, record := range batch {
+			fn(record)
+		}
+		after = batch[len(batch)-1].ShortCode
+	}
+}
+
+// совпадают ли поля, которые перенос обязан сохранить.
+func same(a, b storage.ShortURLRecord) bool {
+	return a.ShortCode == b.ShortCode &&
+		a.OriginalURL == b.OriginalURL &&
+		a.UserID == b.UserID &&
+		a.CorrelationID == b.CorrelationID &&
+		a.TeamID == b.TeamID &&
+		a.DeletedFlag == b.DeletedFlag &&
+		a.PasswordHash == b.PasswordHash &&
+		a.MaxClicks == b.MaxClicks &&
+		a.Clicks == b.Clicks
+}
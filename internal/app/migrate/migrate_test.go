@@ -0,0 +1,140 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openStorage(t *testing.T, path string) *storage.InMemoryStorage {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	s := storage.NewInMemoryStorage(file)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// исходное хранилище с n записями, каждая третья удалена.
+func seed(t *testing.T, n int) *storage.InMemoryStorage {
+	s := openStorage(t, filepath.Join(t.TempDir(), "source.json"))
+	records := make([]storage.ShortURLRecord, n)
+	for i := range records {
+		records[i] = storage.ShortURLRecord{
+			ShortCode:     fmt.Sprintf("code%03d", i),
+			OriginalURL:   fmt.Sprintf("https://example.com/%d", i),
+			CorrelationID: fmt.Sprintf("corr-%d", i),
+			UserID:        fmt.Sprintf("user-%d", i%4),
+			DeletedFlag:   i%3 == 0,
+		}
+	}
+	require.NoError(t, s.SaveBatch(records))
+	return s
+}
+
+// хранилище, которое отказывает на заданной по счету пачке.
+type failingStorage struct {
+	storage.URLStorage
+	failOn int
+	calls  int
+}
+
+func (s *failingStorage) SaveBatch(records []storage.ShortURLRecord) error {
+	s.calls++
+	if s.calls == s.failOn {
+		return errors.New("connection reset")
+	}
+	return s.URLStorage.SaveBatch(records)
+}
+
+func TestRun(t *testing.T) {
+	source := seed(t, 25)
+	target := openStorage(t, filepath.Join(t.TempDir(), "target.json"))
+
+	res, err := Run(context.Background(), source, target, Options{BatchSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 25, res.Migrated)
+	assert.Equal(t, "code024", res.LastShortCode)
+
+	deleted, err := target.GetRecord("code003")
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+	assert.Equal(t, "corr-3", deleted.CorrelationID)
+	assert.Equal(t, "user-3", deleted.UserID)
+
+	rep, err := Verify(source, target, 10, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 25, rep.SourceCount)
+	assert.Equal(t, 25, rep.TargetCount)
+	assert.Equal(t, 10, rep.Sampled)
+
+	// повторный запуск ничего не дублирует
+	res, err = Run(context.Background(), source, target, Options{BatchSize: 10})
+	require.NoError(t, err)
+	assert.Zero(t, res.Migrated)
+	assert.Equal(t, 25, res.Skipped)
+}
+
+func TestRun_DryRun(t *testing.T) {
+	source := seed(t, 5)
+	target := openStorage(t, filepath.Join(t.TempDir(), "target.json"))
+	cp := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	res, err := Run(context.Background(), source, target, Options{BatchSize: 2, DryRun: true, Checkpoint: cp})
+	require.NoError(t, err)
+	assert.Equal(t, 5, res.Migrated)
+
+	records, err := target.ListRecords("", 100)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	assert.NoFileExists(t, cp)
+}
+
+func TestRun_ResumesFromCheckpoint(t *testing.T) {
+	source := seed(t, 25)
+	targetPath := filepath.Join(t.TempDir(), "target.json")
+	target := openStorage(t, targetPath)
+	cp := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	_, err := Run(context.Background(), source, &failingStorage{URLStorage: target, failOn: 2}, Options{BatchSize: 10, Checkpoint: cp})
+	require.Error(t, err)
+
+	// первая пачка перенесена и отмечена
+	checkpoint, err := loadCheckpoint(cp)
+	require.NoError(t, err)
+	assert.Equal(t, "code009", checkpoint.LastShortCode)
+	assert.Equal(t, 10, checkpoint.Migrated)
+
+	// продолжение после перезапуска
+	require.NoError(t, target.Close())
+	target = openStorage(t, targetPath)
+	res, err := Run(context.Background(), source, target, Options{BatchSize: 10, Checkpoint: cp})
+	require.NoError(t, err)
+	assert.Equal(t, 15, res.Migrated)
+	assert.Zero(t, res.Skipped)
+
+	_, err = Verify(source, target, 25, 10)
+	assert.NoError(t, err)
+}
+
+func TestVerify_Mismatch(t *testing.T) {
+	source := seed(t, 5)
+	target := openStorage(t, filepath.Join(t.TempDir(), "target.json"))
+
+	records, err := source.ListRecords("", 100)
+	require.NoError(t, err)
+	records[1].OriginalURL = "https://example.com/changed"
+	require.NoError(t, target.SaveBatch(records[:4]))
+
+	rep, err := Verify(source, target, 5, 2)
+	require.ErrorIs(t, err, ErrVerify)
+	assert.Equal(t, 5, rep.SourceCount)
+	assert.Equal(t, 4, rep.TargetCount)
+	assert.ElementsMatch(t, []string{"code001", "code004"}, rep.Mismatches)
+}
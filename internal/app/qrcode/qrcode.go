@@ -0,0 +1,156 @@
+// Package qrcode рисует QR-коды коротких ссылок в PNG и SVG.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// форматы картинки.
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// ограничения размера картинки в пикселях.
+const (
+	DefaultSize = 256
+	MinSize     = 64
+	MaxSize     = 2048
+)
+
+// ширина пустой рамки вокруг кода в модулях, меньше сканеры не любят.
+const quietZone = 4
+
+// ошибки разбора параметров, текст уходит клиенту.
+var (
+	ErrFormat = errors.New("QR format must be png or svg")
+	ErrSize   = fmt.Errorf("QR size must be between %d and %d", MinSize, MaxSize)
+	ErrLevel  = errors.New("QR error correction level must be L, M, Q or H")
+)
+
+var levels = map[string]qr.Level{
+	"L": qr.L,
+	"M": qr.M,
+	"Q": qr.Q,
+	"H": qr.H,
+}
+
+// параметры картинки.
+type Options struct {
+	Format string
+	Size   int
+	Level  string
+}
+
+// разобрать параметры запроса, пустые значения заменяются умолчаниями.
+func ParseOptions(format, size, level string) (Options, error) {
+	o := Options{Format: FormatPNG, Size: DefaultSize, Level: "M"}
+
+	if format != "" {
+		o.Format = strings.ToLower(format)
+		if o.Format != FormatPNG && o.Format != FormatSVG {
+			return Options{}, ErrFormat
+		}
+	}
+	if size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < MinSize || n > MaxSize {
+			return Options{}, ErrSize
+		}
+		o.Size = n
+	}
+	if level != "" {
+		o.Level = strings.ToUpper(level)
+		if _, ok := levels[o.Level]; !ok {
+			return Options{}, ErrLevel
+		}
+	}
+	return o, nil
+}
+
+// content type картинки.
+func (o Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// нарисовать код для text. Картинка квадратная, сторона ровно o.Size.
+func Render(text string, o Options) ([]byte, error) {
+	level, ok := levels[o.Level]
+	if !ok {
+		return nil, ErrLevel
+	}
+	code, err := qr.Encode(text, level)
+	if err != nil {
+		return nil, err
+	}
+
+	// модуль целого числа пикселей, остаток уходит в рамку
+	modules := code.Size + 2*quietZone
+	scale := o.Size / modules
+	if scale < 1 {
+		return nil, ErrSize
+	}
+	offset := (o.Size - scale*code.Size) / 2
+
+	if o.Format == FormatSVG {
+		return renderSVG(code, o.Size, scale, offset), nil
+	}
+	return renderPNG(code, o.Size, scale, offset)
+}
+
+func renderPNG(code *qr.Code, size, scale, offset int) ([]byte, error) {
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				row := img.Pix[(offset+y*scale+dy)*img.Stride:]
+				for dx := 0; dx < scale; dx++ {
+					row[offset+x*scale+dx] = 1
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// черные модули одним path, подряд идущие в строке склеиваются.
+func renderSVG(code *qr.Code, size, scale, offset int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, size, size)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; {
+			if !code.Black(x, y) {
+				x++
+				continue
+			}
+			start := x
+			for x < code.Size && code.Black(x, y) {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv%dh-%dz", offset+start*scale, offset+y*scale, (x-start)*scale, scale, (x-start)*scale)
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
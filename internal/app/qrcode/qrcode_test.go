@@ -0,0 +1,83 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"rsc.io/qr"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		size     string
+		level    string
+		expected Options
+		err      error
+	}{
+		{"Defaults", "", "", "", Options{Format: FormatPNG, Size: DefaultSize, Level: "M"}, nil},
+		{"SVG", "SVG", "512", "h", Options{Format: FormatSVG, Size: 512, Level: "H"}, nil},
+		{"Bad_format", "gif", "", "", Options{}, ErrFormat},
+		{"Size_too_small", "", "10", "", Options{}, ErrSize},
+		{"Size_too_big", "", "5000", "", Options{}, ErrSize},
+		{"Size_not_a_number", "", "big", "", Options{}, ErrSize},
+		{"Bad_level", "", "", "X", Options{}, ErrLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := ParseOptions(tt.format, tt.size, tt.level)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.expected, o)
+		})
+	}
+}
+
+func TestRender_PNG(t *testing.T) {
+	const text = "http://localhost:8080/abc123"
+	o := Options{Format: FormatPNG, Size: 300, Level: "Q"}
+
+	data, err := Render(text, o)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+	assert.Equal(t, 300, img.Bounds().Dy())
+
+	// центр каждого модуля совпадает с кодом
+	code, err := qr.Encode(text, qr.Q)
+	require.NoError(t, err)
+	scale := o.Size / (code.Size + 2*quietZone)
+	offset := (o.Size - scale*code.Size) / 2
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			r, _, _, _ := img.At(offset+x*scale+scale/2, offset+y*scale+scale/2).RGBA()
+			assert.Equal(t, code.Black(x, y), r == 0, "module %d,%d", x, y)
+		}
+	}
+	// рамка белая
+	r, _, _, _ := img.At(0, 0).RGBA()
+	assert.NotZero(t, r)
+}
+
+func TestRender_SVG(t *testing.T) {
+	data, err := Render("http://localhost:8080/abc123", Options{Format: FormatSVG, Size: 128, Level: "L"})
+	require.NoError(t, err)
+
+	svg := string(data)
+	assert.True(t, strings.HasPrefix(svg, "<svg "))
+	assert.Contains(t, svg, `width="128" height="128"`)
+	assert.Contains(t, svg, `<path fill="#000" d="M`)
+}
+
+func TestRender_TooSmall(t *testing.T) {
+	// длинный текст дает большой код, который не влезает в 64 пикселя
+	_, err := Render("http://localhost:8080/"+strings.Repeat("a", 200), Options{Format: FormatPNG, Size: MinSize, Level: "H"})
+	assert.ErrorIs(t, err, ErrSize)
+}
@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+)
+
+// заголовок с ключом API.
+const APIKeyHeader = "X-API-Key"
+
+// KeyFunc возвращает ключ ведра для запроса, пустая строка - не ограничивать.
+type KeyFunc func(r *http.Request) string
+
+// ключ по пользаку из контекста авторизации.
+func ByUser(r *http.Request) string {
+	userID, _ := r.Context().Value(auth.UserIDContextKey).(string)
+	if userID == "" {
+		return ""
+	}
+	return "user:" + userID
+}
+
+// ключ по ключу API. Ведро получают только ключи, прошедшие valid: иначе каждый
+// выдуманный заголовок давал бы свежее ведро. Запрос с неизвестным ключом
+// ограничивается по остальным ключам, то есть по пользаку и IP.
+func ByAPIKey(valid func(key string) bool) KeyFunc {
+	return func(r *http.Request) string {
+		key := r.Header.Get(APIKeyHeader)
+		if key == "" || valid == nil || !valid(key) {
+			return ""
+		}
+		return "apikey:" + key
+	}
+}
+
+// проверка ключа API по списку выданных ключей.
+func KeySet(keys []string) func(key string) bool {
+	set := make(map[[sha256.Size]byte]struct{}, len(keys))
+	for _, k := range keys {
+		if k != "" {
+			set[sha256.Sum256([]byte(k))] = struct{}{}
+		}
+	}
+	return func(key string) bool {
+		// сравниваем хэши, чтобы время поиска не зависело от совпавшего префикса
+		_, ok := set[sha256.Sum256([]byte(key))]
+		return ok
+	}
+}
+
+// ключ по IP клиента. X-Forwarded-For учитывается, только если
+// запрос пришел от доверенного прокси.
+func ByIP(trusted []*net.IPNet) KeyFunc {
+	return func(r *http.Request) string {
+		ip := ClientIP(r, trusted)
+		if ip == "" {
+			return ""
+		}
+		return "ip:" + ip
+	}
+}
+
+// ClientIP определяет IP клиента. Цепочка X-Forwarded-For просматривается
+// справа налево, пока адреса принадлежат доверенным прокси.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrusted(ip, trusted) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+
+	return ip.String()
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// разобрать список доверенных прокси: CIDR или отдельные адреса.
+func ParseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range list {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Limiter применяет лимиты групп маршрутов.
+type Limiter struct {
+	store  *Store
+	limits atomic.Pointer[map[string]Limit]
+	keys   []KeyFunc
+}
+
+// создать лимитер. Каждый ключ из keys получает собственное ведро.
+func NewLimiter(store *Store, limits map[string]Limit, keys ...KeyFunc) *Limiter {
+	l := &Limiter{
+		store: store,
+		keys:  keys,
+	}
+	l.SetLimits(limits)
+	return l
+}
+
+// заменить лимиты на лету. Уже набранные ведра сохраняются и дальше
+// пополняются по новому лимиту.
+func (l *Limiter) SetLimits(limits map[string]Limit) {
+	l.limits.Store(&limits)
+}
+
+// мидлварь на ограничение группы маршрутов. Без лимита для группы пропускает все.
+func (l *Limiter) Middleware(group string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, ok := (*l.limits.Load())[group]
+			if !ok || !limit.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var tightest *Result
+			for _, keyFn := range l.keys {
+				key := keyFn(r)
+				if key == "" {
+					continue
+				}
+
+				res := l.store.Take(group+"|"+key, limit)
+				if tightest == nil || !res.Allowed || (tightest.Allowed && res.Remaining < tightest.Remaining) {
+					tightest = &res
+				}
+				if !res.Allowed {
+					break
+				}
+			}
+
+			if tightest != nil {
+				setHeaders(w, *tightest)
+				if !tightest.Allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter.Seconds())))
+					http.Error(w, "too many requests", http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func setHeaders(w http.ResponseWriter, res Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset.Seconds())))
+}
+
+func ceilSeconds(s float64) int {
+	return int(math.Ceil(s))
+}
@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Take(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := NewStore(time.Minute, 0)
+	s.now = func() time.Time { return now }
+
+	limit := Limit{Rate: 1, Burst: 2}
+
+	assert.True(t, s.Take("k", limit).Allowed)
+	assert.True(t, s.Take("k", limit).Allowed)
+
+	res := s.Take("k", limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter)
+
+	// через секунду появляется ровно один токен
+	now = now.Add(time.Second)
+	assert.True(t, s.Take("k", limit).Allowed)
+	assert.False(t, s.Take("k", limit).Allowed)
+
+	// другой ключ не затронут
+	assert.True(t, s.Take("other", limit).Allowed)
+}
+
+func TestStore_Eviction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := NewStore(time.Minute, 2)
+	s.now = func() time.Time { return now }
+
+	limit := Limit{Rate: 1, Burst: 1}
+	s.Take("a", limit)
+	now = now.Add(time.Second)
+	s.Take("b", limit)
+	now = now.Add(time.Second)
+	s.Take("c", limit)
+
+	assert.Equal(t, 2, s.Len())
+
+	// вытеснено самое старое ведро - "a" снова полное
+	assert.True(t, s.Take("a", limit).Allowed)
+
+	// обращение освежает ведро: вытесняется "a", а не только что тронутое "c"
+	slow := Limit{Rate: 0.001, Burst: 1}
+	now = now.Add(time.Second)
+	s.Take("c", slow)
+	now = now.Add(time.Second)
+	s.Take("d", slow)
+	assert.Equal(t, 2, s.Len())
+	assert.False(t, s.Take("c", slow).Allowed)
+	assert.True(t, s.Take("a", slow).Allowed)
+
+	now = now.Add(time.Hour)
+	s.mu.Lock()
+	s.sweepLocked(now)
+	s.mu.Unlock()
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"Direct_client", "203.0.113.5:1234", "", "203.0.113.5"},
+		{"Untrusted_peer_ignores_header", "203.0.113.5:1234", "1.2.3.4", "203.0.113.5"},
+		{"Trusted_proxy", "10.0.0.2:1234", "1.2.3.4", "1.2.3.4"},
+		{"Proxy_chain", "192.168.1.1:1234", "6.6.6.6, 1.2.3.4, 10.0.0.3", "1.2.3.4"},
+		{"Only_proxies", "10.0.0.2:1234", "10.0.0.3", "10.0.0.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			assert.Equal(t, tt.expected, ClientIP(req, trusted))
+		})
+	}
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	limiter := NewLimiter(
+		NewStore(time.Minute, 0),
+		map[string]Limit{"shorten": {Rate: 0.001, Burst: 2}},
+		ByAPIKey(KeySet([]string{"key-1"})), ByUser, ByIP(nil),
+	)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	do := func(group, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, userID))
+		rr := httptest.NewRecorder()
+		limiter.Middleware(group)(handler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("shorten", "user-1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusOK, do("shorten", "user-1").Code)
+
+	rr = do("shorten", "user-1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+
+	// другой пользак с того же IP упирается в ведро по IP
+	assert.Equal(t, http.StatusTooManyRequests, do("shorten", "user-2").Code)
+
+	// группа без лимита не ограничивается
+	assert.Equal(t, http.StatusOK, do("redirect", "user-1").Code)
+
+	// новые лимиты действуют для уже собранных мидлварей
+	limiter.SetLimits(map[string]Limit{"redirect": {Rate: 0.001, Burst: 1}})
+	assert.Equal(t, http.StatusOK, do("shorten", "user-1").Code)
+	assert.Equal(t, http.StatusOK, do("redirect", "user-1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("redirect", "user-1").Code)
+}
+
+func TestByAPIKey(t *testing.T) {
+	keyFn := ByAPIKey(KeySet([]string{"key-1", ""}))
+
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{"Valid_key", "key-1", "apikey:key-1"},
+		{"Unknown_key", "key-2", ""},
+		{"No_key", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.key != "" {
+				req.Header.Set(APIKeyHeader, tt.key)
+			}
+			assert.Equal(t, tt.expected, keyFn(req))
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Package ratelimit ограничивает частоту запросов алгоритмом token bucket.
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// параметры ведра: скорость пополнения и емкость.
+type Limit struct {
+	Rate  float64 // токенов в секунду
+	Burst int
+}
+
+// включено ли ограничение.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// результат попытки взять токен.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // до полного пополнения ведра
+	RetryAfter time.Duration // до появления следующего токена, если отказано
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Store хранит ведра в памяти и вытесняет давно не используемые.
+// Ведра лежат в списке по времени последнего обращения, самое свежее в начале,
+// поэтому вытеснение и очистка не перебирают все ведра.
+type Store struct {
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List
+	idleTTL time.Duration
+	maxKeys int
+	now     func() time.Time
+}
+
+// создать хранилище. Ведро, простоявшее idleTTL, удаляется;
+// при превышении maxKeys вытесняются самые старые.
+func NewStore(idleTTL time.Duration, maxKeys int) *Store {
+	return &Store{
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+		idleTTL: idleTTL,
+		maxKeys: maxKeys,
+		now:     time.Now,
+	}
+}
+
+// взять токен из ведра key.
+func (s *Store) Take(key string, l Limit) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	var b *bucket
+	if el, ok := s.buckets[key]; ok {
+		b = el.Value.(*bucket)
+		s.lru.MoveToFront(el)
+	} else {
+		if s.maxKeys > 0 && len(s.buckets) >= s.maxKeys {
+			s.evictLocked(now)
+		}
+		b = &bucket{key: key, tokens: float64(l.Burst), last: now}
+		s.buckets[key] = s.lru.PushFront(b)
+	}
+
+	// пополняем ведро за прошедшее время
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+
+	res := Result{Limit: l.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / l.Rate)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((float64(l.Burst) - b.tokens) / l.Rate)
+
+	return res
+}
+
+// Run периодически удаляет простаивающие ведра до отмены контекста.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.idleTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.sweepLocked(s.now())
+			s.mu.Unlock()
+		}
+	}
+}
+
+// количество ведер.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+// удалить простаивающие ведра с конца списка.
+func (s *Store) sweepLocked(now time.Time) {
+	for el := s.lru.Back(); el != nil; el = s.lru.Back() {
+		if now.Sub(el.Value.(*bucket).last) < s.idleTTL {
+			return
+		}
+		s.removeLocked(el)
+	}
+}
+
+// освободить место: сначала простаивающие, потом самое давнее ведро.
+func (s *Store) evictLocked(now time.Time) {
+	s.sweepLocked(now)
+	if len(s.buckets) >= s.maxKeys {
+		s.removeLocked(s.lru.Back())
+	}
+}
+
+func (s *Store) removeLocked(el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.buckets, el.Value.(*bucket).key)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,131 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+)
+
+// ErrPathNotAllowed - к ссылке без проброса пути обратились с подпутем.
+var ErrPathNotAllowed = errors.New("sub-path is not allowed for this link")
+
+// Forward переносит в адрес назначения параметры и путь входящего запроса.
+//
+// Параметры собираются так: сначала параметры самого адреса, затем utm метки
+// ссылки, если таких ключей в адресе нет, затем входящие параметры. Входящие
+// параметры при QueryOverride заменяют одноименные, иначе добавляются только
+// отсутствующие ключи. Исходная строка запроса адреса сохраняется как есть,
+// новые параметры дописываются в порядке ключей. Фрагмент адреса сохраняется.
+//
+// extraPath - экранированный путь после кода без ведущего слеша.
+func Forward(dest string, f storage.Forwarding, incoming url.Values, extraPath string) (string, error) {
+	if extraPath != "" && !f.ForwardPath {
+		return "", ErrPathNotAllowed
+	}
+	if !f.Enabled() {
+		return dest, nil
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", err
+	}
+
+	if extraPath != "" {
+		if err := appendPath(u, extraPath); err != nil {
+			return "", err
+		}
+	}
+
+	present := u.Query()
+	var remove []string
+	add := url.Values{}
+
+	for k, v := range f.UTM {
+		if !present.Has(k) {
+			add.Set(k, v)
+		}
+	}
+
+	if f.ForwardQuery {
+		for k, vs := range incoming {
+			switch {
+			case f.QueryOverride && present.Has(k):
+				remove = append(remove, k)
+				add[k] = vs
+			case f.QueryOverride || !present.Has(k):
+				add[k] = vs
+			}
+		}
+	}
+
+	u.RawQuery = mergeQuery(u.RawQuery, remove, add)
+	return u.String(), nil
+}
+
+// дописать экранированный путь. Сегменты . и .. запрещены,
+// чтобы нельзя было выйти выше пути адреса.
+func appendPath(u *url.URL, extraPath string) error {
+	p := strings.TrimSuffix(u.Path, "/")
+	raw := strings.TrimSuffix(u.EscapedPath(), "/")
+
+	for _, seg := range strings.Split(extraPath, "/") {
+		if seg == "" {
+			continue
+		}
+		unescaped, err := url.PathUnescape(seg)
+		if err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+		if unescaped == "." || unescaped == ".." {
+			return ErrPathNotAllowed
+		}
+		p += "/" + unescaped
+		raw += "/" + seg
+	}
+
+	u.Path, u.RawPath = p, raw
+	return nil
+}
+
+// убрать из строки запроса ключи remove и дописать add.
+func mergeQuery(raw string, remove []string, add url.Values) string {
+	var parts []string
+	for _, part := range strings.Split(raw, "&") {
+		if part == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(part, "=")
+		if k, err := url.QueryUnescape(key); err == nil && contains(remove, k) {
+			continue
+		}
+		parts = append(parts, part)
+	}
+
+	keys := make([]string, 0, len(add))
+	for k := range add {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range add[k] {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// проверить настройки проброса: ключи меток должны начинаться с utm_.
+func ValidateForwarding(f storage.Forwarding) error {
+	for k := range f.UTM {
+		if !strings.HasPrefix(k, "utm_") || len(k) == len("utm_") {
+			return fmt.Errorf("invalid utm parameter %q", k)
+		}
+	}
+	return nil
+}
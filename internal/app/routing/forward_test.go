@@ -0,0 +1,101 @@
+package routing
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForward(t *testing.T) {
+	tests := []struct {
+		name     string
+		dest     string
+		f        storage.Forwarding
+		incoming string
+		extra    string
+		want     string
+		wantErr  error
+	}{
+		{
+			name:     "disabled_keeps_destination",
+			dest:     "https://example.com/a?x=1",
+			incoming: "utm_source=mail",
+			want:     "https://example.com/a?x=1",
+		},
+		{
+			name:     "query_added",
+			dest:     "https://example.com/a?x=1#top",
+			f:        storage.Forwarding{ForwardQuery: true},
+			incoming: "b=2&a=1",
+			want:     "https://example.com/a?x=1&a=1&b=2#top",
+		},
+		{
+			name:     "original_wins_by_default",
+			dest:     "https://example.com/a?x=1",
+			f:        storage.Forwarding{ForwardQuery: true},
+			incoming: "x=2&y=3",
+			want:     "https://example.com/a?x=1&y=3",
+		},
+		{
+			name:     "incoming_overrides",
+			dest:     "https://example.com/a?x=1&keep=%2F",
+			f:        storage.Forwarding{ForwardQuery: true, QueryOverride: true},
+			incoming: "x=2",
+			want:     "https://example.com/a?keep=%2F&x=2",
+		},
+		{
+			name: "utm_fills_missing",
+			dest: "https://example.com/?utm_source=site",
+			f:    storage.Forwarding{UTM: map[string]string{"utm_source": "short", "utm_medium": "link two"}},
+			want: "https://example.com/?utm_source=site&utm_medium=link+two",
+		},
+		{
+			name:     "incoming_overrides_utm",
+			dest:     "https://example.com/",
+			f:        storage.Forwarding{ForwardQuery: true, QueryOverride: true, UTM: map[string]string{"utm_source": "short"}},
+			incoming: "utm_source=newsletter",
+			want:     "https://example.com/?utm_source=newsletter",
+		},
+		{
+			name:  "path_appended",
+			dest:  "https://example.com/docs/?v=1",
+			f:     storage.Forwarding{ForwardPath: true},
+			extra: "guide/a%2Fb",
+			want:  "https://example.com/docs/guide/a%2Fb?v=1",
+		},
+		{
+			name:    "path_not_allowed",
+			dest:    "https://example.com/docs",
+			extra:   "guide",
+			wantErr: ErrPathNotAllowed,
+		},
+		{
+			name:    "path_traversal",
+			dest:    "https://example.com/docs",
+			f:       storage.Forwarding{ForwardPath: true},
+			extra:   "%2e%2e/secret",
+			wantErr: ErrPathNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			incoming, _ := url.ParseQuery(tt.incoming)
+			got, err := Forward(tt.dest, tt.f, incoming, tt.extra)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateForwarding(t *testing.T) {
+	assert.NoError(t, ValidateForwarding(storage.Forwarding{UTM: map[string]string{"utm_campaign": "spring"}}))
+	assert.Error(t, ValidateForwarding(storage.Forwarding{UTM: map[string]string{"ref": "x"}}))
+	assert.Error(t, ValidateForwarding(storage.Forwarding{UTM: map[string]string{"utm_": "x"}}))
+}
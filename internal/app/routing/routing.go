@@ -0,0 +1,179 @@
+// Package routing выбирает адрес назначения ссылки по ее правилам перенаправления.
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"golang.org/x/text/language"
+)
+
+// вариант основного адреса ссылки.
+const DefaultVariant = "default"
+
+// заголовок со страной, если прокси не настроен иначе.
+const DefaultGeoHeader = "X-Country-Code"
+
+// платформы клиента.
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformDesktop = "desktop"
+)
+
+// признаки запроса, по которым сверяются правила.
+type Request struct {
+	Platform string
+	Language language.Tag // самый предпочтительный язык, und если не указан
+	Country  string
+}
+
+// собрать признаки из http запроса. Страна берется из заголовка geoHeader.
+func FromHTTP(r *http.Request, geoHeader string) Request {
+	req := Request{
+		Platform: Platform(r.UserAgent()),
+		Country:  strings.ToUpper(strings.TrimSpace(r.Header.Get(geoHeader))),
+	}
+	if tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); err == nil && len(tags) > 0 {
+		req.Language = tags[0]
+	}
+	return req
+}
+
+// платформа по User-Agent.
+func Platform(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		return PlatformIOS
+	case strings.Contains(ua, "Android"):
+		return PlatformAndroid
+	default:
+		return PlatformDesktop
+	}
+}
+
+// выбрать адрес и вариант. Правила с условиями проверяются по порядку, первое
+// подошедшее побеждает. Иначе трафик делится по весам, остаток уходит на основной адрес.
+// roll(n) возвращает случайное число из [0, n).
+func Select(rules []storage.RedirectRule, req Request, defaultURL string, roll func(int) int) (string, string) {
+	var weighted []storage.RedirectRule
+	for _, rule := range rules {
+		if rule.Weight > 0 {
+			weighted = append(weighted, rule)
+			continue
+		}
+		if matches(rule, req) {
+			return rule.URL, rule.Variant
+		}
+	}
+
+	if len(weighted) > 0 {
+		n := roll(100)
+		for _, rule := range weighted {
+			if n < rule.Weight {
+				return rule.URL, rule.Variant
+			}
+			n -= rule.Weight
+		}
+	}
+
+	return defaultURL, DefaultVariant
+}
+
+func matches(rule storage.RedirectRule, req Request) bool {
+	if len(rule.Platforms) > 0 && !contains(rule.Platforms, req.Platform) {
+		return false
+	}
+	if len(rule.Countries) > 0 && !contains(rule.Countries, req.Country) {
+		return false
+	}
+	if len(rule.Languages) > 0 && !matchesLanguage(rule.Languages, req.Language) {
+		return false
+	}
+	return true
+}
+
+// язык правила без региона совпадает с любым регионом: en подходит для en-GB.
+func matchesLanguage(langs []string, tag language.Tag) bool {
+	if tag == language.Und {
+		return false
+	}
+	base, _ := tag.Base()
+	for _, l := range langs {
+		want, err := language.Parse(l)
+		if err != nil {
+			continue
+		}
+		if want == tag {
+			return true
+		}
+		if wantBase, _ := want.Base(); !strings.Contains(l, "-") && wantBase == base {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// проверить правила и привести значения к каноническому виду.
+// Адреса назначения проверяет вызывающий.
+func Validate(rules []storage.RedirectRule) error {
+	seen := map[string]bool{DefaultVariant: true}
+	total := 0
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Variant == "" {
+			return fmt.Errorf("rule %d: variant is required", i)
+		}
+		if seen[rule.Variant] {
+			return fmt.Errorf("rule %d: duplicate variant %q", i, rule.Variant)
+		}
+		seen[rule.Variant] = true
+
+		for j, p := range rule.Platforms {
+			p = strings.ToLower(p)
+			if p != PlatformIOS && p != PlatformAndroid && p != PlatformDesktop {
+				return fmt.Errorf("rule %q: unknown platform %q", rule.Variant, p)
+			}
+			rule.Platforms[j] = p
+		}
+		for j, l := range rule.Languages {
+			tag, err := language.Parse(l)
+			if err != nil {
+				return fmt.Errorf("rule %q: invalid language %q", rule.Variant, l)
+			}
+			rule.Languages[j] = tag.String()
+		}
+		for j, c := range rule.Countries {
+			rule.Countries[j] = strings.ToUpper(strings.TrimSpace(c))
+		}
+
+		conditional := len(rule.Platforms) > 0 || len(rule.Languages) > 0 || len(rule.Countries) > 0
+		switch {
+		case rule.Weight < 0 || rule.Weight > 100:
+			return fmt.Errorf("rule %q: weight must be between 0 and 100", rule.Variant)
+		case rule.Weight > 0 && conditional:
+			return fmt.Errorf("rule %q: weight cannot be combined with conditions", rule.Variant)
+		case rule.Weight == 0 && !conditional:
+			return fmt.Errorf("rule %q: needs conditions or weight", rule.Variant)
+		}
+		total += rule.Weight
+	}
+
+	if total > 100 {
+		return errors.New("total weight exceeds 100")
+	}
+	return nil
+}
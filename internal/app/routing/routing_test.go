@@ -0,0 +1,87 @@
+package routing
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36"
+	desktopUA = "Mozilla/5.0 (X11; Linux x86_64) Gecko/20100101 Firefox/125.0"
+)
+
+func TestSelect(t *testing.T) {
+	rules := []storage.RedirectRule{
+		{Variant: "ios", Platforms: []string{PlatformIOS}, URL: "https://apps.apple.com/app"},
+		{Variant: "android", Platforms: []string{PlatformAndroid}, URL: "https://play.google.com/app"},
+		{Variant: "de", Countries: []string{"DE"}, URL: "https://example.de/"},
+		{Variant: "ru", Languages: []string{"ru"}, URL: "https://example.ru/"},
+	}
+
+	tests := []struct {
+		name        string
+		ua          string
+		lang        string
+		country     string
+		wantVariant string
+	}{
+		{"iphone", iPhoneUA, "", "", "ios"},
+		{"android", androidUA, "", "DE", "android"},
+		{"desktop_germany", desktopUA, "", "de", "de"},
+		{"desktop_russian_region", desktopUA, "ru-RU,ru;q=0.9,en;q=0.8", "", "ru"},
+		{"language_by_preference", desktopUA, "en;q=0.5,ru;q=0.4", "", DefaultVariant},
+		{"desktop_default", desktopUA, "en-US", "US", DefaultVariant},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/abc", nil)
+			r.Header.Set("User-Agent", tt.ua)
+			r.Header.Set("Accept-Language", tt.lang)
+			r.Header.Set(DefaultGeoHeader, tt.country)
+
+			_, variant := Select(rules, FromHTTP(r, DefaultGeoHeader), "https://example.com/", func(int) int { return 0 })
+			assert.Equal(t, tt.wantVariant, variant)
+		})
+	}
+}
+
+func TestSelect_Split(t *testing.T) {
+	rules := []storage.RedirectRule{
+		{Variant: "a", Weight: 30, URL: "https://example.com/a"},
+		{Variant: "b", Weight: 20, URL: "https://example.com/b"},
+	}
+
+	for roll, want := range map[int]string{0: "a", 29: "a", 30: "b", 49: "b", 50: DefaultVariant, 99: DefaultVariant} {
+		_, variant := Select(rules, Request{}, "https://example.com/", func(int) int { return roll })
+		assert.Equal(t, want, variant, "roll %d", roll)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	rules := []storage.RedirectRule{
+		{Variant: "mobile", Platforms: []string{"iOS"}, Languages: []string{"EN-gb"}, Countries: []string{" us"}, URL: "https://example.com"},
+	}
+	require.NoError(t, Validate(rules))
+	assert.Equal(t, []string{"ios"}, rules[0].Platforms)
+	assert.Equal(t, []string{"en-GB"}, rules[0].Languages)
+	assert.Equal(t, []string{"US"}, rules[0].Countries)
+
+	bad := map[string][]storage.RedirectRule{
+		"no_variant":      {{Platforms: []string{"ios"}}},
+		"default_variant": {{Variant: DefaultVariant, Platforms: []string{"ios"}}},
+		"duplicate":       {{Variant: "a", Weight: 10}, {Variant: "a", Weight: 10}},
+		"platform":        {{Variant: "a", Platforms: []string{"symbian"}}},
+		"no_conditions":   {{Variant: "a"}},
+		"weight_and_cond": {{Variant: "a", Weight: 10, Platforms: []string{"ios"}}},
+		"weight_total":    {{Variant: "a", Weight: 60}, {Variant: "b", Weight: 50}},
+	}
+	for name, rules := range bad {
+		assert.Error(t, Validate(rules), name)
+	}
+}
@@ -0,0 +1,251 @@
+// Package screening проверяет адреса назначения по списку блокировок.
+//
+// Правила читаются из текстового файла, по одному на строку:
+//
+//	# комментарий
+//	domain evil.com        # сам домен и все его поддомены
+//	suffix .zip            # любой хост с таким окончанием
+//	regex  ^https?://[^/]+/wp-login\.php
+//	cidr   10.0.0.0/8      # хосты, заданные IP-адресом
+//
+// Национальные домены можно писать как есть, они приводятся к punycode,
+// как и хосты проверяемых адресов.
+package screening
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// результат проверки.
+type Verdict struct {
+	Blocked bool
+	Rule    string // сработавшее правило в виде "тип значение"
+}
+
+type regexRule struct {
+	source string
+	re     *regexp.Regexp
+}
+
+type cidrRule struct {
+	source string
+	net    *net.IPNet
+}
+
+// набор правил, после загрузки не меняется.
+type ruleSet struct {
+	domains  map[string]bool
+	suffixes []string
+	regexes  []regexRule
+	cidrs    []cidrRule
+}
+
+// Screener проверяет URL по правилам из файла и умеет перечитывать его на лету.
+type Screener struct {
+	path  string
+	rules atomic.Pointer[ruleSet]
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// создать проверяльщик и загрузить правила.
+func New(path string) (*Screener, error) {
+	s := &Screener{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload перечитывает файл правил. При ошибке остаются прежние правила.
+func (s *Screener) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open rules file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat rules file: %w", err)
+	}
+
+	rules, err := parseRules(f)
+	if err != nil {
+		return err
+	}
+
+	s.rules.Store(rules)
+	s.modTime = info.ModTime()
+
+	return nil
+}
+
+// Watch проверяет время изменения файла раз в interval и перечитывает его.
+// Ошибки перезагрузки передаются в onError.
+func (s *Screener) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				onError(err)
+				continue
+			}
+
+			s.mu.Lock()
+			changed := !info.ModTime().Equal(s.modTime)
+			s.mu.Unlock()
+
+			if changed {
+				if err := s.Reload(); err != nil {
+					onError(err)
+				}
+			}
+		}
+	}
+}
+
+// Check проверяет адрес. Неразбираемые адреса не блокируются:
+// их отсекает валидация при сокращении.
+func (s *Screener) Check(rawURL string) Verdict {
+	rules := s.rules.Load()
+	if rules == nil {
+		return Verdict{}
+	}
+
+	for _, r := range rules.regexes {
+		if r.re.MatchString(rawURL) {
+			return Verdict{Blocked: true, Rule: "regex " + r.source}
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Verdict{}
+	}
+
+	host := asciiHost(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return Verdict{}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, c := range rules.cidrs {
+			if c.net.Contains(ip) {
+				return Verdict{Blocked: true, Rule: "cidr " + c.source}
+			}
+		}
+		return Verdict{}
+	}
+
+	// домен и все родительские домены
+	for d := host; d != ""; {
+		if rules.domains[d] {
+			return Verdict{Blocked: true, Rule: "domain " + d}
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+
+	for _, suffix := range rules.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return Verdict{Blocked: true, Rule: "suffix " + suffix}
+		}
+	}
+
+	return Verdict{}
+}
+
+// хост в нижнем регистре и punycode, как его хранит нормализатор адресов.
+// Хост, который нельзя перевести, остается как есть.
+func asciiHost(host string) string {
+	host = strings.ToLower(host)
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		return ascii
+	}
+	return host
+}
+
+func parseRules(r io.Reader) (*ruleSet, error) {
+	rules := &ruleSet{domains: make(map[string]bool)}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kind, value, ok := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("line %d: rule must be \"<type> <value>\"", lineNo)
+		}
+
+		switch kind {
+		case "domain":
+			rules.domains[asciiHost(strings.TrimSuffix(value, "."))] = true
+		case "suffix":
+			// ведущая точка не часть метки, punycode считается без нее
+			label := strings.TrimPrefix(value, ".")
+			rules.suffixes = append(rules.suffixes, value[:len(value)-len(label)]+asciiHost(label))
+		case "regex":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			rules.regexes = append(rules.regexes, regexRule{source: value, re: re})
+		case "cidr":
+			if !strings.Contains(value, "/") {
+				if strings.Contains(value, ":") {
+					value += "/128"
+				} else {
+					value += "/32"
+				}
+			}
+			_, n, err := net.ParseCIDR(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			rules.cidrs = append(rules.cidrs, cidrRule{source: value, net: n})
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule type %q", lineNo, kind)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,112 @@
+package screening
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `# тестовые правила
+domain evil.com
+suffix .zip   # весь домен верхнего уровня
+regex ^https?://[^/]+/wp-login\.php
+cidr 10.0.0.0/8
+cidr 2001:db8::/32
+domain плохой.рф
+suffix .онлайн
+`
+
+func writeRules(t *testing.T, path, rules string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(rules), 0600))
+}
+
+func TestScreener_Check(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	writeRules(t, path, testRules)
+
+	s, err := New(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		url  string
+		rule string
+	}{
+		{"https://evil.com/", "domain evil.com"},
+		{"https://login.EVIL.com/x", "domain evil.com"},
+		{"https://notevil.com/", ""},
+		{"https://files.zip/a", "suffix .zip"},
+		{"https://example.com/wp-login.php", `regex ^https?://[^/]+/wp-login\.php`},
+		{"http://10.1.2.3/", "cidr 10.0.0.0/8"},
+		{"http://[2001:db8::1]/", "cidr 2001:db8::/32"},
+		{"http://192.168.0.1/", ""},
+		{"https://example.com/", ""},
+		// нормализованные адреса хранят хост в punycode
+		{"https://xn--i1adjac2b.xn--p1ai/", "domain xn--i1adjac2b.xn--p1ai"},
+		{"https://www.плохой.рф/", "domain xn--i1adjac2b.xn--p1ai"},
+		{"https://shop.xn--80asehdb/", "suffix .xn--80asehdb"},
+		{"https://хороший.рф/", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			v := s.Check(tt.url)
+			assert.Equal(t, tt.rule != "", v.Blocked)
+			assert.Equal(t, tt.rule, v.Rule)
+		})
+	}
+}
+
+func TestScreener_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	writeRules(t, path, "domain evil.com\n")
+
+	s, err := New(path)
+	require.NoError(t, err)
+	assert.False(t, s.Check("https://bad.org/").Blocked)
+
+	// битый файл не сбрасывает старые правила
+	writeRules(t, path, "bogus rule\n")
+	assert.Error(t, s.Reload())
+	assert.True(t, s.Check("https://evil.com/").Blocked)
+
+	writeRules(t, path, "domain bad.org\n")
+	require.NoError(t, s.Reload())
+	assert.True(t, s.Check("https://bad.org/").Blocked)
+	assert.False(t, s.Check("https://evil.com/").Blocked)
+}
+
+func TestScreener_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	writeRules(t, path, "domain evil.com\n")
+
+	s, err := New(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Watch(ctx, 10*time.Millisecond, func(err error) { t.Error(err) })
+
+	writeRules(t, path, "domain bad.org\n")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+
+	assert.Eventually(t, func() bool {
+		return s.Check("https://bad.org/").Blocked
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestParseRules_Errors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+
+	for _, rules := range []string{"domain\n", "regex [\n", "cidr 300.0.0.0/8\n", "host evil.com\n"} {
+		writeRules(t, path, rules)
+		_, err := New(path)
+		assert.Error(t, err, rules)
+	}
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"go.uber.org/zap"
+)
+
+// ClickCount - накопленные переходы на вариант ссылки.
+type ClickCount struct {
+	ShortCode string `json:"short_code"`
+	Variant   string `json:"variant"`
+	Clicks    int    `json:"clicks"`
+}
+
+// получатель пачки переходов.
+type ClickAdder interface {
+	AddClicks(counts []ClickCount) error
+}
+
+type clickKey struct {
+	shortCode, variant string
+}
+
+// ClickBatcher копит переходы по ссылкам без лимита и пишет их в хранилище пачкой,
+// чтобы редирект не ждал записи. Ссылки с лимитом переходов считаются сразу
+// через RegisterClick: там проверка и увеличение счетчика должны быть атомарными.
+type ClickBatcher struct {
+	mu       sync.Mutex
+	pending  map[clickKey]int
+	storage  ClickAdder
+	interval time.Duration
+}
+
+// создать накопитель, который сбрасывает переходы раз в interval.
+func NewClickBatcher(storage ClickAdder, interval time.Duration) *ClickBatcher {
+	return &ClickBatcher{
+		pending:  make(map[clickKey]int),
+		storage:  storage,
+		interval: interval,
+	}
+}
+
+// засчитать переход.
+func (b *ClickBatcher) Add(shortCode, variant string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[clickKey{shortCode, variant}]++
+}
+
+// записать накопленное. Если хранилище не приняло пачку, переходы
+// возвращаются в очередь до следующего сброса.
+func (b *ClickBatcher) Flush() error {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[clickKey]int)
+	b.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	counts := make([]ClickCount, 0, len(pending))
+	for k, n := range pending {
+		counts = append(counts, ClickCount{ShortCode: k.shortCode, Variant: k.variant, Clicks: n})
+	}
+	err := b.storage.AddClicks(counts)
+	if err == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for k, n := range pending {
+		b.pending[k] += n
+	}
+	return err
+}
+
+// Run сбрасывает переходы по расписанию до отмены контекста. Остаток после
+// остановки нужно записать через Flush до закрытия хранилища.
+func (b *ClickBatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				logger.Log.Error("failed to flush clicks", zap.Error(err))
+			}
+		}
+	}
+}
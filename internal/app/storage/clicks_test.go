@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClickBatcher_Flush(t *testing.T) {
+	m := new(MockURLStorage)
+	b := NewClickBatcher(m, time.Minute)
+
+	// пустой накопитель хранилище не трогает
+	assert.NoError(t, b.Flush())
+
+	b.Add("abc123", "default")
+	b.Add("abc123", "default")
+	b.Add("abc123", "mobile")
+
+	// неудачная пачка возвращается в очередь и уходит при следующем сбросе
+	m.On("AddClicks", mock.Anything).Return(errors.New("db is down")).Once()
+	assert.Error(t, b.Flush())
+
+	b.Add("abc123", "default")
+	m.On("AddClicks", mock.MatchedBy(func(counts []ClickCount) bool {
+		got := make(map[string]int)
+		for _, c := range counts {
+			got[c.ShortCode+"|"+c.Variant] = c.Clicks
+		}
+		return len(got) == 2 && got["abc123|default"] == 3 && got["abc123|mobile"] == 1
+	})).Return(nil).Once()
+	assert.NoError(t, b.Flush())
+
+	assert.NoError(t, b.Flush())
+	m.AssertExpectations(t)
+}
@@ -1,24 +1,39 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/buharamanya/shortener/internal/app/logger"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/buharamanya/shortener/internal/app/urlcrypt"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 )
 
 // type DBStorage struct.
 type DBStorage struct {
 	*sql.DB
+	dsn      string // для отдельного соединения LISTEN
+	replicas *replicaSet
+	// шифрование адресов; nil - адреса хранятся открыто
+	keys *urlcrypt.Keyring
 }
 
 // NewDBStorage.
-func NewDBStorage(dbDSN string) (*DBStorage, error) {
-	db, err := sql.Open("pgx", dbDSN)
+func NewDBStorage(dbDSN string, opts ...DBOption) (*DBStorage, error) {
+	o := dbOptions{window: defaultReadYourWritesWindow}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	db, err := openDB(dbDSN, o.pool)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка инициализации базы данных: %w", err)
 	}
@@ -31,7 +46,7 @@ func NewDBStorage(dbDSN string) (*DBStorage, error) {
 	createTableQuery := `
 	CREATE TABLE IF NOT EXISTS shorturl (
 		short_code 		VARCHAR(20) 	NOT NULL,
-		url  			VARCHAR 		NOT NULL UNIQUE,
+		url  			VARCHAR 		NOT NULL,
 		correlation_id  VARCHAR(200),
 		user_id			VARCHAR(100),
 		is_deleted		BOOLEAN 		NOT NULL DEFAULT FALSE		
@@ -41,45 +56,304 @@ func NewDBStorage(dbDSN string) (*DBStorage, error) {
 		return nil, fmt.Errorf("ошибка создания таблицы: %w", err)
 	}
 
+	createAccountsQuery := `
+	CREATE TABLE IF NOT EXISTS accounts (
+		user_id 		VARCHAR(100) 	PRIMARY KEY,
+		login  			VARCHAR(100) 	NOT NULL UNIQUE,
+		password_hash 	VARCHAR 		NOT NULL
+	)`
+
+	if _, err = db.Exec(createAccountsQuery); err != nil {
+		return nil, fmt.Errorf("ошибка создания таблицы аккаунтов: %w", err)
+	}
+
+	createTeamsQuery := `
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS team_id VARCHAR(100);
+	CREATE TABLE IF NOT EXISTS teams (
+		id 				VARCHAR(100) 	PRIMARY KEY,
+		name  			VARCHAR 		NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS team_members (
+		team_id 		VARCHAR(100) 	NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+		user_id  		VARCHAR(100) 	NOT NULL,
+		role 			VARCHAR(20) 	NOT NULL,
+		PRIMARY KEY (team_id, user_id)
+	)`
+
+	if _, err = db.Exec(createTeamsQuery); err != nil {
+		return nil, fmt.Errorf("ошибка создания таблиц команд: %w", err)
+	}
+
+	alterRecordsQuery := `
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS password_hash VARCHAR;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS max_clicks INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS clicks INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS active_from TIMESTAMPTZ;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS active_until TIMESTAMPTZ;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS fallback_url VARCHAR;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS rules JSONB;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS forwarding JSONB;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS redirect_status INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS title VARCHAR;
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+	CREATE TABLE IF NOT EXISTS variant_clicks (
+		short_code 		VARCHAR(50) 	NOT NULL,
+		variant  		VARCHAR(100) 	NOT NULL,
+		clicks 			INTEGER 		NOT NULL DEFAULT 0,
+		PRIMARY KEY (short_code, variant)
+	)`
+
+	if _, err = db.Exec(alterRecordsQuery); err != nil {
+		return nil, fmt.Errorf("ошибка обновления таблицы: %w", err)
+	}
+
+	createTagsQuery := `
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS notes VARCHAR;
+	CREATE TABLE IF NOT EXISTS url_tags (
+		short_code 		VARCHAR(50) 	NOT NULL,
+		tag  			VARCHAR(50) 	NOT NULL,
+		PRIMARY KEY (short_code, tag)
+	);
+	CREATE INDEX IF NOT EXISTS url_tags_tag_idx ON url_tags (tag);
+	CREATE INDEX IF NOT EXISTS shorturl_short_code_idx ON shorturl (short_code);
+	CREATE INDEX IF NOT EXISTS shorturl_search_idx ON shorturl USING GIN (` + searchVector + `)`
+
+	if _, err = db.Exec(createTagsQuery); err != nil {
+		return nil, fmt.Errorf("ошибка создания таблицы тегов: %w", err)
+	}
+
+	createWebhooksQuery := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id 				VARCHAR(100) 	PRIMARY KEY,
+		user_id  		VARCHAR(100) 	NOT NULL,
+		url 			VARCHAR 		NOT NULL,
+		secret 			VARCHAR 		NOT NULL,
+		events 			JSONB 			NOT NULL,
+		created_at 		TIMESTAMPTZ 	NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS webhooks_user_idx ON webhooks (user_id);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id 				VARCHAR(250) 	PRIMARY KEY,
+		webhook_id 		VARCHAR(100) 	NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+		event_id 		VARCHAR(100) 	NOT NULL,
+		event_type 		VARCHAR(50) 	NOT NULL,
+		payload 		JSONB 			NOT NULL,
+		status 			VARCHAR(20) 	NOT NULL,
+		attempts 		INTEGER 		NOT NULL DEFAULT 0,
+		response_code 	INTEGER 		NOT NULL DEFAULT 0,
+		error 			VARCHAR,
+		next_attempt_at TIMESTAMPTZ 	NOT NULL,
+		created_at 		TIMESTAMPTZ 	NOT NULL,
+		updated_at 		TIMESTAMPTZ 	NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at)`
+
+	if _, err = db.Exec(createWebhooksQuery); err != nil {
+		return nil, fmt.Errorf("ошибка создания таблиц вебхуков: %w", err)
+	}
+
+	// адрес уникален только среди обычных ссылок, у ссылок с настройками свои коды.
+	// Слепой индекс заменяет адрес, когда адреса зашифрованы. Код уникален всегда.
+	createURLHashQuery := `
+	ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS url_hash VARCHAR(64);
+	CREATE UNIQUE INDEX IF NOT EXISTS shorturl_short_code_key ON shorturl (short_code);
+	ALTER TABLE shorturl DROP CONSTRAINT IF EXISTS shorturl_url_key;
+	DROP INDEX IF EXISTS shorturl_url_hash_idx;
+	DROP INDEX IF EXISTS shorturl_plain_url_idx;
+	DROP INDEX IF EXISTS shorturl_plain_url_hash_idx;
+	CREATE UNIQUE INDEX IF NOT EXISTS shorturl_plain_link_url_idx ON shorturl (url) WHERE ` + plainLinkCondition + `;
+	CREATE UNIQUE INDEX IF NOT EXISTS shorturl_plain_link_url_hash_idx ON shorturl (url_hash) WHERE ` + plainLinkCondition
+
+	if _, err = db.Exec(createURLHashQuery); err != nil {
+		return nil, fmt.Errorf("ошибка создания индекса адресов: %w", err)
+	}
+
+	replicas, err := newReplicaSet(o.replicas, o.pool, o.window)
+	if err != nil {
+		return nil, err
+	}
+
 	return &DBStorage{
-		DB: db,
+		DB:       db,
+		dsn:      dbDSN,
+		replicas: replicas,
+		keys:     o.keys,
 	}, nil
 }
 
+// обычная ссылка в SQL, то же, что ShortURLRecord.Plain.
+const plainLinkCondition = `password_hash IS NULL AND max_clicks = 0 AND active_from IS NULL AND active_until IS NULL
+	AND forwarding IS NULL AND rules IS NULL AND redirect_status = 0 AND team_id IS NULL`
+
+// колонки записи в порядке scanRecord.
+const recordColumns = `short_code, url, COALESCE(correlation_id, ''), COALESCE(user_id, ''),
+	COALESCE(team_id, ''), is_deleted, COALESCE(password_hash, ''), max_clicks, clicks,
+	active_from, active_until, COALESCE(fallback_url, ''), rules, forwarding, redirect_status,
+	COALESCE(title, ''), COALESCE(notes, ''), created_at,
+	(SELECT COALESCE(json_agg(tag ORDER BY tag), '[]') FROM url_tags WHERE url_tags.short_code = shorturl.short_code)`
+
+// текст для полнотекстового поиска, совпадает с выражением индекса.
+const searchVector = `to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(notes, ''))`
+
+// запрос на вставку записи и его аргументы.
+const insertRecordQuery = `INSERT INTO shorturl (short_code, url, correlation_id, user_id, team_id,
+	password_hash, max_clicks, active_from, active_until, fallback_url, rules, forwarding, redirect_status, title, notes, created_at,
+	is_deleted, clicks, url_hash)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, NULLIF($10, ''), $11, $12, $13,
+	NULLIF($14, ''), NULLIF($15, ''), COALESCE($16, now()), $17, $18, NULLIF($19, ''))`
+
+func (db *DBStorage) insertRecordArgs(r ShortURLRecord) ([]interface{}, error) {
+	sealed, err := sealRecord(db.keys, r)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{r.ShortCode, sealed.OriginalURL, r.CorrelationID, r.UserID, r.TeamID,
+		r.PasswordHash, r.MaxClicks, r.ActiveFrom, r.ActiveUntil, sealed.FallbackURL,
+		toJSONB(sealed.Rules, len(sealed.Rules) > 0), toJSONB(r.Forwarding, r.Forwarding.Enabled()), r.RedirectStatus,
+		r.Title, r.Notes, nullTime(r.CreatedAt), r.DeletedFlag, r.Clicks, db.keys.BlindIndex(r.OriginalURL)}, nil
+}
+
+// время для колонки, нулевое хранится как NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// значение для колонки jsonb, незаданное хранится как NULL.
+func toJSONB(v interface{}, set bool) interface{} {
+	if !set {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
+
+// прочитать запись, выбранную по recordColumns, и расшифровать адреса.
+func scanRecord(row interface{ Scan(...interface{}) error }, keys *urlcrypt.Keyring) (ShortURLRecord, error) {
+	var r ShortURLRecord
+	var rules, forwarding, tags []byte
+	err := row.Scan(&r.ShortCode, &r.OriginalURL, &r.CorrelationID, &r.UserID, &r.TeamID, &r.DeletedFlag, &r.PasswordHash,
+		&r.MaxClicks, &r.Clicks, &r.ActiveFrom, &r.ActiveUntil, &r.FallbackURL, &rules, &forwarding,
+		&r.RedirectStatus, &r.Title, &r.Notes, &r.CreatedAt, &tags)
+	if err == nil && len(rules) > 0 {
+		err = json.Unmarshal(rules, &r.Rules)
+	}
+	if err == nil {
+		r, err = openRecord(keys, r)
+	}
+	if err == nil && len(forwarding) > 0 {
+		err = json.Unmarshal(forwarding, &r.Forwarding)
+	}
+	if err == nil && len(tags) > 0 {
+		err = json.Unmarshal(tags, &r.Tags)
+	}
+	if len(r.Tags) == 0 {
+		r.Tags = nil
+	}
+	return r, err
+}
+
+// заменить теги записи.
+func saveTags(tx *sql.Tx, shortCode string, tags []string) error {
+	if _, err := tx.Exec(`DELETE FROM url_tags WHERE short_code = $1`, shortCode); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec(`INSERT INTO url_tags (short_code, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING`, shortCode, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // сохранить.
 func (db *DBStorage) Save(record ShortURLRecord) error {
-	query := `INSERT INTO shorturl (short_code, url, user_id, correlation_id) VALUES ($1, $2, $3, $4)`
-	_, err := db.Exec(query, record.ShortCode, record.OriginalURL, record.UserID, record.CorrelationID)
-	return err
+	return db.SaveBatch([]ShortURLRecord{record})
 }
 
 // много сохранить.
 func (db *DBStorage) SaveBatch(records []ShortURLRecord) error {
-	query := `INSERT INTO shorturl (short_code, url, correlation_id, user_id) VALUES ($1, $2, $3, $4)`
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	for _, v := range records {
 		// все изменения записываются в транзакцию
-		_, err := tx.Exec(query, v.ShortCode, v.OriginalURL, v.CorrelationID, v.UserID)
+		args, err := db.insertRecordArgs(v)
+		if err == nil {
+			_, err = tx.Exec(insertRecordQuery, args...)
+		}
+		if err == nil {
+			err = saveTags(tx, v.ShortCode, v.Tags)
+		}
 		if err != nil {
 			// если ошибка, то откатываем изменения
 			tx.Rollback()
-			return err
+			return db.conflict(v, err)
 		}
 	}
+	// другие реплики могли закэшировать эти коды как неизвестные
+	codes := make([]string, 0, len(records))
+	for _, v := range records {
+		codes = append(codes, v.ShortCode)
+	}
+	if err := notifyLinks(tx, codes...); err != nil {
+		tx.Rollback()
+		return err
+	}
 	// завершаем транзакцию
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, v := range records {
+		db.replicas.wrote(v.UserID)
+	}
+	return nil
+}
+
+// если обычная ссылка на адрес записи уже есть, вернуть *ConflictError с ее кодом,
+// если занят код - *CodeTakenError. Ищем на основной базе: реплика может еще
+// не видеть мешающую запись.
+func (db *DBStorage) conflict(record ShortURLRecord, err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+		return err
+	}
+	if pgErr.ConstraintName == "shorturl_short_code_key" {
+		return &CodeTakenError{ShortCode: record.ShortCode}
+	}
+	if !record.Plain() {
+		return err
+	}
+
+	query := `SELECT short_code FROM shorturl WHERE ` + plainLinkCondition + ` AND url = $1`
+	arg := record.OriginalURL
+	if hash := db.keys.BlindIndex(record.OriginalURL); hash != "" {
+		query = `SELECT short_code FROM shorturl WHERE ` + plainLinkCondition + ` AND url_hash = $1`
+		arg = hash
+	}
+	var shortCode string
+	if lookupErr := db.QueryRow(query, arg).Scan(&shortCode); lookupErr != nil {
+		logger.Log.Warn("failed to find conflicting link", zap.Error(lookupErr))
+		return err
+	}
+	return &ConflictError{ShortCode: shortCode, Err: err}
 }
 
 // получить.
 func (db *DBStorage) Get(shortCode string) (string, error) {
 	query := `SELECT url, is_deleted FROM shorturl WHERE short_code = $1 LIMIT 1`
-	row := db.QueryRow(query, shortCode)
 	var url string
 	var isDeleted bool
-	err := row.Scan(&url, &isDeleted)
+	err := db.read("", func(conn *sql.DB) error {
+		return conn.QueryRow(query, shortCode).Scan(&url, &isDeleted)
+	})
 	if err != nil {
 		logger.Log.Error("Не нашел записи по запросу", zap.Error(err))
 		return "", err
@@ -87,24 +361,299 @@ func (db *DBStorage) Get(shortCode string) (string, error) {
 	if isDeleted {
 		return "", ErrDeleted
 	}
-	return url, nil
+	return db.keys.Open(url)
+}
+
+// получить запись целиком. Для удаленной записи возвращается и она, и ErrDeleted.
+func (db *DBStorage) GetRecord(shortCode string) (ShortURLRecord, error) {
+	query := `SELECT ` + recordColumns + ` FROM shorturl WHERE short_code = $1 LIMIT 1`
+	var record ShortURLRecord
+	err := db.read("", func(conn *sql.DB) (err error) {
+		record, err = scanRecord(conn.QueryRow(query, shortCode), db.keys)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShortURLRecord{}, ErrNotFound
+	}
+	if err != nil {
+		return ShortURLRecord{}, err
+	}
+	if record.DeletedFlag {
+		return record, ErrDeleted
+	}
+	return record, nil
+}
+
+// засчитать переход на вариант. Проверка лимита и увеличение счетчиков делаются
+// одним запросом, поэтому параллельные переходы не превысят max_clicks.
+func (db *DBStorage) RegisterClick(shortCode, variant string) error {
+	query := `WITH clicked AS (
+			UPDATE shorturl SET clicks = clicks + 1
+			WHERE short_code = $1 AND NOT is_deleted AND (max_clicks = 0 OR clicks < max_clicks)
+			RETURNING short_code
+		)
+		INSERT INTO variant_clicks (short_code, variant, clicks)
+		SELECT short_code, $2, 1 FROM clicked
+		ON CONFLICT (short_code, variant) DO UPDATE SET clicks = variant_clicks.clicks + 1
+		RETURNING clicks`
+
+	var clicks int
+	err := db.QueryRow(query, shortCode, variant).Scan(&clicks)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// ничего не обновили: выясняем почему
+	if _, err = db.GetRecord(shortCode); err != nil {
+		return err
+	}
+	return ErrExhausted
+}
+
+// записать пачку переходов по ссылкам без лимита одной транзакцией.
+// Удаленные и неизвестные ссылки пропускаются.
+func (db *DBStorage) AddClicks(counts []ClickCount) error {
+	query := `WITH clicked AS (
+			UPDATE shorturl SET clicks = clicks + $3
+			WHERE short_code = $1 AND NOT is_deleted
+			RETURNING short_code
+		)
+		INSERT INTO variant_clicks (short_code, variant, clicks)
+		SELECT short_code, $2, $3 FROM clicked
+		ON CONFLICT (short_code, variant) DO UPDATE SET clicks = variant_clicks.clicks + EXCLUDED.clicks`
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, c := range counts {
+		if _, err := tx.Exec(query, c.ShortCode, c.Variant, c.Clicks); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// обновить настройки записи. Адрес, владелец и счетчик переходов не меняются.
+func (db *DBStorage) UpdateRecord(record ShortURLRecord) error {
+	query := `UPDATE shorturl SET password_hash = NULLIF($2, ''), max_clicks = $3,
+		active_from = $4, active_until = $5, fallback_url = NULLIF($6, ''), rules = $7,
+		forwarding = $8, redirect_status = $9, title = NULLIF($10, ''), notes = NULLIF($11, '')
+		WHERE short_code = $1`
+
+	sealed, err := sealRecord(db.keys, record)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(query, record.ShortCode, record.PasswordHash, record.MaxClicks,
+		record.ActiveFrom, record.ActiveUntil, sealed.FallbackURL,
+		toJSONB(sealed.Rules, len(sealed.Rules) > 0), toJSONB(record.Forwarding, record.Forwarding.Enabled()),
+		record.RedirectStatus, record.Title, record.Notes)
+	if err != nil {
+		tx.Rollback()
+		return db.conflict(record, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		tx.Rollback()
+		return ErrNotFound
+	}
+	if err := saveTags(tx, record.ShortCode, record.Tags); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := notifyLinks(tx, record.ShortCode); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.replicas.wrote(record.UserID)
+	return nil
+}
+
+// переходы по вариантам ссылки.
+func (db *DBStorage) GetVariantClicks(shortCode string) (map[string]int, error) {
+	rows, err := db.Query(`SELECT variant, clicks FROM variant_clicks WHERE short_code = $1`, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clicks := make(map[string]int)
+	for rows.Next() {
+		var variant string
+		var n int
+		if err := rows.Scan(&variant, &n); err != nil {
+			return nil, err
+		}
+		clicks[variant] = n
+	}
+	return clicks, rows.Err()
 }
 
 // получить по пользаку.
 func (db *DBStorage) GetURLsByUserID(userID string) ([]ShortURLRecord, error) {
-	query := `SELECT short_code, url, correlation_id, user_id
+	query := `SELECT ` + recordColumns + `
 		FROM shorturl
 		WHERE user_id = $1`
 
+	var urls []ShortURLRecord
+	err := db.read(userID, func(conn *sql.DB) (err error) {
+		urls, err = queryRecords(conn, db.keys, query, userID)
+		return err
+	})
+	return urls, err
+}
+
+// страница всех записей, включая удаленные, с кодами после afterShortCode по возрастанию.
+func (db *DBStorage) ListRecords(afterShortCode string, limit int) ([]ShortURLRecord, error) {
+	query := `SELECT ` + recordColumns + `
+		FROM shorturl
+		WHERE short_code > $1
+		ORDER BY short_code
+		LIMIT $2`
+
+	return db.queryRecords(query, afterShortCode, limit)
+}
+
+// согласованный снимок всех записей, включая удаленные, по возрастанию кода:
+// чтение идет одним запросом в транзакции REPEATABLE READ.
+func (db *DBStorage) ExportRecords(fn func(ShortURLRecord) error) error {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT ` + recordColumns + ` FROM shorturl ORDER BY short_code`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanRecord(rows, db.keys)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// заменить все записи ссылок одной транзакцией.
+func (db *DBStorage) RestoreRecords(records []ShortURLRecord) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM url_tags`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM shorturl`); err != nil {
+		return err
+	}
+	for _, record := range records {
+		args, err := db.insertRecordArgs(record)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(insertRecordQuery, args...); err != nil {
+			return fmt.Errorf("restore %q: %w", record.ShortCode, err)
+		}
+		if err := saveTags(tx, record.ShortCode, record.Tags); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM variant_clicks WHERE short_code NOT IN (SELECT short_code FROM shorturl)`); err != nil {
+		return err
+	}
+	if err := notifyLinks(tx, notifyAll); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// найти урлы пользака по тегу и тексту. Слова ищутся полнотекстовым поиском
+// по заголовку и заметкам, адрес - по подстроке без учета регистра.
+func (db *DBStorage) SearchURLsByUserID(userID string, filter URLFilter) ([]ShortURLRecord, error) {
+	query := `SELECT ` + recordColumns + `
+		FROM shorturl
+		WHERE user_id = $1
+		AND ($2 = '' OR EXISTS (SELECT 1 FROM url_tags WHERE url_tags.short_code = shorturl.short_code AND tag = $2))
+		AND ($3 = '' OR ` + searchVector + ` @@ plainto_tsquery('simple', $3) OR url ILIKE $4)`
+
+	var urls []ShortURLRecord
+	err := db.read(userID, func(conn *sql.DB) (err error) {
+		urls, err = queryRecords(conn, db.keys, query, userID, filter.Tag, filter.Query, "%"+escapeLike(filter.Query)+"%")
+		if err != nil || db.keys == nil || filter.Query == "" {
+			return err
+		}
+		// зашифрованный адрес в базе не найти, подстроку ищем после расшифровки
+		all, err := queryRecords(conn, db.keys, query, userID, filter.Tag, "", "")
+		if err != nil {
+			return err
+		}
+		urls = appendURLMatches(urls, all, filter.Query)
+		return nil
+	})
+	return urls, err
+}
+
+// добавить к found записи из all, адрес которых содержит q без учета регистра.
+func appendURLMatches(found, all []ShortURLRecord, q string) []ShortURLRecord {
+	seen := make(map[string]bool, len(found))
+	for _, r := range found {
+		seen[r.ShortCode] = true
+	}
+	q = strings.ToLower(q)
+	for _, r := range all {
+		if !seen[r.ShortCode] && strings.Contains(strings.ToLower(r.OriginalURL), q) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// экранировать спецсимволы LIKE.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// получить урлы команды.
+func (db *DBStorage) GetURLsByTeamID(teamID string) ([]ShortURLRecord, error) {
+	query := `SELECT ` + recordColumns + `
+		FROM shorturl
+		WHERE team_id = $1`
+
+	return db.queryRecords(query, teamID)
+}
+
+func (db *DBStorage) queryRecords(query string, args ...interface{}) ([]ShortURLRecord, error) {
+	return queryRecords(db.DB, db.keys, query, args...)
+}
+
+// записи по запросу к primary или реплике.
+func queryRecords(conn *sql.DB, keys *urlcrypt.Keyring, query string, args ...interface{}) ([]ShortURLRecord, error) {
 	urls := []ShortURLRecord{}
-	rows, err := db.Query(query, userID)
+	rows, err := conn.Query(query, args...)
 	if err != nil {
 		return []ShortURLRecord{}, fmt.Errorf("failed to execute query: %w", err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var u ShortURLRecord
-		err = rows.Scan(&u.ShortCode, &u.OriginalURL, &u.CorrelationID, &u.UserID)
+		u, err := scanRecord(rows, keys)
 		if err != nil {
 			return []ShortURLRecord{}, fmt.Errorf("failed to scan query: %w", err)
 		}
@@ -119,31 +668,382 @@ func (db *DBStorage) GetURLsByUserID(userID string) ([]ShortURLRecord, error) {
 	return urls, nil
 }
 
-// удалить.
-func (db *DBStorage) DeleteURLs(shortCodes []string, userID string) error {
+// удалить. Возвращает записи, которые действительно удалены этим вызовом.
+func (db *DBStorage) DeleteURLs(shortCodes []string, userID string) ([]ShortURLRecord, error) {
 
 	if len(shortCodes) == 0 {
-		return nil
+		return nil, nil
+	}
+
+	// личные урлы удаляет владелец, командные - редактор или владелец команды
+	query := `UPDATE shorturl SET is_deleted = true
+		WHERE short_code IN (` + placeholders(2, len(shortCodes)) + `)
+		AND NOT is_deleted
+		AND (
+			(team_id IS NULL AND user_id = $1)
+			OR team_id IN (SELECT team_id FROM team_members WHERE user_id = $1 AND role IN ('owner', 'editor'))
+		)
+		RETURNING ` + recordColumns
+	args := make([]interface{}, 0, len(shortCodes)+1)
+	args = append(args, userID)
+	for _, sc := range shortCodes {
+		args = append(args, sc)
+	}
+	deleted, err := db.queryRecords(query, args...)
+	if err != nil || len(deleted) == 0 {
+		return deleted, err
+	}
+	db.replicas.wrote(userID)
+
+	codes := make([]string, len(deleted))
+	for i, r := range deleted {
+		codes[i] = r.ShortCode
+	}
+	if err := notifyLinks(db.DB, codes...); err != nil {
+		// удаление уже прошло, остальные реплики увидят его после пересинхронизации
+		logger.Log.Warn("failed to notify about deleted links", zap.Error(err))
+	}
+	return deleted, nil
+}
+
+// сохранить аккаунт.
+func (db *DBStorage) SaveAccount(account Account) error {
+	query := `INSERT INTO accounts (user_id, login, password_hash) VALUES ($1, $2, $3)`
+	_, err := db.Exec(query, account.UserID, account.Login, account.PasswordHash)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return ErrLoginTaken
+	}
+	return err
+}
+
+// получить аккаунт по логину.
+func (db *DBStorage) GetAccountByLogin(login string) (Account, error) {
+	query := `SELECT user_id, login, password_hash FROM accounts WHERE login = $1`
+	var account Account
+	err := db.QueryRow(query, login).Scan(&account.UserID, &account.Login, &account.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, ErrNotFound
+	}
+	return account, err
+}
+
+// передать урлы другому пользаку.
+func (db *DBStorage) ReassignURLs(fromUserID, toUserID string) error {
+	query := `UPDATE shorturl SET user_id = $2 WHERE user_id = $1`
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(query, fromUserID, toUserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// какие коды затронуты, не считаем - реплики сбросят все
+	if err := notifyLinks(tx, notifyAll); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.replicas.wrote(fromUserID, toUserID)
+	return nil
+}
+
+// создать команду вместе с владельцем.
+func (db *DBStorage) SaveTeam(team Team, ownerID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO teams (id, name) VALUES ($1, $2)`, team.ID, team.Name); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO team_members (team_id, user_id, role) VALUES ($1, $2, $3)`, team.ID, ownerID, RoleOwner); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// добавить участника или сменить ему роль.
+func (db *DBStorage) SaveTeamMember(member TeamMember) error {
+	query := `INSERT INTO team_members (team_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (team_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+	if member.Role == RoleOwner {
+		_, err := db.Exec(query, member.TeamID, member.UserID, member.Role)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+			return ErrNotFound
+		}
+		return err
+	}
+	return db.changeMember(member.TeamID, member.UserID, query, member.TeamID, member.UserID, member.Role)
+}
+
+// исключить участника.
+func (db *DBStorage) DeleteTeamMember(teamID, userID string) error {
+	return db.changeMember(teamID, userID, `DELETE FROM team_members WHERE team_id = $1 AND user_id = $2`, teamID, userID)
+}
+
+// понизить или исключить участника, если он не последний владелец. Строка команды
+// блокируется, так что параллельные изменения состава не оставят команду без владельца.
+func (db *DBStorage) changeMember(teamID, userID, query string, args ...interface{}) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked string
+	err = tx.QueryRow(`SELECT id FROM teams WHERE id = $1 FOR UPDATE`, teamID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var lastOwner bool
+	err = tx.QueryRow(`SELECT COALESCE(bool_and(user_id = $2), false) FROM team_members
+		WHERE team_id = $1 AND role = $3`, teamID, userID, RoleOwner).Scan(&lastOwner)
+	if err != nil {
+		return err
+	}
+	if lastOwner {
+		return ErrLastOwner
+	}
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// роль пользака в команде.
+func (db *DBStorage) GetTeamRole(teamID, userID string) (Role, error) {
+	var role Role
+	err := db.QueryRow(`SELECT role FROM team_members WHERE team_id = $1 AND user_id = $2`, teamID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return role, err
+}
+
+// участники команды.
+func (db *DBStorage) GetTeamMembers(teamID string) ([]TeamMember, error) {
+	query := `SELECT m.team_id, t.name, m.user_id, m.role
+		FROM team_members m JOIN teams t ON t.id = m.team_id
+		WHERE m.team_id = $1`
+	return db.queryMembers(query, teamID)
+}
+
+// команды пользака.
+func (db *DBStorage) GetTeamsByUserID(userID string) ([]TeamMember, error) {
+	query := `SELECT m.team_id, t.name, m.user_id, m.role
+		FROM team_members m JOIN teams t ON t.id = m.team_id
+		WHERE m.user_id = $1`
+	return db.queryMembers(query, userID)
+}
+
+func (db *DBStorage) queryMembers(query string, args ...interface{}) ([]TeamMember, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var members []TeamMember
+	for rows.Next() {
+		var m TeamMember
+		if err := rows.Scan(&m.TeamID, &m.TeamName, &m.UserID, &m.Role); err != nil {
+			return nil, fmt.Errorf("failed to scan query: %w", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// сохранить подписку.
+func (db *DBStorage) SaveWebhook(webhook Webhook) error {
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO webhooks (id, user_id, url, secret, events, created_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET url = EXCLUDED.url, secret = EXCLUDED.secret, events = EXCLUDED.events`
+	_, err = db.Exec(query, webhook.ID, webhook.UserID, webhook.URL, webhook.Secret, string(events), webhook.CreatedAt)
+	return err
+}
+
+// колонки подписки в порядке scanWebhook.
+const webhookColumns = `id, user_id, url, secret, events, created_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (Webhook, error) {
+	var w Webhook
+	var events []byte
+	err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.CreatedAt)
+	if err == nil {
+		err = json.Unmarshal(events, &w.Events)
+	}
+	return w, err
+}
+
+// подписка по идентификатору.
+func (db *DBStorage) GetWebhook(id string) (Webhook, error) {
+	webhook, err := scanWebhook(db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Webhook{}, ErrNotFound
+	}
+	return webhook, err
+}
+
+// подписки пользака.
+func (db *DBStorage) GetWebhooksByUserID(userID string) ([]Webhook, error) {
+	rows, err := db.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan query: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// удалить подписку, доставки удаляются каскадом.
+func (db *DBStorage) DeleteWebhook(id, userID string) error {
+	res, err := db.Exec(`DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
 	}
+	return nil
+}
 
-	query := `UPDATE shorturl SET is_deleted = true WHERE user_id = '` + userID + `' and short_code IN (` + placeholders(len(shortCodes)) + `)`
-	args := make([]interface{}, len(shortCodes))
-	for i, sc := range shortCodes {
-		args[i] = sc
+// поставить событие в очередь всем подпискам пользака на этот тип одним запросом.
+func (db *DBStorage) EnqueueWebhookEvent(event WebhookEvent, now time.Time) error {
+	payload, err := sealPayload(db.keys, event.Payload)
+	if err != nil {
+		return err
 	}
-	_, err := db.Exec(query, args...)
+	query := `INSERT INTO webhook_deliveries (id, webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at, updated_at)
+		SELECT $1 || '.' || id, id, $1, $2, $3, $4, $5, $5, $5
+		FROM webhooks
+		WHERE user_id = $6 AND events @> jsonb_build_array($2::text)
+		ON CONFLICT (id) DO NOTHING`
+	_, err = db.Exec(query, event.ID, event.Type, string(payload), DeliveryPending, now, event.UserID)
 	return err
 }
 
+// колонки доставки в порядке scanDelivery.
+const deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, response_code,
+	COALESCE(error, ''), next_attempt_at, created_at, updated_at`
+
+func scanDelivery(row interface{ Scan(...interface{}) error }, keys *urlcrypt.Keyring) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload []byte
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.ResponseCode, &d.Error, &d.NextAttemptAt, &d.CreatedAt, &d.UpdatedAt)
+	if err == nil {
+		d.Payload, err = openPayload(keys, payload)
+	}
+	return d, err
+}
+
+func (db *DBStorage) queryDeliveries(query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows, db.keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan query: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// забрать доставки, срок которых подошел. SKIP LOCKED не дает двум экземплярам взять одну доставку.
+func (db *DBStorage) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	query := `UPDATE webhook_deliveries SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $3 AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + deliveryColumns
+	return db.queryDeliveries(query, now, now.Add(lease), DeliveryPending, limit)
+}
+
+// сохранить результат попытки доставки.
+func (db *DBStorage) UpdateWebhookDelivery(d WebhookDelivery) error {
+	query := `UPDATE webhook_deliveries SET status = $2, attempts = $3, response_code = $4,
+		error = NULLIF($5, ''), next_attempt_at = $6, updated_at = $7
+		WHERE id = $1`
+	res, err := db.Exec(query, d.ID, d.Status, d.Attempts, d.ResponseCode, d.Error, d.NextAttemptAt, d.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// последние доставки подписки.
+func (db *DBStorage) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+		WHERE webhook_id = $1 ORDER BY created_at DESC LIMIT $2`
+	return db.queryDeliveries(query, webhookID, limit)
+}
+
+// удалить старые завершенные доставки одним запросом.
+func (db *DBStorage) PruneWebhookDeliveries(before time.Time, keep int) (int, error) {
+	query := `DELETE FROM webhook_deliveries WHERE id IN (
+			SELECT id FROM (
+				SELECT id, updated_at,
+					row_number() OVER (PARTITION BY webhook_id ORDER BY created_at DESC) AS n
+				FROM webhook_deliveries
+				WHERE status <> $1
+			) done
+			WHERE updated_at < $2 OR ($3 > 0 AND n > $3)
+		)`
+	res, err := db.Exec(query, DeliveryPending, before, keep)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
 // Close - закрывает соединение с базой данных.
 func (db *DBStorage) Close() error {
-	return db.DB.Close()
+	return errors.Join(db.DB.Close(), db.replicas.Close())
 }
 
-func placeholders(n int) string {
+// плейсхолдеры $start..$start+n-1.
+func placeholders(start, n int) string {
 	ph := make([]string, n)
 	for i := range ph {
-		ph[i] = "$" + strconv.Itoa(i+1)
+		ph[i] = "$" + strconv.Itoa(start+i)
 	}
 	return strings.Join(ph, ",")
 }
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// база для тестов из TEST_DATABASE_DSN, без нее тесты пропускаются.
+func openTestDB(t *testing.T) *DBStorage {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := NewDBStorage(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDBStorage_PlainLinkConflict(t *testing.T) {
+	db := openTestDB(t)
+	// свой адрес на каждый запуск, чтобы не чистить таблицу
+	url := "https://example.com/" + uuid.NewString()
+	code := func() string { return uuid.NewString()[:8] }
+
+	plain := ShortURLRecord{ShortCode: code(), OriginalURL: url, UserID: "user-1"}
+	require.NoError(t, db.Save(plain))
+
+	// ссылки с настройками на тот же адрес не мешают ни друг другу, ни обычной
+	for _, r := range []ShortURLRecord{
+		{ShortCode: code(), OriginalURL: url, UserID: "user-1", PasswordHash: "hash"},
+		{ShortCode: code(), OriginalURL: url, UserID: "user-1", PasswordHash: "hash"},
+		{ShortCode: code(), OriginalURL: url, UserID: "user-2", MaxClicks: 1},
+	} {
+		require.NoError(t, db.Save(r))
+		stored, err := db.GetRecord(r.ShortCode)
+		require.NoError(t, err)
+		assert.Equal(t, url, stored.OriginalURL)
+	}
+
+	// вторая обычная ссылка получает код той, что уже сохранена
+	err := db.Save(ShortURLRecord{ShortCode: code(), OriginalURL: url, UserID: "user-2"})
+	var conflict *ConflictError
+	require.True(t, errors.As(err, &conflict), "expected ConflictError, got %v", err)
+	assert.Equal(t, plain.ShortCode, conflict.ShortCode)
+
+	// снять с защищенной ссылки все настройки тоже нельзя
+	limited := ShortURLRecord{ShortCode: code(), OriginalURL: url, UserID: "user-1", MaxClicks: 5}
+	require.NoError(t, db.Save(limited))
+	limited.MaxClicks = 0
+	err = db.UpdateRecord(limited)
+	require.True(t, errors.As(err, &conflict), "expected ConflictError, got %v", err)
+	assert.Equal(t, plain.ShortCode, conflict.ShortCode)
+}
+
+func TestDBStorage_RegisterClickLimit(t *testing.T) {
+	db := openTestDB(t)
+	code := uuid.NewString()[:8]
+	require.NoError(t, db.Save(ShortURLRecord{ShortCode: code, OriginalURL: "https://example.com/" + code, MaxClicks: 3}))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := db.RegisterClick(code, "default")
+			if err == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, ErrExhausted)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, allowed)
+
+	// пачка переходов по обычной ссылке
+	plain := uuid.NewString()[:8]
+	require.NoError(t, db.Save(ShortURLRecord{ShortCode: plain, OriginalURL: "https://example.com/" + plain}))
+	require.NoError(t, db.AddClicks([]ClickCount{{ShortCode: plain, Variant: "default", Clicks: 4}}))
+	record, err := db.GetRecord(plain)
+	require.NoError(t, err)
+	assert.Equal(t, 4, record.Clicks)
+}
+
+func TestDBStorage_LastOwner(t *testing.T) {
+	db := openTestDB(t)
+	team := uuid.NewString()
+	require.NoError(t, db.SaveTeam(Team{ID: team, Name: "Team"}, "owner-1"))
+	require.NoError(t, db.SaveTeamMember(TeamMember{TeamID: team, UserID: "owner-2", Role: RoleOwner}))
+
+	// два владельца одновременно понижают друг друга: один из них остается
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, id := range []string{"owner-1", "owner-2"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			errs <- db.SaveTeamMember(TeamMember{TeamID: team, UserID: id, Role: RoleViewer})
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+	failed := 0
+	for err := range errs {
+		if err != nil {
+			assert.ErrorIs(t, err, ErrLastOwner)
+			failed++
+		}
+	}
+	assert.Equal(t, 1, failed)
+
+	members, err := db.GetTeamMembers(team)
+	require.NoError(t, err)
+	owners := 0
+	for _, m := range members {
+		if m.Role == RoleOwner {
+			owners++
+			assert.ErrorIs(t, db.DeleteTeamMember(team, m.UserID), ErrLastOwner)
+		}
+	}
+	assert.Equal(t, 1, owners)
+}
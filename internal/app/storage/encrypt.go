@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/buharamanya/shortener/internal/app/urlcrypt"
+)
+
+// сколько записей перешифровывать за одну транзакцию.
+const defaultReencryptBatch = 500
+
+// ErrNoURLKeys - перешифровать нечем.
+var ErrNoURLKeys = errors.New("url encryption keys are not configured")
+
+// хранилище, которое умеет перешифровать адреса активным ключом после ротации.
+type Reencrypter interface {
+	// возвращает число перешифрованных записей
+	ReencryptURLs(batchSize int) (int, error)
+}
+
+// применить f ко всем адресам записи: основному, запасному и адресам правил.
+// Правила копируются, чтобы не менять запись вызывающего.
+func mapRecordURLs(r ShortURLRecord, f func(string) (string, error)) (ShortURLRecord, error) {
+	var err error
+	if r.OriginalURL, err = f(r.OriginalURL); err != nil {
+		return r, err
+	}
+	if r.FallbackURL != "" {
+		if r.FallbackURL, err = f(r.FallbackURL); err != nil {
+			return r, err
+		}
+	}
+	if len(r.Rules) > 0 {
+		rules := make([]RedirectRule, len(r.Rules))
+		for i, rule := range r.Rules {
+			if rule.URL != "" {
+				if rule.URL, err = f(rule.URL); err != nil {
+					return r, err
+				}
+			}
+			rules[i] = rule
+		}
+		r.Rules = rules
+	}
+	return r, nil
+}
+
+// зашифровать адреса записи перед записью в хранилище.
+func sealRecord(keys *urlcrypt.Keyring, r ShortURLRecord) (ShortURLRecord, error) {
+	return mapRecordURLs(r, keys.Seal)
+}
+
+// расшифровать адреса прочитанной записи.
+func openRecord(keys *urlcrypt.Keyring, r ShortURLRecord) (ShortURLRecord, error) {
+	return mapRecordURLs(r, keys.Open)
+}
+
+// нужно ли перешифровать какой-нибудь адрес записи.
+func recordStale(keys *urlcrypt.Keyring, r ShortURLRecord) bool {
+	stale := false
+	mapRecordURLs(r, func(url string) (string, error) {
+		stale = stale || keys.Stale(url)
+		return url, nil
+	})
+	return stale
+}
+
+// зашифровать тело события, в нем адрес назначения. Зашифрованное тело хранится
+// JSON строкой, так колонка остается jsonb.
+func sealPayload(keys *urlcrypt.Keyring, payload json.RawMessage) (json.RawMessage, error) {
+	if keys == nil {
+		return payload, nil
+	}
+	sealed, err := keys.Seal(string(payload))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed)
+}
+
+// расшифровать тело события. Тело-объект записано до включения шифрования.
+func openPayload(keys *urlcrypt.Keyring, payload json.RawMessage) (json.RawMessage, error) {
+	var sealed string
+	if json.Unmarshal(payload, &sealed) != nil {
+		return payload, nil
+	}
+	plain, err := keys.Open(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(plain), nil
+}
+
+// нужно ли перешифровать тело события.
+func payloadStale(keys *urlcrypt.Keyring, payload json.RawMessage) bool {
+	var sealed string
+	if json.Unmarshal(payload, &sealed) != nil {
+		return keys != nil
+	}
+	return keys.Stale(sealed)
+}
+
+// шифровать адреса назначения ключами keys.
+func WithURLKeys(keys *urlcrypt.Keyring) DBOption {
+	return func(o *dbOptions) {
+		o.keys = keys
+	}
+}
+
+// перешифровать адреса активным ключом пачками по batchSize: основные, запасные,
+// адреса правил и тела событий в очереди вебхуков. Заодно шифруются адреса,
+// записанные до включения шифрования, и заполняется их слепой индекс.
+// Строка, изменившаяся во время прохода, пропускается.
+func (db *DBStorage) ReencryptURLs(batchSize int) (int, error) {
+	if db.keys == nil {
+		return 0, ErrNoURLKeys
+	}
+	if batchSize <= 0 {
+		batchSize = defaultReencryptBatch
+	}
+
+	total := 0
+	for _, reencrypt := range []func(after string, limit int) (int, string, error){db.reencryptBatch, db.reencryptDeliveries} {
+		after := ""
+		for {
+			n, last, err := reencrypt(after, batchSize)
+			total += n
+			if err != nil {
+				return total, err
+			}
+			if last == "" {
+				break
+			}
+			after = last
+		}
+	}
+	return total, nil
+}
+
+// перешифровать одну пачку ссылок после кода after. Возвращает последний просмотренный код,
+// пустой - если записей больше нет.
+func (db *DBStorage) reencryptBatch(after string, limit int) (int, string, error) {
+	type row struct {
+		record      ShortURLRecord
+		hash, rules string
+	}
+	query := `SELECT short_code, url, COALESCE(url_hash, ''), COALESCE(fallback_url, ''), COALESCE(rules::text, '')
+		FROM shorturl
+		WHERE short_code > $1
+		ORDER BY short_code
+		LIMIT $2`
+	rows, err := db.Query(query, after, limit)
+	if err != nil {
+		return 0, "", err
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.record.ShortCode, &r.record.OriginalURL, &r.hash, &r.record.FallbackURL, &r.rules); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		if r.rules != "" {
+			if err := json.Unmarshal([]byte(r.rules), &r.record.Rules); err != nil {
+				rows.Close()
+				return 0, "", err
+			}
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+	if len(batch) == 0 {
+		return 0, "", nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	n := 0
+	for _, r := range batch {
+		if !recordStale(db.keys, r.record) && r.hash != "" {
+			continue
+		}
+		plain, err := db.keys.Open(r.record.OriginalURL)
+		if err != nil {
+			return 0, "", err
+		}
+		rotated, err := mapRecordURLs(r.record, db.keys.Rotate)
+		if err != nil {
+			return 0, "", err
+		}
+		res, err := tx.Exec(`UPDATE shorturl SET url = $3, url_hash = $4, fallback_url = NULLIF($5, ''), rules = $6
+			WHERE short_code = $1 AND url = $2
+				AND COALESCE(fallback_url, '') = $7 AND COALESCE(rules::text, '') = $8`,
+			r.record.ShortCode, r.record.OriginalURL, rotated.OriginalURL, db.keys.BlindIndex(plain),
+			rotated.FallbackURL, toJSONB(rotated.Rules, len(rotated.Rules) > 0), r.record.FallbackURL, r.rules)
+		if err != nil {
+			return 0, "", err
+		}
+		// строку успели изменить после выборки - она не перешифрована
+		if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+			n++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return n, batch[len(batch)-1].record.ShortCode, nil
+}
+
+// перешифровать одну пачку тел событий после доставки after.
+func (db *DBStorage) reencryptDeliveries(after string, limit int) (int, string, error) {
+	type row struct {
+		id      string
+		payload []byte
+	}
+	rows, err := db.Query(`SELECT id, payload FROM webhook_deliveries WHERE id > $1 ORDER BY id LIMIT $2`, after, limit)
+	if err != nil {
+		return 0, "", err
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.payload); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+	if len(batch) == 0 {
+		return 0, "", nil
+	}
+
+	n := 0
+	for _, r := range batch {
+		if !payloadStale(db.keys, r.payload) {
+			continue
+		}
+		plain, err := openPayload(db.keys, r.payload)
+		if err != nil {
+			return 0, "", err
+		}
+		sealed, err := sealPayload(db.keys, plain)
+		if err != nil {
+			return 0, "", err
+		}
+		// тело доставки не меняется, достаточно одной строки на запрос
+		res, err := db.Exec(`UPDATE webhook_deliveries SET payload = $2 WHERE id = $1`, r.id, string(sealed))
+		if err != nil {
+			return 0, "", err
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+			n++
+		}
+	}
+	return n, batch[len(batch)-1].id, nil
+}
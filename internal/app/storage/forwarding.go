@@ -0,0 +1,18 @@
+package storage
+
+// что из входящего запроса переносится в адрес назначения.
+type Forwarding struct {
+	// добавлять параметры запроса к адресу назначения
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// входящие параметры заменяют одноименные параметры адреса, иначе адрес главнее
+	QueryOverride bool `json:"query_override,omitempty"`
+	// utm метки, которые добавляются, если в адресе их нет
+	UTM map[string]string `json:"utm,omitempty"`
+	// дописывать путь после кода к пути адреса: /{code}/extra -> /dest/extra
+	ForwardPath bool `json:"forward_path,omitempty"`
+}
+
+// задано ли что-то кроме поведения по умолчанию.
+func (f Forwarding) Enabled() bool {
+	return f.ForwardQuery || f.ForwardPath || len(f.UTM) > 0
+}
@@ -5,22 +5,89 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/urlcrypt"
 	"github.com/google/uuid"
 )
 
 // InMemoryStorage - реализация хранилища в памяти.
 type InMemoryStorage struct {
+	mu   sync.RWMutex
 	file os.File
 	urls map[string]ShortURLRecord
+	// адрес -> код обычной ссылки на него, как уникальный индекс в базе
+	plainURLs map[string]string
+	accounts  map[string]Account
+	teams     map[string]Team
+	members   map[string]map[string]Role // team_id -> user_id -> роль
+	variants  map[string]map[string]int  // short_code -> вариант -> переходы
+	webhooks  map[string]Webhook
+	// доставки хранятся в файле целиком при каждом изменении, последняя строка побеждает
+	deliveries map[string]WebhookDelivery
+	// шифрование адресов и тел событий в файле, в памяти они открыты
+	keys *urlcrypt.Keyring
+}
+
+// настройка файлового хранилища.
+type InMemoryOption func(*InMemoryStorage)
+
+// шифровать адреса назначения в файле ключами keys.
+func WithFileKeys(keys *urlcrypt.Keyring) InMemoryOption {
+	return func(s *InMemoryStorage) {
+		s.keys = keys
+	}
+}
+
+// строка файла хранилища: либо запись урла, либо служебная запись.
+type fileEntry struct {
+	ShortURLRecord
+	Account  *Account         `json:"account,omitempty"`
+	Team     *Team            `json:"team,omitempty"`
+	Member   *TeamMember      `json:"team_member,omitempty"`
+	Click    *click           `json:"click,omitempty"`
+	Webhook  *Webhook         `json:"webhook,omitempty"`
+	Delivery *WebhookDelivery `json:"webhook_delivery,omitempty"`
+	Variant  *variantClicks   `json:"variant_clicks,omitempty"`
+}
+
+// переход по ссылке, в файле хранится отдельной строкой. Пачка переходов
+// пишется одной строкой с Count, без него строка означает один переход.
+type click struct {
+	ShortCode string `json:"short_code"`
+	Variant   string `json:"variant"`
+	Count     int    `json:"count,omitempty"`
+}
+
+// итог переходов по варианту, пишется при переписывании файла вместо отдельных переходов.
+type variantClicks struct {
+	ShortCode string `json:"short_code"`
+	Variant   string `json:"variant"`
+	Clicks    int    `json:"clicks"`
 }
 
 // ну понятно же.
-func NewInMemoryStorage(file *os.File) *InMemoryStorage {
+func NewInMemoryStorage(file *os.File, opts ...InMemoryOption) *InMemoryStorage {
 
 	urls := make(map[string]ShortURLRecord)
+	s := &InMemoryStorage{
+		file:       *file,
+		urls:       urls,
+		accounts:   make(map[string]Account),
+		teams:      make(map[string]Team),
+		members:    make(map[string]map[string]Role),
+		variants:   make(map[string]map[string]int),
+		webhooks:   make(map[string]Webhook),
+		deliveries: make(map[string]WebhookDelivery),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
 
 	if _, err := file.Seek(0, 0); err != nil {
 		logger.Log.Fatal("не удалось перейти в начало файла")
@@ -33,42 +100,134 @@ func NewInMemoryStorage(file *os.File) *InMemoryStorage {
 			continue
 		}
 
-		var record ShortURLRecord
-		if err := json.Unmarshal([]byte(line), &record); err != nil {
+		var entry fileEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
 			logger.Log.Info(fmt.Sprintf("Ошибка декодирования строки '%s': %v", line, err))
 			continue
 		}
 
-		urls[record.ShortCode] = record
+		switch {
+		case entry.Account != nil:
+			s.accounts[entry.Account.Login] = *entry.Account
+		case entry.Team != nil:
+			s.teams[entry.Team.ID] = *entry.Team
+		case entry.Member != nil:
+			s.applyMember(*entry.Member)
+		case entry.Click != nil:
+			s.applyClick(*entry.Click)
+		case entry.Webhook != nil:
+			s.applyWebhook(*entry.Webhook)
+		case entry.Delivery != nil:
+			payload, err := openPayload(s.keys, entry.Delivery.Payload)
+			if err != nil {
+				logger.Log.Fatal(fmt.Sprintf("не удалось расшифровать доставку %s: %v", entry.Delivery.ID, err))
+			}
+			entry.Delivery.Payload = payload
+			s.deliveries[entry.Delivery.ID] = *entry.Delivery
+		case entry.Variant != nil:
+			if s.variants[entry.Variant.ShortCode] == nil {
+				s.variants[entry.Variant.ShortCode] = make(map[string]int)
+			}
+			s.variants[entry.Variant.ShortCode][entry.Variant.Variant] = entry.Variant.Clicks
+		default:
+			record, err := openRecord(s.keys, entry.ShortURLRecord)
+			if err != nil {
+				// пропустить запись нельзя: при переписывании файла она потеряется
+				logger.Log.Fatal(fmt.Sprintf("не удалось расшифровать адрес %s: %v", entry.ShortCode, err))
+			}
+			urls[entry.ShortCode] = record
+		}
 	}
+	s.reindexLocked()
+
+	return s
+}
 
-	return &InMemoryStorage{
-		*file,
-		urls,
+// пересобрать индекс обычных ссылок по s.urls.
+func (s *InMemoryStorage) reindexLocked() {
+	s.plainURLs = make(map[string]string)
+	for code, record := range s.urls {
+		if record.Plain() {
+			s.plainURLs[record.OriginalURL] = code
+		}
+	}
+}
+
+// можно ли сохранить новую запись: обычная ссылка на адрес не дублируется,
+// код не занят. pending - уже проверенные записи той же пачки.
+func (s *InMemoryStorage) checkNewLocked(record ShortURLRecord, pending map[string]ShortURLRecord) error {
+	if record.Plain() {
+		if code, ok := s.plainURLs[record.OriginalURL]; ok {
+			return &ConflictError{ShortCode: code, Err: ErrURLExists}
+		}
+		for code, p := range pending {
+			if p.Plain() && p.OriginalURL == record.OriginalURL {
+				return &ConflictError{ShortCode: code, Err: ErrURLExists}
+			}
+		}
+	}
+	if _, taken := s.urls[record.ShortCode]; taken {
+		return &CodeTakenError{ShortCode: record.ShortCode}
+	}
+	if _, taken := pending[record.ShortCode]; taken {
+		return &CodeTakenError{ShortCode: record.ShortCode}
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) putLocked(record ShortURLRecord) {
+	s.urls[record.ShortCode] = record
+	if record.Plain() {
+		s.plainURLs[record.OriginalURL] = record.ShortCode
 	}
 }
 
 // прихранить.
 func (s *InMemoryStorage) Save(record ShortURLRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkNewLocked(record, nil); err != nil {
+		return err
+	}
 	record.CorrelationID = uuid.New().String()
-	s.urls[record.ShortCode] = record
-	encoder := json.NewEncoder(&s.file)
-	encoder.Encode(record)
-	return nil
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	s.putLocked(record)
+	return s.encodeRecord(json.NewEncoder(&s.file), record)
 }
 
-// прихранить много.
+// прихранить много. Пачка сохраняется целиком или не сохраняется вовсе.
 func (s *InMemoryStorage) SaveBatch(records []ShortURLRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := make(map[string]ShortURLRecord, len(records))
 	for _, v := range records {
-		s.urls[v.ShortCode] = v
-		encoder := json.NewEncoder(&s.file)
-		encoder.Encode(v)
+		if err := s.checkNewLocked(v, pending); err != nil {
+			return err
+		}
+		pending[v.ShortCode] = v
+	}
+
+	for _, v := range records {
+		if v.CreatedAt.IsZero() {
+			v.CreatedAt = time.Now()
+		}
+		s.putLocked(v)
+		if err := s.encodeRecord(json.NewEncoder(&s.file), v); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 // получить.
 func (s *InMemoryStorage) Get(shortCode string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	url, exists := s.urls[shortCode]
 	if !exists {
 		return "", ErrNotFound
@@ -79,8 +238,26 @@ func (s *InMemoryStorage) Get(shortCode string) (string, error) {
 	return url.OriginalURL, nil
 }
 
+// получить запись целиком. Для удаленной записи возвращается и она, и ErrDeleted.
+func (s *InMemoryStorage) GetRecord(shortCode string) (ShortURLRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, exists := s.urls[shortCode]
+	if !exists {
+		return ShortURLRecord{}, ErrNotFound
+	}
+	if record.DeletedFlag {
+		return record, ErrDeleted
+	}
+	return record, nil
+}
+
 // получить по пользаку.
 func (s *InMemoryStorage) GetURLsByUserID(userID string) ([]ShortURLRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	var userURLs []ShortURLRecord
 	for _, v := range s.urls {
 		if v.UserID == userID {
@@ -90,22 +267,745 @@ func (s *InMemoryStorage) GetURLsByUserID(userID string) ([]ShortURLRecord, erro
 	return userURLs, nil
 }
 
-// удалить.
-func (s *InMemoryStorage) DeleteURLs(shortCodes []string, userID string) error {
+// страница всех записей, включая удаленные, с кодами после afterShortCode по возрастанию.
+func (s *InMemoryStorage) ListRecords(afterShortCode string, limit int) ([]ShortURLRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	codes := make([]string, 0, len(s.urls))
+	for code := range s.urls {
+		if code > afterShortCode {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	if len(codes) > limit {
+		codes = codes[:limit]
+	}
+
+	records := make([]ShortURLRecord, 0, len(codes))
+	for _, code := range codes {
+		records = append(records, s.urls[code])
+	}
+	return records, nil
+}
+
+// найти урлы пользака по тегу и тексту.
+func (s *InMemoryStorage) SearchURLsByUserID(userID string, filter URLFilter) ([]ShortURLRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var userURLs []ShortURLRecord
+	for _, v := range s.urls {
+		if v.UserID == userID && filter.Match(v) {
+			userURLs = append(userURLs, v)
+		}
+	}
+	return userURLs, nil
+}
+
+// удалить. Возвращает записи, которые действительно удалены этим вызовом.
+func (s *InMemoryStorage) DeleteURLs(shortCodes []string, userID string) ([]ShortURLRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	var deleted []ShortURLRecord
 	for _, v := range shortCodes {
 		record, ok := s.urls[v]
-		if ok && record.UserID == userID {
+		if ok && !record.DeletedFlag && s.canManage(record, userID) {
 			record.DeletedFlag = true
 			s.urls[v] = record
-			encoder := json.NewEncoder(&s.file)
-			encoder.Encode(record)
+			s.encodeRecord(json.NewEncoder(&s.file), record)
+			deleted = append(deleted, record)
 		}
 	}
 
+	return deleted, nil
+}
+
+// сохранить аккаунт.
+func (s *InMemoryStorage) SaveAccount(account Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.accounts[account.Login]; exists {
+		return ErrLoginTaken
+	}
+	s.accounts[account.Login] = account
+	encoder := json.NewEncoder(&s.file)
+	return encoder.Encode(struct {
+		Account Account `json:"account"`
+	}{account})
+}
+
+// получить аккаунт по логину.
+func (s *InMemoryStorage) GetAccountByLogin(login string) (Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, exists := s.accounts[login]
+	if !exists {
+		return Account{}, ErrNotFound
+	}
+	return account, nil
+}
+
+// передать урлы другому пользаку.
+func (s *InMemoryStorage) ReassignURLs(fromUserID, toUserID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoder := json.NewEncoder(&s.file)
+	for k, v := range s.urls {
+		if v.UserID == fromUserID {
+			v.UserID = toUserID
+			s.urls[k] = v
+			if err := s.encodeRecord(encoder, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// может ли пользак менять запись: свою личную или командную с ролью не ниже редактора.
+func (s *InMemoryStorage) canManage(record ShortURLRecord, userID string) bool {
+	if record.TeamID == "" {
+		return record.UserID == userID
+	}
+	return s.members[record.TeamID][userID].Allows(RoleEditor)
+}
+
+// создать команду.
+func (s *InMemoryStorage) SaveTeam(team Team, ownerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.teams[team.ID] = team
+	encoder := json.NewEncoder(&s.file)
+	if err := encoder.Encode(struct {
+		Team Team `json:"team"`
+	}{team}); err != nil {
+		return err
+	}
+	return s.saveTeamMember(TeamMember{TeamID: team.ID, UserID: ownerID, Role: RoleOwner})
+}
+
+// добавить участника или сменить ему роль.
+func (s *InMemoryStorage) SaveTeamMember(member TeamMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.saveTeamMember(member)
+}
+
+// добавить участника, вызывается под блокировкой. Пустая роль исключает участника.
+func (s *InMemoryStorage) saveTeamMember(member TeamMember) error {
+	if _, ok := s.teams[member.TeamID]; !ok {
+		return ErrNotFound
+	}
+	if member.Role != RoleOwner && s.lastOwnerLocked(member.TeamID, member.UserID) {
+		return ErrLastOwner
+	}
+	s.applyMember(member)
+	encoder := json.NewEncoder(&s.file)
+	return encoder.Encode(struct {
+		Member TeamMember `json:"team_member"`
+	}{member})
+}
+
+// исключить участника.
+func (s *InMemoryStorage) DeleteTeamMember(teamID, userID string) error {
+	return s.SaveTeamMember(TeamMember{TeamID: teamID, UserID: userID})
+}
+
+// единственный ли владелец команды userID.
+func (s *InMemoryStorage) lastOwnerLocked(teamID, userID string) bool {
+	if s.members[teamID][userID] != RoleOwner {
+		return false
+	}
+	for id, role := range s.members[teamID] {
+		if id != userID && role == RoleOwner {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *InMemoryStorage) applyMember(member TeamMember) {
+	if member.Role == "" {
+		delete(s.members[member.TeamID], member.UserID)
+		return
+	}
+	if s.members[member.TeamID] == nil {
+		s.members[member.TeamID] = make(map[string]Role)
+	}
+	s.members[member.TeamID][member.UserID] = member.Role
+}
+
+// роль пользака в команде.
+func (s *InMemoryStorage) GetTeamRole(teamID, userID string) (Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	role, ok := s.members[teamID][userID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return role, nil
+}
+
+// участники команды.
+func (s *InMemoryStorage) GetTeamMembers(teamID string) ([]TeamMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	team, ok := s.teams[teamID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var members []TeamMember
+	for userID, role := range s.members[teamID] {
+		members = append(members, TeamMember{TeamID: teamID, TeamName: team.Name, UserID: userID, Role: role})
+	}
+	return members, nil
+}
+
+// команды пользака.
+func (s *InMemoryStorage) GetTeamsByUserID(userID string) ([]TeamMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var teams []TeamMember
+	for teamID, members := range s.members {
+		if role, ok := members[userID]; ok {
+			teams = append(teams, TeamMember{TeamID: teamID, TeamName: s.teams[teamID].Name, UserID: userID, Role: role})
+		}
+	}
+	return teams, nil
+}
+
+// получить урлы команды.
+func (s *InMemoryStorage) GetURLsByTeamID(teamID string) ([]ShortURLRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var teamURLs []ShortURLRecord
+	for _, v := range s.urls {
+		if v.TeamID == teamID {
+			teamURLs = append(teamURLs, v)
+		}
+	}
+	return teamURLs, nil
+}
+
+// засчитать переход на вариант. Ссылка с исчерпанным лимитом переходов
+// возвращает ErrExhausted, проверка и увеличение счетчика выполняются под одной блокировкой.
+func (s *InMemoryStorage) RegisterClick(shortCode, variant string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, exists := s.urls[shortCode]
+	if !exists {
+		return ErrNotFound
+	}
+	if record.DeletedFlag {
+		return ErrDeleted
+	}
+	if record.MaxClicks > 0 && record.Clicks >= record.MaxClicks {
+		return ErrExhausted
+	}
+
+	c := click{ShortCode: shortCode, Variant: variant}
+	s.applyClick(c)
+	encoder := json.NewEncoder(&s.file)
+	return encoder.Encode(struct {
+		Click click `json:"click"`
+	}{c})
+}
+
+// записать пачку переходов по ссылкам без лимита. Удаленные и неизвестные ссылки пропускаются.
+func (s *InMemoryStorage) AddClicks(counts []ClickCount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoder := json.NewEncoder(&s.file)
+	for _, count := range counts {
+		record, exists := s.urls[count.ShortCode]
+		if !exists || record.DeletedFlag || count.Clicks <= 0 {
+			continue
+		}
+		c := click{ShortCode: count.ShortCode, Variant: count.Variant, Count: count.Clicks}
+		s.applyClick(c)
+		if err := encoder.Encode(struct {
+			Click click `json:"click"`
+		}{c}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (s *InMemoryStorage) applyClick(c click) {
+	record, exists := s.urls[c.ShortCode]
+	if !exists {
+		return
+	}
+	n := max(c.Count, 1)
+	record.Clicks += n
+	s.urls[c.ShortCode] = record
+
+	if s.variants[c.ShortCode] == nil {
+		s.variants[c.ShortCode] = make(map[string]int)
+	}
+	s.variants[c.ShortCode][c.Variant] += n
+}
+
+// переходы по вариантам ссылки.
+func (s *InMemoryStorage) GetVariantClicks(shortCode string) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clicks := make(map[string]int, len(s.variants[shortCode]))
+	for k, v := range s.variants[shortCode] {
+		clicks[k] = v
+	}
+	return clicks, nil
+}
+
+// обновить настройки записи. Адрес, владелец и счетчик переходов не меняются.
+func (s *InMemoryStorage) UpdateRecord(record ShortURLRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists := s.urls[record.ShortCode]
+	if !exists {
+		return ErrNotFound
+	}
+	wasPlain := current.Plain()
+
+	current.PasswordHash = record.PasswordHash
+	current.MaxClicks = record.MaxClicks
+	current.Schedule = record.Schedule
+	current.Rules = record.Rules
+	current.Forwarding = record.Forwarding
+	current.RedirectStatus = record.RedirectStatus
+	current.Title = record.Title
+	current.Tags = record.Tags
+	current.Notes = record.Notes
+
+	if !wasPlain && current.Plain() {
+		if code, ok := s.plainURLs[current.OriginalURL]; ok {
+			return &ConflictError{ShortCode: code, Err: ErrURLExists}
+		}
+	}
+	if wasPlain && !current.Plain() && s.plainURLs[current.OriginalURL] == current.ShortCode {
+		delete(s.plainURLs, current.OriginalURL)
+	}
+
+	s.putLocked(current)
+	return s.encodeRecord(json.NewEncoder(&s.file), current)
+}
+
+// сохранить подписку.
+func (s *InMemoryStorage) SaveWebhook(webhook Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.applyWebhook(webhook)
+	encoder := json.NewEncoder(&s.file)
+	return encoder.Encode(struct {
+		Webhook Webhook `json:"webhook"`
+	}{webhook})
+}
+
+// подписка по идентификатору.
+func (s *InMemoryStorage) GetWebhook(id string) (Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return Webhook{}, ErrNotFound
+	}
+	return webhook, nil
+}
+
+// подписки пользака.
+func (s *InMemoryStorage) GetWebhooksByUserID(userID string) ([]Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var webhooks []Webhook
+	for _, v := range s.webhooks {
+		if v.UserID == userID {
+			webhooks = append(webhooks, v)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+	return webhooks, nil
+}
+
+// удалить подписку вместе с ее доставками.
+func (s *InMemoryStorage) DeleteWebhook(id, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok || webhook.UserID != userID {
+		return ErrNotFound
+	}
+	removed := Webhook{ID: id, UserID: userID}
+	s.applyWebhook(removed)
+	encoder := json.NewEncoder(&s.file)
+	return encoder.Encode(struct {
+		Webhook Webhook `json:"webhook"`
+	}{removed})
+}
+
+func (s *InMemoryStorage) applyWebhook(webhook Webhook) {
+	if webhook.URL != "" {
+		s.webhooks[webhook.ID] = webhook
+		return
+	}
+	delete(s.webhooks, webhook.ID)
+	for id, d := range s.deliveries {
+		if d.WebhookID == webhook.ID {
+			delete(s.deliveries, id)
+		}
+	}
+}
+
+// поставить событие в очередь всем подпискам пользака на этот тип.
+func (s *InMemoryStorage) EnqueueWebhookEvent(event WebhookEvent, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoder := json.NewEncoder(&s.file)
+	for _, webhook := range s.webhooks {
+		if webhook.UserID != event.UserID || !webhook.Subscribed(event.Type) {
+			continue
+		}
+		d := WebhookDelivery{
+			ID:            deliveryID(event.ID, webhook.ID),
+			WebhookID:     webhook.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       event.Payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if _, exists := s.deliveries[d.ID]; exists {
+			continue
+		}
+		if err := s.saveDelivery(encoder, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// забрать доставки, срок которых подошел.
+func (s *InMemoryStorage) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []WebhookDelivery
+	for _, d := range s.deliveries {
+		if d.Status == DeliveryPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	// аренда в файл не пишется: после перезапуска доставки просто уйдут сразу
+	for _, d := range due {
+		d.NextAttemptAt = now.Add(lease)
+		s.deliveries[d.ID] = d
+	}
+	return due, nil
+}
+
+// сохранить результат попытки доставки.
+func (s *InMemoryStorage) UpdateWebhookDelivery(delivery WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deliveries[delivery.ID]; !ok {
+		return ErrNotFound
+	}
+	return s.saveDelivery(json.NewEncoder(&s.file), delivery)
+}
+
+func (s *InMemoryStorage) saveDelivery(encoder *json.Encoder, delivery WebhookDelivery) error {
+	s.deliveries[delivery.ID] = delivery
+	return s.encodeDelivery(encoder, delivery)
+}
+
+// записать строку доставки, тело шифруется, если заданы ключи.
+func (s *InMemoryStorage) encodeDelivery(encoder *json.Encoder, delivery WebhookDelivery) error {
+	payload, err := sealPayload(s.keys, delivery.Payload)
+	if err != nil {
+		return err
+	}
+	delivery.Payload = payload
+	return encoder.Encode(struct {
+		Delivery WebhookDelivery `json:"webhook_delivery"`
+	}{delivery})
+}
+
+// последние доставки подписки.
+func (s *InMemoryStorage) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var deliveries []WebhookDelivery
+	for _, d := range s.deliveries {
+		if d.WebhookID == webhookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// удалить старые завершенные доставки. Удаленные строки остаются в файле,
+// поэтому после удаления файл переписывается.
+func (s *InMemoryStorage) PruneWebhookDeliveries(before time.Time, keep int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	done := make(map[string][]WebhookDelivery)
+	for _, d := range s.deliveries {
+		if d.Status != DeliveryPending {
+			done[d.WebhookID] = append(done[d.WebhookID], d)
+		}
+	}
+
+	pruned := 0
+	for _, deliveries := range done {
+		sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+		for i, d := range deliveries {
+			if d.UpdatedAt.Before(before) || (keep > 0 && i >= keep) {
+				delete(s.deliveries, d.ID)
+				pruned++
+			}
+		}
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+	return pruned, s.rewrite(s.urls)
+}
+
+// согласованный снимок всех записей, включая удаленные, по возрастанию кода.
+// Записи копируются под блокировкой, fn вызывается уже без нее.
+func (s *InMemoryStorage) ExportRecords(fn func(ShortURLRecord) error) error {
+	s.mu.RLock()
+	records := make([]ShortURLRecord, 0, len(s.urls))
+	for _, record := range s.urls {
+		records = append(records, record)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].ShortCode < records[j].ShortCode })
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// заменить все записи ссылок. Файл переписывается целиком через временный файл:
+// аккаунты, команды и вебхуки остаются, ссылки берутся из records.
+func (s *InMemoryStorage) RestoreRecords(records []ShortURLRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	urls := make(map[string]ShortURLRecord, len(records))
+	for _, record := range records {
+		urls[record.ShortCode] = record
+	}
+	if err := s.rewrite(urls); err != nil {
+		return err
+	}
+	s.urls = urls
+	s.reindexLocked()
+	for code := range s.variants {
+		if _, ok := urls[code]; !ok {
+			delete(s.variants, code)
+		}
+	}
+	return nil
+}
+
+// переписать файл по состоянию в памяти с записями urls, вызывается под блокировкой.
+func (s *InMemoryStorage) rewrite(urls map[string]ShortURLRecord) error {
+	path := s.file.Name()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := s.encodeState(json.NewEncoder(w), urls); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	old := s.file
+	s.file = *file
+	old.Close()
+	return nil
+}
+
+func (s *InMemoryStorage) encodeState(encoder *json.Encoder, urls map[string]ShortURLRecord) error {
+	for _, account := range s.accounts {
+		if err := encoder.Encode(struct {
+			Account Account `json:"account"`
+		}{account}); err != nil {
+			return err
+		}
+	}
+	for _, team := range s.teams {
+		if err := encoder.Encode(struct {
+			Team Team `json:"team"`
+		}{team}); err != nil {
+			return err
+		}
+	}
+	for teamID, members := range s.members {
+		for userID, role := range members {
+			if err := encoder.Encode(struct {
+				Member TeamMember `json:"team_member"`
+			}{TeamMember{TeamID: teamID, UserID: userID, Role: role}}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, record := range urls {
+		if err := s.encodeRecord(encoder, record); err != nil {
+			return err
+		}
+		for variant, clicks := range s.variants[record.ShortCode] {
+			if err := encoder.Encode(struct {
+				Variant variantClicks `json:"variant_clicks"`
+			}{variantClicks{ShortCode: record.ShortCode, Variant: variant, Clicks: clicks}}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, webhook := range s.webhooks {
+		if err := encoder.Encode(struct {
+			Webhook Webhook `json:"webhook"`
+		}{webhook}); err != nil {
+			return err
+		}
+	}
+	for _, delivery := range s.deliveries {
+		if err := s.encodeDelivery(encoder, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// записать строку записи, адреса шифруются, если заданы ключи.
+func (s *InMemoryStorage) encodeRecord(encoder *json.Encoder, record ShortURLRecord) error {
+	record, err := sealRecord(s.keys, record)
+	if err != nil {
+		return err
+	}
+	return encoder.Encode(record)
+}
+
+// перешифровать адреса активным ключом. В файле могут остаться старые версии записей,
+// поэтому он переписывается целиком, размер пачки не нужен. Возвращает число записей
+// и доставок, у которых в файле был не активный ключ или открытый адрес.
+func (s *InMemoryStorage) ReencryptURLs(_ int) (int, error) {
+	if s.keys == nil {
+		return 0, ErrNoURLKeys
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stale, err := s.staleEntries()
+	if err != nil {
+		return 0, err
+	}
+	if err := s.rewrite(s.urls); err != nil {
+		return 0, err
+	}
+	return stale, nil
+}
+
+// число записей и доставок, последняя версия которых в файле требует перешифровки.
+func (s *InMemoryStorage) staleEntries() (int, error) {
+	if _, err := s.file.Seek(0, 0); err != nil {
+		return 0, err
+	}
+	records := make(map[string]bool)
+	deliveries := make(map[string]bool)
+	scanner := bufio.NewScanner(&s.file)
+	for scanner.Scan() {
+		var entry fileEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		switch {
+		case entry.Delivery != nil:
+			deliveries[entry.Delivery.ID] = payloadStale(s.keys, entry.Delivery.Payload)
+		case entry.Account == nil && entry.Team == nil && entry.Member == nil && entry.Click == nil &&
+			entry.Webhook == nil && entry.Variant == nil:
+			records[entry.ShortCode] = recordStale(s.keys, entry.ShortURLRecord)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for code, stale := range records {
+		if _, ok := s.urls[code]; ok && stale {
+			n++
+		}
+	}
+	for id, stale := range deliveries {
+		if _, ok := s.deliveries[id]; ok && stale {
+			n++
+		}
+	}
+	return n, nil
+}
+
 // Close - закрывает файловый дескриптор.
 func (s *InMemoryStorage) Close() error {
 	return s.file.Close()
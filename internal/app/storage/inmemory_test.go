@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/urlcrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// открыть файловое хранилище, как это делает сервер.
+func openFileStorage(t *testing.T, path string, opts ...InMemoryOption) *InMemoryStorage {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	s := NewInMemoryStorage(file, opts...)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestInMemoryStorage_AddClicksReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.json")
+	s := openFileStorage(t, path)
+
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-1"}))
+	require.NoError(t, s.RegisterClick("abc123", "default"))
+	require.NoError(t, s.AddClicks([]ClickCount{
+		{ShortCode: "abc123", Variant: "default", Clicks: 2},
+		{ShortCode: "abc123", Variant: "mobile", Clicks: 3},
+		{ShortCode: "nope", Variant: "default", Clicks: 1},
+	}))
+	require.NoError(t, s.Close())
+
+	// после перезапуска счетчики восстанавливаются из файла
+	s = openFileStorage(t, path)
+	record, err := s.GetRecord("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, 6, record.Clicks)
+
+	clicks, err := s.GetVariantClicks("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"default": 3, "mobile": 3}, clicks)
+}
+
+func TestInMemoryStorage_RegisterClickLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.json")
+	s := openFileStorage(t, path)
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "once05", OriginalURL: "https://example.com", MaxClicks: 5}))
+
+	// параллельные переходы не превышают лимит
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.RegisterClick("once05", "default")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	allowed := 0
+	for err := range errs {
+		if err == nil {
+			allowed++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrExhausted)
+	}
+	assert.Equal(t, 5, allowed)
+	assert.ErrorIs(t, s.RegisterClick("nope", "default"), ErrNotFound)
+
+	require.NoError(t, s.Close())
+	record, err := openFileStorage(t, path).GetRecord("once05")
+	require.NoError(t, err)
+	assert.Equal(t, 5, record.Clicks)
+}
+
+func TestInMemoryStorage_RewriteReplay(t *testing.T) {
+	keys, err := urlcrypt.New("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, bytes.Repeat([]byte{9}, 32))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "urls.json")
+	s := openFileStorage(t, path, WithFileKeys(keys))
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveAccount(Account{UserID: "user-1", Login: "alice", PasswordHash: "hash"}))
+	require.NoError(t, s.SaveTeam(Team{ID: "team-1", Name: "Team"}, "user-1"))
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-1", TeamID: "team-1"}))
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "gone01", OriginalURL: "https://example.com/gone", UserID: "user-1"}))
+	_, err = s.DeleteURLs([]string{"gone01"}, "user-1")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.RegisterClick("abc123", "mobile"))
+	}
+	require.NoError(t, s.SaveWebhook(Webhook{ID: "wh-1", UserID: "user-1", URL: "https://hooks.example.com", Secret: "s", Events: []string{"link.created"}}))
+	require.NoError(t, s.SaveWebhook(Webhook{ID: "wh-2", UserID: "user-1", URL: "https://hooks.example.com/2", Secret: "s", Events: []string{"link.created"}}))
+	require.NoError(t, s.DeleteWebhook("wh-2", "user-1"))
+	require.NoError(t, s.EnqueueWebhookEvent(WebhookEvent{ID: "ev-1", Type: "link.created", UserID: "user-1", Payload: json.RawMessage(`{}`)}, now))
+
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// переписывание файла сохраняет состояние и схлопывает историю
+	_, err = s.ReencryptURLs(0)
+	require.NoError(t, err)
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Less(t, bytes.Count(after, []byte("\n")), bytes.Count(before, []byte("\n")))
+
+	// запись после переписывания попадает уже в новый файл
+	require.NoError(t, s.RegisterClick("abc123", "default"))
+	require.NoError(t, s.Close())
+
+	s = openFileStorage(t, path, WithFileKeys(keys))
+	account, err := s.GetAccountByLogin("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", account.UserID)
+
+	role, err := s.GetTeamRole("team-1", "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, RoleOwner, role)
+
+	record, err := s.GetRecord("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, 4, record.Clicks)
+	assert.Equal(t, "team-1", record.TeamID)
+
+	clicks, err := s.GetVariantClicks("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"mobile": 3, "default": 1}, clicks)
+
+	_, err = s.GetRecord("gone01")
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	_, err = s.GetWebhook("wh-2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	deliveries, err := s.GetWebhookDeliveries("wh-1", 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, DeliveryPending, deliveries[0].Status)
+}
+
+func TestInMemoryStorage_ReencryptURLs(t *testing.T) {
+	key := func(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+	keyring := func(active string, keys map[string][]byte) *urlcrypt.Keyring {
+		k, err := urlcrypt.New(active, keys, key(9))
+		require.NoError(t, err)
+		return k
+	}
+	both := map[string][]byte{"old": key(1), "new": key(2)}
+
+	path := filepath.Join(t.TempDir(), "urls.json")
+	s := openFileStorage(t, path, WithFileKeys(keyring("old", both)))
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com/secret"}))
+	require.NoError(t, s.Close())
+
+	// после ротации старым ключом зашифрованы и старые строки файла,
+	// считаются только записи, которые действительно перешифрованы
+	s = openFileStorage(t, path, WithFileKeys(keyring("new", both)))
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "fresh1", OriginalURL: "https://example.com/fresh"}))
+	n, err := s.ReencryptURLs(0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = s.ReencryptURLs(0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	require.NoError(t, s.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "https://example.com/secret")
+	assert.NotContains(t, string(data), "enc:v1:old:")
+	assert.Contains(t, string(data), "enc:v1:new:")
+
+	// старый ключ больше не нужен
+	s = openFileStorage(t, path, WithFileKeys(keyring("new", map[string][]byte{"new": key(2)})))
+	record, err := s.GetRecord("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/secret", record.OriginalURL)
+
+	// без ключей перешифровывать нечем
+	_, err = openFileStorage(t, filepath.Join(t.TempDir(), "plain.json")).ReencryptURLs(0)
+	assert.ErrorIs(t, err, ErrNoURLKeys)
+}
+
+func TestInMemoryStorage_PlainLinkConflict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.json")
+	s := openFileStorage(t, path)
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-1", Title: "Home"}))
+	require.NoError(t, s.RegisterClick("abc123", "default"))
+
+	// второй пользователь получает уже сохраненный код, первая ссылка не меняется
+	var conflict *ConflictError
+	err := s.Save(ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-2"})
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "abc123", conflict.ShortCode)
+
+	record, err := s.GetRecord("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", record.UserID)
+	assert.Equal(t, "Home", record.Title)
+	assert.Equal(t, 1, record.Clicks)
+
+	// пачка с уже сокращенным адресом не сохраняется целиком
+	err = s.SaveBatch([]ShortURLRecord{
+		{ShortCode: "new001", OriginalURL: "https://example.com/new", UserID: "user-2"},
+		{ShortCode: "abc124", OriginalURL: "https://example.com", UserID: "user-2"},
+	})
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "abc123", conflict.ShortCode)
+	_, err = s.GetRecord("new001")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// код ссылки, которой добавили настройки, не отдается новой ссылке
+	record.MaxClicks = 10
+	require.NoError(t, s.UpdateRecord(record))
+	var taken *CodeTakenError
+	err = s.Save(ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-2"})
+	require.ErrorAs(t, err, &taken)
+	assert.Equal(t, "abc123", taken.ShortCode)
+
+	// адрес снова свободен для обычной ссылки под другим кодом
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "rnd001", OriginalURL: "https://example.com", UserID: "user-2"}))
+	record.MaxClicks = 0
+	err = s.UpdateRecord(record)
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "rnd001", conflict.ShortCode)
+
+	// после перезапуска индекс восстанавливается из файла
+	require.NoError(t, s.Close())
+	s = openFileStorage(t, path)
+	err = s.Save(ShortURLRecord{ShortCode: "rnd002", OriginalURL: "https://example.com", UserID: "user-3"})
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "rnd001", conflict.ShortCode)
+}
+
+func TestInMemoryStorage_SettingsLinksNotDeduped(t *testing.T) {
+	s := openFileStorage(t, filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "abc123", OriginalURL: "https://example.com", UserID: "user-1"}))
+
+	// ссылки с правилами, своим кодом ответа или командой не совпадают с обычной
+	for _, record := range []ShortURLRecord{
+		{ShortCode: "rules1", OriginalURL: "https://example.com", Rules: []RedirectRule{{Variant: "ios", Platforms: []string{"ios"}, URL: "https://example.com/ios"}}},
+		{ShortCode: "status", OriginalURL: "https://example.com", RedirectStatus: 301},
+		{ShortCode: "team01", OriginalURL: "https://example.com", TeamID: "team-1"},
+	} {
+		assert.False(t, record.Plain(), record.ShortCode)
+		require.NoError(t, s.Save(record), record.ShortCode)
+	}
+
+	// обычная ссылка, получившая правила, освобождает адрес
+	record, err := s.GetRecord("abc123")
+	require.NoError(t, err)
+	record.RedirectStatus = 308
+	require.NoError(t, s.UpdateRecord(record))
+	require.NoError(t, s.Save(ShortURLRecord{ShortCode: "abc124", OriginalURL: "https://example.com", UserID: "user-2"}))
+}
+
+func TestInMemoryStorage_EncryptsAllURLs(t *testing.T) {
+	keys, err := urlcrypt.New("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, bytes.Repeat([]byte{9}, 32))
+	require.NoError(t, err)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	path := filepath.Join(t.TempDir(), "urls.json")
+	s := openFileStorage(t, path, WithFileKeys(keys))
+	record := ShortURLRecord{
+		ShortCode:   "abc123",
+		OriginalURL: "https://example.com/secret",
+		UserID:      "user-1",
+		Schedule:    Schedule{FallbackURL: "https://example.com/fallback"},
+		Rules:       []RedirectRule{{Variant: "ios", Platforms: []string{"ios"}, URL: "https://example.com/ios"}},
+	}
+	require.NoError(t, s.Save(record))
+	require.NoError(t, s.SaveWebhook(Webhook{ID: "wh-1", UserID: "user-1", URL: "https://hooks.example.com", Secret: "s", Events: []string{"link.created"}}))
+	payload := json.RawMessage(`{"original_url":"https://example.com/secret"}`)
+	require.NoError(t, s.EnqueueWebhookEvent(WebhookEvent{ID: "ev-1", Type: "link.created", UserID: "user-1", Payload: payload}, now))
+	require.NoError(t, s.Close())
+
+	// ни один адрес назначения не лежит в файле открытым
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "example.com/")
+
+	s = openFileStorage(t, path, WithFileKeys(keys))
+	got, err := s.GetRecord("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, record.FallbackURL, got.FallbackURL)
+	assert.Equal(t, record.Rules, got.Rules)
+	deliveries, err := s.GetWebhookDeliveries("wh-1", 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.JSONEq(t, string(payload), string(deliveries[0].Payload))
+}
+
+func TestInMemoryStorage_LastOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.json")
+	s := openFileStorage(t, path)
+	require.NoError(t, s.SaveTeam(Team{ID: "team-1", Name: "Team"}, "user-1"))
+	require.NoError(t, s.SaveTeamMember(TeamMember{TeamID: "team-1", UserID: "user-2", Role: RoleEditor}))
+
+	// единственного владельца нельзя понизить или исключить
+	assert.ErrorIs(t, s.SaveTeamMember(TeamMember{TeamID: "team-1", UserID: "user-1", Role: RoleEditor}), ErrLastOwner)
+	assert.ErrorIs(t, s.DeleteTeamMember("team-1", "user-1"), ErrLastOwner)
+	role, err := s.GetTeamRole("team-1", "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, RoleOwner, role)
+
+	// с вторым владельцем первый может уйти
+	require.NoError(t, s.SaveTeamMember(TeamMember{TeamID: "team-1", UserID: "user-2", Role: RoleOwner}))
+	require.NoError(t, s.DeleteTeamMember("team-1", "user-1"))
+	assert.ErrorIs(t, s.DeleteTeamMember("team-1", "user-2"), ErrLastOwner)
+	require.NoError(t, s.DeleteTeamMember("team-1", "user-3"))
+}
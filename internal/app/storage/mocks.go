@@ -15,6 +15,37 @@ func (m *MockURLStorage) Get(shortCode string) (string, error) {
 	return args.String(0), args.Error(1)
 }
 
+// получить запись.
+func (m *MockURLStorage) GetRecord(shortCode string) (ShortURLRecord, error) {
+	args := m.Called(shortCode)
+	return args.Get(0).(ShortURLRecord), args.Error(1)
+}
+
+// засчитать переход.
+func (m *MockURLStorage) RegisterClick(shortCode, variant string) error {
+	args := m.Called(shortCode, variant)
+	return args.Error(0)
+}
+
+// записать пачку переходов.
+func (m *MockURLStorage) AddClicks(counts []ClickCount) error {
+	args := m.Called(counts)
+	return args.Error(0)
+}
+
+// переходы по вариантам.
+func (m *MockURLStorage) GetVariantClicks(shortCode string) (map[string]int, error) {
+	args := m.Called(shortCode)
+	clicks, _ := args.Get(0).(map[string]int)
+	return clicks, args.Error(1)
+}
+
+// обновить запись.
+func (m *MockURLStorage) UpdateRecord(record ShortURLRecord) error {
+	args := m.Called(record)
+	return args.Error(0)
+}
+
 // прихранить.
 func (m *MockURLStorage) Save(record ShortURLRecord) error {
 	args := m.Called(record)
@@ -26,3 +57,123 @@ func (m *MockURLStorage) SaveBatch(records []ShortURLRecord) error {
 	args := m.Called(records)
 	return args.Error(0)
 }
+
+// сохранить аккаунт.
+func (m *MockURLStorage) SaveAccount(account Account) error {
+	args := m.Called(account)
+	return args.Error(0)
+}
+
+// получить аккаунт по логину.
+func (m *MockURLStorage) GetAccountByLogin(login string) (Account, error) {
+	args := m.Called(login)
+	return args.Get(0).(Account), args.Error(1)
+}
+
+// передать урлы другому пользаку.
+func (m *MockURLStorage) ReassignURLs(fromUserID, toUserID string) error {
+	args := m.Called(fromUserID, toUserID)
+	return args.Error(0)
+}
+
+// создать команду.
+func (m *MockURLStorage) SaveTeam(team Team, ownerID string) error {
+	args := m.Called(team, ownerID)
+	return args.Error(0)
+}
+
+// добавить участника.
+func (m *MockURLStorage) SaveTeamMember(member TeamMember) error {
+	args := m.Called(member)
+	return args.Error(0)
+}
+
+// исключить участника.
+func (m *MockURLStorage) DeleteTeamMember(teamID, userID string) error {
+	args := m.Called(teamID, userID)
+	return args.Error(0)
+}
+
+// роль в команде.
+func (m *MockURLStorage) GetTeamRole(teamID, userID string) (Role, error) {
+	args := m.Called(teamID, userID)
+	return args.Get(0).(Role), args.Error(1)
+}
+
+// участники команды.
+func (m *MockURLStorage) GetTeamMembers(teamID string) ([]TeamMember, error) {
+	args := m.Called(teamID)
+	return args.Get(0).([]TeamMember), args.Error(1)
+}
+
+// команды пользака.
+func (m *MockURLStorage) GetTeamsByUserID(userID string) ([]TeamMember, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]TeamMember), args.Error(1)
+}
+
+// урлы команды.
+func (m *MockURLStorage) GetURLsByTeamID(teamID string) ([]ShortURLRecord, error) {
+	args := m.Called(teamID)
+	return args.Get(0).([]ShortURLRecord), args.Error(1)
+}
+
+// найти урлы пользака.
+func (m *MockURLStorage) SearchURLsByUserID(userID string, filter URLFilter) ([]ShortURLRecord, error) {
+	args := m.Called(userID, filter)
+	return args.Get(0).([]ShortURLRecord), args.Error(1)
+}
+
+// урлы пользака.
+func (m *MockURLStorage) GetURLsByUserID(userID string) ([]ShortURLRecord, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]ShortURLRecord), args.Error(1)
+}
+
+// все записи по порядку кодов.
+func (m *MockURLStorage) ListRecords(afterShortCode string, limit int) ([]ShortURLRecord, error) {
+	args := m.Called(afterShortCode, limit)
+	return args.Get(0).([]ShortURLRecord), args.Error(1)
+}
+
+// снимок всех записей.
+func (m *MockURLStorage) ExportRecords(fn func(ShortURLRecord) error) error {
+	args := m.Called(fn)
+	return args.Error(0)
+}
+
+// заменить все записи.
+func (m *MockURLStorage) RestoreRecords(records []ShortURLRecord) error {
+	args := m.Called(records)
+	return args.Error(0)
+}
+
+// сохранить подписку.
+func (m *MockURLStorage) SaveWebhook(webhook Webhook) error {
+	args := m.Called(webhook)
+	return args.Error(0)
+}
+
+// подписка.
+func (m *MockURLStorage) GetWebhook(id string) (Webhook, error) {
+	args := m.Called(id)
+	return args.Get(0).(Webhook), args.Error(1)
+}
+
+// подписки пользака.
+func (m *MockURLStorage) GetWebhooksByUserID(userID string) ([]Webhook, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]Webhook), args.Error(1)
+}
+
+// удалить подписку.
+func (m *MockURLStorage) DeleteWebhook(id, userID string) error {
+	args := m.Called(id, userID)
+	return args.Error(0)
+}
+
+// журнал доставок.
+func (m *MockURLStorage) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	args := m.Called(webhookID, limit)
+	return args.Get(0).([]WebhookDelivery), args.Error(1)
+}
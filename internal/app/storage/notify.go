@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// канал уведомлений об изменении ссылок. Полезная нагрузка - коды через запятую.
+const LinksChannel = "shortener_links"
+
+const (
+	// нагрузка "сбросить все"
+	notifyAll = "*"
+	// NOTIFY принимает до 8000 байт, оставляем запас
+	maxNotifyPayload = 7000
+	// пауза перед переподключением LISTEN
+	listenMinBackoff = time.Second
+	listenMaxBackoff = time.Minute
+)
+
+// Invalidator - держатель локальных копий записей, например кэш.
+type Invalidator interface {
+	Invalidate(shortCodes ...string)
+	Purge()
+}
+
+// *sql.DB или *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// сообщить репликам, что записи кодов изменились. В транзакции уведомление уходит при коммите.
+func notifyLinks(ex execer, shortCodes ...string) error {
+	for _, payload := range notifyPayloads(shortCodes) {
+		if _, err := ex.Exec(`SELECT pg_notify($1, $2)`, LinksChannel, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// разбить коды на уведомления допустимого размера.
+func notifyPayloads(shortCodes []string) []string {
+	var payloads []string
+	var b strings.Builder
+	for _, code := range shortCodes {
+		if b.Len() > 0 && b.Len()+1+len(code) > maxNotifyPayload {
+			payloads = append(payloads, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(code)
+	}
+	if b.Len() > 0 {
+		payloads = append(payloads, b.String())
+	}
+	return payloads
+}
+
+// слушать изменения ссылок с других реплик и сбрасывать их в inv до отмены ctx.
+// Соединение переподключается с экспоненциальной паузой; после подключения и раз в resync
+// inv сбрасывается целиком, чтобы не зависеть от потерянных уведомлений.
+func (db *DBStorage) Listen(ctx context.Context, inv Invalidator, resync time.Duration) {
+	backoff := listenMinBackoff
+	for {
+		err := db.listen(ctx, inv, resync, func() { backoff = listenMinBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Log.Warn("links listener disconnected", zap.Duration("retry_in", backoff), zap.Error(err))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, listenMaxBackoff)
+	}
+}
+
+// одна сессия LISTEN; возвращается при обрыве соединения.
+func (db *DBStorage) listen(ctx context.Context, inv Invalidator, resync time.Duration, connected func()) error {
+	conn, err := pgx.Connect(ctx, db.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+LinksChannel); err != nil {
+		return err
+	}
+	// пока соединения не было, уведомления могли пройти мимо
+	inv.Purge()
+	connected()
+
+	next := time.Now().Add(resync)
+	for {
+		waitCtx, cancel := context.WithDeadline(ctx, next)
+		n, err := conn.WaitForNotification(waitCtx)
+		cancel()
+
+		switch {
+		case err == nil:
+			if n.Payload == notifyAll {
+				inv.Purge()
+			} else {
+				inv.Invalidate(strings.Split(n.Payload, ",")...)
+			}
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.Is(err, context.DeadlineExceeded):
+			// таймаут не рвет соединение, просто пора пересинхронизироваться
+			inv.Purge()
+			next = time.Now().Add(resync)
+		default:
+			return err
+		}
+	}
+}
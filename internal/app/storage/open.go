@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"os"
+	"strings"
+
+	"github.com/buharamanya/shortener/internal/app/urlcrypt"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// открыть хранилище по DSN базы (postgres://, postgresql:// или host=... dbname=...)
+// или пути к файлу. Без create файл должен уже существовать. keys - шифрование адресов, nil - без него.
+func Open(location string, create bool, keys *urlcrypt.Keyring) (URLStorage, error) {
+	if isDSN(location) {
+		return NewDBStorage(location, WithURLKeys(keys))
+	}
+
+	flags := os.O_RDWR | os.O_APPEND
+	if create {
+		flags |= os.O_CREATE
+	}
+	file, err := os.OpenFile(location, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return NewInMemoryStorage(file, WithFileKeys(keys)), nil
+}
+
+// DSN ли это базы: URL со схемой postgres или строка key=value, которую принимает pgx.
+// Путь к файлу со знаком = в имени DSN не считается: ключ состоит только из букв и _.
+func isDSN(location string) bool {
+	if strings.HasPrefix(location, "postgres://") || strings.HasPrefix(location, "postgresql://") {
+		return true
+	}
+	key, _, ok := strings.Cut(strings.TrimSpace(location), "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.TrimRight(key, "abcdefghijklmnopqrstuvwxyz_") != "" {
+		return false
+	}
+	_, err := pgconn.ParseConfig(location)
+	return err == nil
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/urlcrypt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+)
+
+// сколько после записи пользак читает свои данные с primary, пока реплики догоняют.
+const defaultReadYourWritesWindow = 5 * time.Second
+
+// ошибка настройки пула.
+var ErrExecMode = errors.New("unknown statement cache mode")
+
+// режимы выполнения запросов pgx по именам из конфига.
+var execModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// настройки пула соединений. Нулевые значения - умолчания database/sql и pgx.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	ExecMode        string
+}
+
+// настройка хранилища в базе.
+type DBOption func(o *dbOptions)
+
+type dbOptions struct {
+	pool     PoolConfig
+	replicas []string
+	window   time.Duration
+	keys     *urlcrypt.Keyring
+}
+
+// параметры пула для primary и реплик.
+func WithPool(pool PoolConfig) DBOption {
+	return func(o *dbOptions) {
+		o.pool = pool
+	}
+}
+
+// реплики для чтения ссылок.
+func WithReplicas(dsns ...string) DBOption {
+	return func(o *dbOptions) {
+		o.replicas = dsns
+	}
+}
+
+// сколько после записи читать данные пользака с primary.
+func WithReadYourWrites(window time.Duration) DBOption {
+	return func(o *dbOptions) {
+		o.window = window
+	}
+}
+
+// открыть пул с настройками.
+func openDB(dsn string, pool PoolConfig) (*sql.DB, error) {
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if pool.ExecMode != "" {
+		mode, ok := execModes[pool.ExecMode]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrExecMode, pool.ExecMode)
+		}
+		cfg.DefaultQueryExecMode = mode
+	}
+
+	db := stdlib.OpenDB(*cfg)
+	if pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+	if pool.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	}
+	return db, nil
+}
+
+type replica struct {
+	db      *sql.DB
+	host    string // для логов, без пароля из DSN
+	healthy atomic.Bool
+}
+
+// реплики для чтения. Запросы расходятся по живым репликам по кругу,
+// без живых реплик читаем с primary.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+
+	mu     sync.Mutex
+	writes map[string]time.Time // пользак -> когда последний раз писал
+	window time.Duration
+	now    func() time.Time
+}
+
+func newReplicaSet(dsns []string, pool PoolConfig, window time.Duration) (*replicaSet, error) {
+	rs := &replicaSet{
+		writes: make(map[string]time.Time),
+		window: window,
+		now:    time.Now,
+	}
+	for _, dsn := range dsns {
+		db, err := openDB(dsn, pool)
+		if err != nil {
+			rs.Close()
+			return nil, fmt.Errorf("ошибка инициализации реплики: %w", err)
+		}
+		r := &replica{db: db}
+		if cfg, err := pgx.ParseConfig(dsn); err == nil {
+			r.host = cfg.Host
+		}
+		// недоступная реплика не мешает старту, ее подхватит проверка
+		r.healthy.Store(db.Ping() == nil)
+		rs.replicas = append(rs.replicas, r)
+	}
+	return rs, nil
+}
+
+// живая реплика для чтения данных пользака или nil, если читать надо с primary.
+func (rs *replicaSet) pick(userID string) *replica {
+	if rs == nil || len(rs.replicas) == 0 || rs.recentlyWrote(userID) {
+		return nil
+	}
+	start := rs.next.Add(1)
+	for i := range rs.replicas {
+		r := rs.replicas[(start+uint64(i))%uint64(len(rs.replicas))]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// запомнить запись пользака.
+func (rs *replicaSet) wrote(userIDs ...string) {
+	if rs == nil || len(rs.replicas) == 0 {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := rs.now()
+	for _, userID := range userIDs {
+		if userID != "" {
+			rs.writes[userID] = now
+		}
+	}
+}
+
+func (rs *replicaSet) recentlyWrote(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	at, ok := rs.writes[userID]
+	if !ok {
+		return false
+	}
+	if rs.now().Sub(at) >= rs.window {
+		delete(rs.writes, userID)
+		return false
+	}
+	return true
+}
+
+// проверить реплики и забыть старые записи пользаков.
+func (rs *replicaSet) check(ctx context.Context) {
+	for _, r := range rs.replicas {
+		err := r.db.PingContext(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if healthy := err == nil; r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				logger.Log.Info("replica is back", zap.String("host", r.host))
+			} else {
+				logger.Log.Warn("replica is down", zap.String("host", r.host), zap.Error(err))
+			}
+		}
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	now := rs.now()
+	for userID, at := range rs.writes {
+		if now.Sub(at) >= rs.window {
+			delete(rs.writes, userID)
+		}
+	}
+}
+
+// ошибка чтения с реплики. Ответ сервера значит, что соединение живое;
+// все остальное - повод не слать на реплику запросы до следующей проверки.
+func (rs *replicaSet) failed(r *replica, err error) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return
+	}
+	if r.healthy.Swap(false) {
+		logger.Log.Warn("replica is down", zap.String("host", r.host), zap.Error(err))
+	}
+}
+
+func (rs *replicaSet) Close() error {
+	if rs == nil {
+		return nil
+	}
+	var errs []error
+	for _, r := range rs.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// прочитать данные пользака с реплики, при ошибке - с primary.
+// Если на реплике записи нет, она могла еще не доехать, поэтому тоже спрашиваем primary.
+func (db *DBStorage) read(userID string, fn func(conn *sql.DB) error) error {
+	r := db.replicas.pick(userID)
+	if r == nil {
+		return fn(db.DB)
+	}
+	err := fn(r.db)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		db.replicas.failed(r, err)
+	}
+	return fn(db.DB)
+}
+
+// проверять реплики раз в interval до отмены ctx.
+func (db *DBStorage) WatchReplicas(ctx context.Context, interval time.Duration) {
+	if db.replicas == nil || len(db.replicas.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			db.replicas.check(ctx)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package storage
+
+// правило перенаправления. Условия, заданные в правиле, должны выполниться все;
+// правило с весом без условий участвует в A/B разбиении трафика.
+type RedirectRule struct {
+	Variant   string   `json:"variant"`
+	Platforms []string `json:"platforms,omitempty"` // ios, android, desktop
+	Languages []string `json:"languages,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+	Weight    int      `json:"weight,omitempty"` // процент трафика
+	URL       string   `json:"url"`
+}
@@ -0,0 +1,31 @@
+package storage
+
+import "time"
+
+// окно активности ссылки. Незаданная граница не ограничивает.
+type Schedule struct {
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	// куда вести вне окна, пусто - показать страницу-заглушку
+	FallbackURL string `json:"fallback_url,omitempty"`
+}
+
+// состояние окна активности.
+type ScheduleState int
+
+const (
+	ScheduleActive ScheduleState = iota
+	SchedulePending
+	ScheduleEnded
+)
+
+// состояние окна на момент now.
+func (s Schedule) State(now time.Time) ScheduleState {
+	if s.ActiveFrom != nil && now.Before(*s.ActiveFrom) {
+		return SchedulePending
+	}
+	if s.ActiveUntil != nil && !now.Before(*s.ActiveUntil) {
+		return ScheduleEnded
+	}
+	return ScheduleActive
+}
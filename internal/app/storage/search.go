@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// фильтр списка ссылок. Пустые поля не ограничивают выборку.
+type URLFilter struct {
+	Tag string
+	// слова ищутся в заголовке и заметках целиком, сам адрес - по подстроке
+	Query string
+}
+
+// подходит ли запись под фильтр. Повторяет поиск в Postgres:
+// все слова запроса есть в заголовке или заметках, либо адрес содержит запрос.
+func (f URLFilter) Match(r ShortURLRecord) bool {
+	if f.Tag != "" && !slices.Contains(r.Tags, f.Tag) {
+		return false
+	}
+	if f.Query == "" {
+		return true
+	}
+	if strings.Contains(strings.ToLower(r.OriginalURL), strings.ToLower(f.Query)) {
+		return true
+	}
+
+	query := searchWords(f.Query)
+	if len(query) == 0 {
+		return false
+	}
+	text := searchWords(r.Title + " " + r.Notes)
+	for _, w := range query {
+		if !slices.Contains(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// слова текста в нижнем регистре.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"errors"
+	"time"
 )
 
 // не нашел.
@@ -10,21 +11,101 @@ var ErrNotFound = errors.New("URL not found")
 // удалил.
 var ErrDeleted = errors.New("URL was deleted")
 
+// лимит переходов исчерпан.
+var ErrExhausted = errors.New("URL click limit exhausted")
+
+// логин занят.
+var ErrLoginTaken = errors.New("login already taken")
+
+// ErrURLExists - обычная ссылка на адрес уже есть, подробности в *ConflictError.
+var ErrURLExists = errors.New("URL is already shortened")
+
+// CodeTakenError - короткий код уже занят другой ссылкой, например обычной,
+// которой потом добавили настройки. Ссылке нужен другой код.
+type CodeTakenError struct {
+	ShortCode string
+}
+
+func (e *CodeTakenError) Error() string {
+	return "short code " + e.ShortCode + " is already taken"
+}
+
+// ConflictError - обычная ссылка на этот адрес уже сохранена под кодом ShortCode.
+type ConflictError struct {
+	ShortCode string
+	Err       error
+}
+
+func (e *ConflictError) Error() string {
+	return "URL is already shortened as " + e.ShortCode
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
 // рекорд урла.
 type ShortURLRecord struct {
 	ShortCode     string `json:"short_code"`
 	OriginalURL   string `json:"original_url"`
 	CorrelationID string `json:"correlation_id"`
 	UserID        string `json:"user_id"`
+	TeamID        string `json:"team_id,omitempty"`
 	DeletedFlag   bool   `json:"is_deleted"`
+	PasswordHash  string `json:"password_hash,omitempty"`
+	MaxClicks     int    `json:"max_clicks,omitempty"` // 0 - без ограничения
+	Clicks        int    `json:"clicks,omitempty"`
+	Schedule
+	Rules []RedirectRule `json:"rules,omitempty"`
+	Forwarding
+	RedirectStatus int       `json:"redirect_status,omitempty"` // 0 - код по умолчанию
+	Title          string    `json:"title,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	Notes          string    `json:"notes,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// обычная ли ссылка: личная, без пароля, лимита переходов, окна активности, правил,
+// своего кода ответа и переадресации. Обычные ссылки на один адрес не дублируются,
+// ссылки с настройками всегда отдельные: владелец может их перенаправить.
+func (r ShortURLRecord) Plain() bool {
+	return r.PasswordHash == "" && r.MaxClicks == 0 && r.ActiveFrom == nil && r.ActiveUntil == nil &&
+		!r.Forwarding.Enabled() && len(r.Rules) == 0 && r.RedirectStatus == 0 && r.TeamID == ""
+}
+
+// именованный аккаунт. UserID совпадает с идентификатором в токене.
+type Account struct {
+	UserID       string `json:"user_id"`
+	Login        string `json:"login"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// интерфейс хранилища аккаунтов.
+type AccountStorage interface {
+	SaveAccount(account Account) error
+	GetAccountByLogin(login string) (Account, error)
+	ReassignURLs(fromUserID, toUserID string) error
 }
 
 // интерфейс хранилища.
 type URLStorage interface {
+	AccountStorage
+	TeamStorage
+	WebhookStorage
+
 	Get(shortCode string) (string, error)
+	GetRecord(shortCode string) (ShortURLRecord, error)
+	RegisterClick(shortCode, variant string) error
+	AddClicks(counts []ClickCount) error
+	GetVariantClicks(shortCode string) (map[string]int, error)
+	UpdateRecord(record ShortURLRecord) error
 	Save(record ShortURLRecord) error
 	SaveBatch(records []ShortURLRecord) error
 	GetURLsByUserID(userID string) ([]ShortURLRecord, error)
-	DeleteURLs(shortCodes []string, userID string) error
+	SearchURLsByUserID(userID string, filter URLFilter) ([]ShortURLRecord, error)
+	ListRecords(afterShortCode string, limit int) ([]ShortURLRecord, error)
+	ExportRecords(fn func(ShortURLRecord) error) error
+	RestoreRecords(records []ShortURLRecord) error
+	DeleteURLs(shortCodes []string, userID string) ([]ShortURLRecord, error)
 	Close() error
 }
@@ -0,0 +1,58 @@
+package storage
+
+import "errors"
+
+// ErrLastOwner - изменение оставило бы команду без владельца.
+var ErrLastOwner = errors.New("team must keep at least one owner")
+
+// роль участника команды.
+type Role string
+
+// роли по возрастанию прав.
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// известная ли роль.
+func (r Role) Valid() bool {
+	return roleRank[r] > 0
+}
+
+// хватает ли роли для действия, требующего need.
+func (r Role) Allows(need Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[need]
+}
+
+// команда.
+type Team struct {
+	ID   string `json:"team_id"`
+	Name string `json:"name"`
+}
+
+// участник команды. В файловом хранилище пустая роль означает исключение.
+type TeamMember struct {
+	TeamID   string `json:"team_id"`
+	TeamName string `json:"team_name,omitempty"`
+	UserID   string `json:"user_id"`
+	Role     Role   `json:"role"`
+}
+
+// интерфейс хранилища команд.
+type TeamStorage interface {
+	SaveTeam(team Team, ownerID string) error
+	// понижение или исключение последнего владельца возвращает ErrLastOwner
+	SaveTeamMember(member TeamMember) error
+	DeleteTeamMember(teamID, userID string) error
+	GetTeamRole(teamID, userID string) (Role, error)
+	GetTeamMembers(teamID string) ([]TeamMember, error)
+	GetTeamsByUserID(userID string) ([]TeamMember, error)
+	GetURLsByTeamID(teamID string) ([]ShortURLRecord, error)
+}
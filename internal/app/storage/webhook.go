@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// состояния доставки события.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// подписка пользака на события. Пустой URL в файловом хранилище означает удаление.
+type Webhook struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// доставка события подписчику, она же запись outbox.
+type WebhookDelivery struct {
+	ID            string          `json:"id"`
+	WebhookID     string          `json:"webhook_id"`
+	EventID       string          `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// событие для рассылки подписчикам пользака.
+type WebhookEvent struct {
+	ID      string
+	Type    string
+	UserID  string
+	Payload json.RawMessage
+}
+
+// идентификатор доставки события подписке.
+func deliveryID(eventID, webhookID string) string {
+	return eventID + "." + webhookID
+}
+
+// подписан ли хук на событие.
+func (w Webhook) Subscribed(eventType string) bool {
+	return slices.Contains(w.Events, eventType)
+}
+
+// интерфейс хранилища подписок и очереди доставок.
+type WebhookStorage interface {
+	SaveWebhook(webhook Webhook) error
+	GetWebhook(id string) (Webhook, error)
+	GetWebhooksByUserID(userID string) ([]Webhook, error)
+	DeleteWebhook(id, userID string) error
+	// поставить в очередь доставки события всем подходящим подпискам
+	EnqueueWebhookEvent(event WebhookEvent, now time.Time) error
+	// забрать готовые к отправке доставки и отложить их на lease, чтобы их не взял другой обработчик
+	ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(delivery WebhookDelivery) error
+	// последние доставки подписки, новые первыми
+	GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error)
+	// удалить завершенные доставки, обновленные раньше before, и все сверх keep
+	// последних у каждой подписки; keep 0 - без ограничения. Возвращает число удаленных.
+	PruneWebhookDeliveries(before time.Time, keep int) (int, error)
+}
@@ -0,0 +1,180 @@
+// Package stream раздает события о ссылках открытым соединениям пользаков (SSE).
+package stream
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/webhooks"
+	"github.com/google/uuid"
+)
+
+// сколько событий подписчик может не забрать, прежде чем его отключат.
+const subscriberBuffer = 64
+
+// событие с порядковым идентификатором для Last-Event-ID.
+type Message struct {
+	ID    string
+	Event webhooks.Event
+}
+
+type entry struct {
+	seq    uint64
+	userID string
+	event  webhooks.Event
+}
+
+// Broker хранит последние события в кольцевом буфере и раздает новые подписчикам.
+// Идентификатор события - "<эпоха>-<номер>": после перезапуска эпоха меняется,
+// и клиент со старым Last-Event-ID получает все, что есть в буфере.
+type Broker struct {
+	mu     sync.Mutex
+	epoch  string
+	ring   []entry
+	next   int // куда писать следующее событие
+	seq    uint64
+	subs   map[string]map[*Subscription]struct{}
+	closed bool
+	now    func() time.Time
+}
+
+// подписка на события пользака.
+type Subscription struct {
+	C      <-chan Message
+	c      chan Message
+	userID string
+	broker *Broker
+	once   sync.Once
+}
+
+// создать брокер, помнящий последние size событий всех пользаков.
+func NewBroker(size int) *Broker {
+	return &Broker{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		ring:  make([]entry, 0, size),
+		subs:  make(map[string]map[*Subscription]struct{}),
+		now:   time.Now,
+	}
+}
+
+// опубликовать событие о ссылке пользака.
+func (b *Broker) Publish(userID, eventType string, link webhooks.LinkData) {
+	if userID == "" {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	b.seq++
+	e := entry{
+		seq:    b.seq,
+		userID: userID,
+		event: webhooks.Event{
+			ID:        uuid.NewString(),
+			Type:      eventType,
+			CreatedAt: b.now().UTC(),
+			Data:      link,
+		},
+	}
+	if len(b.ring) < cap(b.ring) {
+		b.ring = append(b.ring, e)
+	} else if cap(b.ring) > 0 {
+		b.ring[b.next] = e
+		b.next = (b.next + 1) % cap(b.ring)
+	}
+
+	msg := b.message(e)
+	for sub := range b.subs[userID] {
+		select {
+		case sub.c <- msg:
+		default:
+			// медленный клиент: отключаем, он переподключится с Last-Event-ID
+			b.remove(sub)
+		}
+	}
+}
+
+func (b *Broker) message(e entry) Message {
+	return Message{ID: b.epoch + "-" + strconv.FormatUint(e.seq, 10), Event: e.event}
+}
+
+// подписаться на события пользака. Возвращает и пропущенные после lastEventID
+// события из буфера; подписка и выборка идут под одной блокировкой, так что ничего не теряется.
+func (b *Broker) Subscribe(userID, lastEventID string) (*Subscription, []Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Message, subscriberBuffer)
+	sub := &Subscription{C: c, c: c, userID: userID, broker: b}
+	if b.closed {
+		close(c)
+		return sub, nil
+	}
+
+	var backlog []Message
+	if lastEventID != "" {
+		after := b.lastSeq(lastEventID)
+		for i := range b.ring {
+			e := b.ring[(b.next+i)%len(b.ring)]
+			if e.userID == userID && e.seq > after {
+				backlog = append(backlog, b.message(e))
+			}
+		}
+	}
+
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[*Subscription]struct{})
+	}
+	b.subs[userID][sub] = struct{}{}
+	return sub, backlog
+}
+
+// номер последнего полученного клиентом события; чужая эпоха - с начала буфера.
+func (b *Broker) lastSeq(lastEventID string) uint64 {
+	epoch, seq, ok := strings.Cut(lastEventID, "-")
+	if !ok || epoch != b.epoch {
+		return 0
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// отписаться. Канал подписки закрывается.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s)
+}
+
+// убрать подписчика, вызывается под блокировкой.
+func (b *Broker) remove(sub *Subscription) {
+	sub.once.Do(func() {
+		delete(b.subs[sub.userID], sub)
+		if len(b.subs[sub.userID]) == 0 {
+			delete(b.subs, sub.userID)
+		}
+		close(sub.c)
+	})
+}
+
+// закрыть все подписки, например при остановке сервера.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, subs := range b.subs {
+		for sub := range subs {
+			b.remove(sub)
+		}
+	}
+}
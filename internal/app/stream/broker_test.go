@@ -0,0 +1,96 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/buharamanya/shortener/internal/app/webhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func codes(msgs []Message) []string {
+	var res []string
+	for _, m := range msgs {
+		res = append(res, m.Event.Data.ShortCode)
+	}
+	return res
+}
+
+func TestBroker_Deliver(t *testing.T) {
+	b := NewBroker(10)
+	sub, backlog := b.Subscribe("user-1", "")
+	defer sub.Close()
+	assert.Empty(t, backlog)
+
+	b.Publish("user-2", webhooks.EventLinkCreated, webhooks.LinkData{ShortCode: "other"})
+	b.Publish("user-1", webhooks.EventLinkClicked, webhooks.LinkData{ShortCode: "abc123"})
+
+	msg := <-sub.C
+	assert.Equal(t, webhooks.EventLinkClicked, msg.Event.Type)
+	assert.Equal(t, "abc123", msg.Event.Data.ShortCode)
+	assert.Len(t, sub.C, 0)
+}
+
+func TestBroker_Resume(t *testing.T) {
+	b := NewBroker(3)
+	var ids []string
+	for _, code := range []string{"a", "b", "c", "d"} {
+		sub, _ := b.Subscribe("user-1", "")
+		b.Publish("user-1", webhooks.EventLinkCreated, webhooks.LinkData{ShortCode: code})
+		ids = append(ids, (<-sub.C).ID)
+		sub.Close()
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		expected    []string
+	}{
+		{"No_id_no_backlog", "", nil},
+		{"After_b", ids[1], []string{"c", "d"}},
+		{"Up_to_date", ids[3], nil},
+		// "a" уже вытеснено из буфера, отдаем что есть
+		{"Before_buffer", ids[0], []string{"b", "c", "d"}},
+		{"Other_epoch", "zzz-2", []string{"b", "c", "d"}},
+		{"Garbage", "garbage", []string{"b", "c", "d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, backlog := b.Subscribe("user-1", tt.lastEventID)
+			defer sub.Close()
+			assert.Equal(t, tt.expected, codes(backlog))
+		})
+	}
+}
+
+func TestBroker_DropsSlowSubscriber(t *testing.T) {
+	b := NewBroker(10)
+	sub, _ := b.Subscribe("user-1", "")
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish("user-1", webhooks.EventLinkClicked, webhooks.LinkData{ShortCode: "abc123"})
+	}
+
+	n := 0
+	for range sub.C {
+		n++
+	}
+	assert.Equal(t, subscriberBuffer, n)
+	// повторное закрытие безопасно
+	sub.Close()
+}
+
+func TestBroker_Close(t *testing.T) {
+	b := NewBroker(10)
+	sub, _ := b.Subscribe("user-1", "")
+	b.Close()
+
+	_, ok := <-sub.C
+	assert.False(t, ok)
+
+	late, _ := b.Subscribe("user-1", "")
+	_, ok = <-late.C
+	require.False(t, ok)
+	b.Publish("user-1", webhooks.EventLinkClicked, webhooks.LinkData{ShortCode: "abc123"})
+}
@@ -0,0 +1,263 @@
+// Package urlcrypt шифрует адреса назначения перед записью в хранилище.
+//
+// Каждый адрес шифруется своим случайным ключом данных (AES-256-GCM), а ключ данных -
+// ключом из связки с идентификатором. Ротация перешифровывает только ключ данных.
+// Для поиска дублей рядом хранится слепой индекс - HMAC-SHA256 адреса на отдельном ключе,
+// который при ротации не меняется.
+//
+// Связка задается JSON:
+//
+//	{"active": "2026-10", "index_key": "<base64>", "keys": {"2026-09": "<base64>", "2026-10": "<base64>"}}
+//
+// Все ключи - 32 байта в base64, например из `head -c 32 /dev/urandom | base64`.
+package urlcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// формат зашифрованного значения: enc:v1:<kid>:<ключ данных>:<адрес>,
+// обе части - base64 от nonce и шифротекста.
+const prefix = "enc:v1:"
+
+const keySize = 32
+
+// ошибки расшифровки и загрузки связки.
+var (
+	ErrUnknownKey = errors.New("unknown url encryption key")
+	ErrMalformed  = errors.New("malformed encrypted url")
+)
+
+// связка ключей шифрования адресов. Методы nil связки оставляют адреса как есть,
+// так хранилище работает одинаково с шифрованием и без.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+	index  []byte
+}
+
+// описание связки в файле или переменной окружения.
+type keyringFile struct {
+	Active   string            `json:"active"`
+	IndexKey string            `json:"index_key"`
+	Keys     map[string]string `json:"keys"`
+}
+
+// создать связку. Шифруется активным ключом, расшифровать можно любым.
+func New(active string, keys map[string][]byte, indexKey []byte) (*Keyring, error) {
+	if len(indexKey) < keySize {
+		return nil, fmt.Errorf("index key must be at least %d bytes", keySize)
+	}
+	k := &Keyring{
+		active: active,
+		keys:   make(map[string]cipher.AEAD, len(keys)),
+		index:  indexKey,
+	}
+	for kid, key := range keys {
+		if kid == "" || strings.Contains(kid, ":") {
+			return nil, fmt.Errorf("invalid key id %q", kid)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key %q must be %d bytes, got %d", kid, keySize, len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[kid] = aead
+	}
+	if _, ok := k.keys[active]; !ok {
+		return nil, fmt.Errorf("active key %q: %w", active, ErrUnknownKey)
+	}
+	return k, nil
+}
+
+// разобрать связку из JSON.
+func Parse(data []byte) (*Keyring, error) {
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse url keys: %w", err)
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(f.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("index key: %w", err)
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for kid, encoded := range f.Keys {
+		if keys[kid], err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("key %q: %w", kid, err)
+		}
+	}
+	return New(f.Active, keys, indexKey)
+}
+
+// загрузить связку из значения переменной окружения или, если его нет, из файла.
+// Без того и другого шифрование выключено и возвращается nil.
+func Load(inline, path string) (*Keyring, error) {
+	if inline != "" {
+		return Parse([]byte(inline))
+	}
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read url keys file: %w", err)
+	}
+	return Parse(data)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// зашифровать адрес активным ключом.
+func (k *Keyring) Seal(url string) (string, error) {
+	if k == nil {
+		return url, nil
+	}
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedURL, err := seal(data, []byte(url), nil)
+	if err != nil {
+		return "", err
+	}
+	sealedKey, err := seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.active + ":" + sealedKey + ":" + sealedURL, nil
+}
+
+// расшифровать значение. Незашифрованный адрес, записанный до включения шифрования,
+// возвращается как есть.
+func (k *Keyring) Open(value string) (string, error) {
+	kid, sealedKey, sealedURL, ok := split(value)
+	if !ok {
+		return value, nil
+	}
+	if k == nil {
+		return "", fmt.Errorf("%w: %q, url encryption is not configured", ErrUnknownKey, kid)
+	}
+	dataKey, err := k.openKey(kid, sealedKey)
+	if err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	url, err := open(data, sealedURL, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(url), nil
+}
+
+// перешифровать значение активным ключом. У зашифрованного меняется только ключ данных,
+// незашифрованное шифруется целиком.
+func (k *Keyring) Rotate(value string) (string, error) {
+	if k == nil {
+		return value, nil
+	}
+	kid, sealedKey, sealedURL, ok := split(value)
+	if !ok {
+		return k.Seal(value)
+	}
+	if kid == k.active {
+		return value, nil
+	}
+	dataKey, err := k.openKey(kid, sealedKey)
+	if err != nil {
+		return "", err
+	}
+	if sealedKey, err = seal(k.keys[k.active], dataKey, []byte(k.active)); err != nil {
+		return "", err
+	}
+	return prefix + k.active + ":" + sealedKey + ":" + sealedURL, nil
+}
+
+// нужно ли перешифровать значение: оно открыто или зашифровано не активным ключом.
+func (k *Keyring) Stale(value string) bool {
+	if k == nil {
+		return false
+	}
+	kid, _, _, ok := split(value)
+	return !ok || kid != k.active
+}
+
+// слепой индекс адреса в hex. Для nil связки - пустая строка.
+func (k *Keyring) BlindIndex(url string) string {
+	if k == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(url))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (k *Keyring) openKey(kid, sealedKey string) ([]byte, error) {
+	if kid == "" {
+		return nil, ErrMalformed
+	}
+	kek, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	return open(kek, sealedKey, []byte(kid))
+}
+
+// разобрать зашифрованное значение на части.
+func split(value string) (kid, sealedKey, sealedURL string, ok bool) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return "", "", "", false
+	}
+	parts := strings.SplitN(rest, ":", 3)
+	if len(parts) != 3 {
+		// значение с префиксом, но битое - пусть упадет при расшифровке
+		return "", "", "", true
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+func seal(aead cipher.AEAD, plaintext, additional []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, additional)), nil
+}
+
+func open(aead cipher.AEAD, sealed string, additional []byte) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,160 @@
+package urlcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func newKeyring(t *testing.T, active string) *Keyring {
+	t.Helper()
+	k, err := New(active, map[string][]byte{"old": key(1), "new": key(2)}, key(9))
+	require.NoError(t, err)
+	return k
+}
+
+func TestSealOpen(t *testing.T) {
+	k := newKeyring(t, "new")
+	url := "https://example.com/reset?token=s3cr3t"
+
+	sealed, err := k.Seal(url)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "enc:v1:new:"))
+	assert.NotContains(t, sealed, "s3cr3t")
+
+	// шифрование недетерминировано
+	again, err := k.Seal(url)
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	opened, err := k.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, url, opened)
+
+	// старые незашифрованные адреса читаются как есть
+	opened, err = k.Open(url)
+	require.NoError(t, err)
+	assert.Equal(t, url, opened)
+}
+
+func TestOpen_Errors(t *testing.T) {
+	k := newKeyring(t, "new")
+	sealed, err := k.Seal("https://example.com")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		value   string
+		wantErr error
+	}{
+		{name: "unknown key", keyring: k, value: strings.Replace(sealed, ":new:", ":gone:", 1), wantErr: ErrUnknownKey},
+		{name: "no keyring", keyring: nil, value: sealed, wantErr: ErrUnknownKey},
+		{name: "tampered", keyring: k, value: sealed[:len(sealed)-2] + "AA", wantErr: ErrMalformed},
+		{name: "truncated", keyring: k, value: "enc:v1:new:abc", wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.keyring.Open(tt.value)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old := newKeyring(t, "old")
+	sealed, err := old.Seal("https://example.com/a")
+	require.NoError(t, err)
+
+	k := newKeyring(t, "new")
+	assert.True(t, k.Stale(sealed))
+	assert.True(t, k.Stale("https://example.com/plain"))
+
+	rotated, err := k.Rotate(sealed)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rotated, "enc:v1:new:"))
+	assert.False(t, k.Stale(rotated))
+	// данные не перешифровываются, меняется только обертка ключа
+	assert.Equal(t, sealed[strings.LastIndex(sealed, ":"):], rotated[strings.LastIndex(rotated, ":"):])
+
+	opened, err := k.Open(rotated)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/a", opened)
+
+	// после удаления старого ключа перешифрованное значение по-прежнему читается
+	onlyNew, err := New("new", map[string][]byte{"new": key(2)}, key(9))
+	require.NoError(t, err)
+	_, err = onlyNew.Open(sealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	opened, err = onlyNew.Open(rotated)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/a", opened)
+
+	plain, err := k.Rotate("https://example.com/plain")
+	require.NoError(t, err)
+	assert.False(t, k.Stale(plain))
+}
+
+func TestBlindIndex(t *testing.T) {
+	k := newKeyring(t, "new")
+	rotated := newKeyring(t, "old")
+
+	assert.Equal(t, k.BlindIndex("https://example.com"), rotated.BlindIndex("https://example.com"))
+	assert.NotEqual(t, k.BlindIndex("https://example.com"), k.BlindIndex("https://example.org"))
+	assert.Len(t, k.BlindIndex("https://example.com"), 64)
+
+	var none *Keyring
+	assert.Empty(t, none.BlindIndex("https://example.com"))
+}
+
+func TestNilKeyring(t *testing.T) {
+	var k *Keyring
+	sealed, err := k.Seal("https://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", sealed)
+	assert.False(t, k.Stale(sealed))
+}
+
+func TestLoad(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString
+	valid := `{"active": "k1", "index_key": "` + b64(key(9)) + `", "keys": {"k1": "` + b64(key(1)) + `"}}`
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(valid), 0600))
+
+	tests := []struct {
+		name    string
+		inline  string
+		path    string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "disabled", wantNil: true},
+		{name: "from file", path: path},
+		{name: "inline wins", inline: valid, path: "missing.json"},
+		{name: "missing file", path: "missing.json", wantErr: true},
+		{name: "unknown active", inline: `{"active": "k2", "index_key": "` + b64(key(9)) + `", "keys": {"k1": "` + b64(key(1)) + `"}}`, wantErr: true},
+		{name: "short key", inline: `{"active": "k1", "index_key": "` + b64(key(9)) + `", "keys": {"k1": "` + b64([]byte("short")) + `"}}`, wantErr: true},
+		{name: "no index key", inline: `{"active": "k1", "keys": {"k1": "` + b64(key(1)) + `"}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := Load(tt.inline, tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantNil, k == nil)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Package urlnorm проверяет и приводит к каноническому виду сокращаемые URL.
+package urlnorm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/idna"
+)
+
+// ошибки валидации, текст уходит клиенту.
+var (
+	ErrEmpty         = errors.New("URL cannot be empty")
+	ErrInvalid       = errors.New("URL is not valid")
+	ErrScheme        = errors.New("URL scheme is not allowed")
+	ErrNoHost        = errors.New("URL has no host")
+	ErrSelfReference = errors.New("URL points to the shortener itself")
+)
+
+// схемы по умолчанию.
+var defaultSchemes = []string{"http", "https"}
+
+// порты по умолчанию, которые выкидываются при нормализации.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+}
+
+// настройки нормализации.
+type Options struct {
+	AllowedSchemes []string // по умолчанию http и https
+	SelfBaseURL    string   // ссылки на этот хост отклоняются
+	SortQuery      bool
+}
+
+// Normalizer проверяет и нормализует URL.
+type Normalizer struct {
+	schemes   map[string]bool
+	selfHost  atomic.Pointer[string]
+	sortQuery bool
+}
+
+// создать нормализатор.
+func New(opts Options) *Normalizer {
+	schemes := opts.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = defaultSchemes
+	}
+
+	n := &Normalizer{
+		schemes:   make(map[string]bool, len(schemes)),
+		sortQuery: opts.SortQuery,
+	}
+	for _, s := range schemes {
+		n.schemes[strings.ToLower(s)] = true
+	}
+
+	n.SetSelfBaseURL(opts.SelfBaseURL)
+
+	return n
+}
+
+// сменить собственный адрес сократителя на лету.
+func (n *Normalizer) SetSelfBaseURL(baseURL string) {
+	var host string
+	if baseURL != "" {
+		if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+			host, _ = normalizeHost(strings.ToLower(u.Scheme), u.Host)
+		}
+	}
+	n.selfHost.Store(&host)
+}
+
+// Normalize возвращает канонический вид URL: схема и хост в нижнем регистре,
+// хост в punycode, без порта по умолчанию, с непустым путем.
+func (n *Normalizer) Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrEmpty
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalid, unwrapURLError(err))
+	}
+
+	if u.Scheme == "" {
+		return "", fmt.Errorf("%w: missing scheme", ErrInvalid)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !n.schemes[u.Scheme] {
+		return "", fmt.Errorf("%w: %s", ErrScheme, u.Scheme)
+	}
+
+	if u.Opaque != "" || u.Host == "" {
+		return "", ErrNoHost
+	}
+
+	host, err := normalizeHost(u.Scheme, u.Host)
+	if err != nil {
+		return "", err
+	}
+	u.Host = host
+
+	if self := *n.selfHost.Load(); self != "" && host == self {
+		return "", ErrSelfReference
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	if n.sortQuery && u.RawQuery != "" {
+		// Encode сортирует параметры по ключу
+		u.RawQuery = u.Query().Encode()
+	}
+
+	return u.String(), nil
+}
+
+// привести хост к нижнему регистру и punycode, выкинуть порт по умолчанию.
+func normalizeHost(scheme, hostport string) (string, error) {
+	host, port := hostport, ""
+	if h, p, err := net.SplitHostPort(hostport); err == nil {
+		host, port = h, p
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "", ErrNoHost
+	}
+
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		host = ip.String()
+		if ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+	} else {
+		ascii, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return "", fmt.Errorf("%w: bad host %q", ErrInvalid, host)
+		}
+		host = ascii
+	}
+
+	if port != "" && port != defaultPorts[scheme] {
+		host += ":" + port
+	}
+
+	return host, nil
+}
+
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package urlnorm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizer_Normalize(t *testing.T) {
+	n := New(Options{SelfBaseURL: "http://localhost:8080"})
+
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+		err      error
+	}{
+		{"Lowercase_scheme_and_host", "HTTP://Example.COM/Path", "http://example.com/Path", nil},
+		{"Empty_path", "http://example.com", "http://example.com/", nil},
+		{"Default_port_http", "http://example.com:80/a", "http://example.com/a", nil},
+		{"Default_port_https", "https://example.com:443/", "https://example.com/", nil},
+		{"Custom_port_kept", "https://example.com:8443/", "https://example.com:8443/", nil},
+		{"IDNA_host", "https://пример.рф/путь", "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C", nil},
+		{"Trailing_dot", "https://example.com./", "https://example.com/", nil},
+		{"IPv6_host", "http://[::1]:80/", "http://[::1]/", nil},
+		{"Query_order_kept", "https://example.com/?b=2&a=1#frag", "https://example.com/?b=2&a=1#frag", nil},
+		{"Spaces_trimmed", "  https://example.com/  ", "https://example.com/", nil},
+		{"Empty", "   ", "", ErrEmpty},
+		{"Javascript", "javascript:alert(1)", "", ErrScheme},
+		{"Not_a_URL", "not a url", "", ErrInvalid},
+		{"No_host", "http:///path", "", ErrNoHost},
+		{"Self_reference", "http://LOCALHOST:8080/abc", "", ErrSelfReference},
+		{"Other_port_is_not_self", "http://localhost:9090/abc", "http://localhost:9090/abc", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := n.Normalize(tt.raw)
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err), "ожидалась ошибка %v, получено %v", tt.err, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestNormalizer_Options(t *testing.T) {
+	n := New(Options{AllowedSchemes: []string{"https", "ftp"}, SortQuery: true})
+
+	got, err := n.Normalize("https://example.com/?b=2&a=1&a=0")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/?a=1&a=0&b=2", got)
+
+	_, err = n.Normalize("http://example.com/")
+	assert.ErrorIs(t, err, ErrScheme)
+
+	got, err = n.Normalize("FTP://files.example.com:21/pub")
+	assert.NoError(t, err)
+	assert.Equal(t, "ftp://files.example.com/pub", got)
+}
+
+func TestNormalizer_SetSelfBaseURL(t *testing.T) {
+	n := New(Options{SelfBaseURL: "http://localhost:8080"})
+
+	n.SetSelfBaseURL("https://sho.rt")
+	_, err := n.Normalize("https://SHO.RT/abc")
+	assert.ErrorIs(t, err, ErrSelfReference)
+
+	// прежний адрес больше не считается собственным
+	got, err := n.Normalize("http://localhost:8080/abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/abc", got)
+}
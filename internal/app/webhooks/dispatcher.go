@@ -0,0 +1,316 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/logger"
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// параметры по умолчанию.
+const (
+	defaultMaxAttempts  = 8
+	defaultBaseDelay    = 10 * time.Second
+	defaultMaxDelay     = time.Hour
+	defaultPollInterval = 5 * time.Second
+	defaultTimeout      = 10 * time.Second
+	// сколько хранить журнал завершенных доставок и сколько записей на подписку
+	defaultRetention     = 30 * 24 * time.Hour
+	defaultKeepPerHook   = 1000
+	defaultPruneInterval = time.Hour
+	// сколько доставок забирать за раз и отправлять одновременно
+	batchSize   = 50
+	concurrency = 8
+)
+
+// Dispatcher складывает события в outbox хранилища и в фоне доставляет их подписчикам.
+// Доставки переживают перезапуск: после старта Run досылает все, что осталось в очереди.
+type Dispatcher struct {
+	store         storage.WebhookStorage
+	client        *http.Client
+	allowInternal bool
+	maxAttempts   int
+	baseDelay     time.Duration
+	maxDelay      time.Duration
+	pollInterval  time.Duration
+	retention     time.Duration
+	keepPerHook   int
+	pruneInterval time.Duration
+	wake          chan struct{}
+	now           func() time.Time
+}
+
+// настройка диспетчера.
+type Option func(d *Dispatcher)
+
+// свой http клиент, например с другим таймаутом.
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// разрешить доставку на внутренние адреса: localhost, частные сети и т.п.
+// Нужно для тестов и закрытых инсталляций; игнорируется вместе с WithHTTPClient.
+func WithInternalTargets() Option {
+	return func(d *Dispatcher) {
+		d.allowInternal = true
+	}
+}
+
+// число попыток и границы экспоненциальной задержки между ними.
+func WithRetry(maxAttempts int, baseDelay, maxDelay time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.maxAttempts = maxAttempts
+		d.baseDelay = baseDelay
+		d.maxDelay = maxDelay
+	}
+}
+
+// как часто проверять очередь, если новых событий нет.
+func WithPollInterval(interval time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.pollInterval = interval
+	}
+}
+
+// сколько хранить завершенные доставки и сколько последних оставлять на подписку.
+// Нулевое значение оставляет умолчание.
+func WithRetention(retention time.Duration, keepPerHook int) Option {
+	return func(d *Dispatcher) {
+		if retention > 0 {
+			d.retention = retention
+		}
+		if keepPerHook > 0 {
+			d.keepPerHook = keepPerHook
+		}
+	}
+}
+
+// создать диспетчер.
+func NewDispatcher(store storage.WebhookStorage, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		store:         store,
+		maxAttempts:   defaultMaxAttempts,
+		baseDelay:     defaultBaseDelay,
+		maxDelay:      defaultMaxDelay,
+		pollInterval:  defaultPollInterval,
+		retention:     defaultRetention,
+		keepPerHook:   defaultKeepPerHook,
+		pruneInterval: defaultPruneInterval,
+		wake:          make(chan struct{}, 1),
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.client == nil {
+		d.client = NewHTTPClient(defaultTimeout, d.allowInternal)
+	}
+	return d
+}
+
+// опубликовать событие о ссылке пользака. Событие сразу пишется в outbox,
+// отправка идет в фоне; ошибки только логируются, чтобы не ломать основной запрос.
+func (d *Dispatcher) Publish(userID, eventType string, link LinkData) {
+	if userID == "" {
+		return
+	}
+
+	event := Event{
+		ID:        uuid.NewString(),
+		Type:      eventType,
+		CreatedAt: d.now().UTC(),
+		Data:      link,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Log.Error("failed to encode webhook event", zap.Error(err))
+		return
+	}
+
+	err = d.store.EnqueueWebhookEvent(storage.WebhookEvent{
+		ID:      event.ID,
+		Type:    event.Type,
+		UserID:  userID,
+		Payload: payload,
+	}, d.now())
+	if err != nil {
+		logger.Log.Error("failed to enqueue webhook event", zap.String("event", eventType), zap.Error(err))
+		return
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// рассылать события до отмены ctx. Раз в pruneInterval чистит журнал доставок.
+func (d *Dispatcher) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	prune := time.NewTicker(d.pruneInterval)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-prune.C:
+			d.Prune()
+			continue
+		case <-d.wake:
+		case <-timer.C:
+		}
+
+		// полная пачка - скорее всего, в очереди есть еще
+		for ctx.Err() == nil {
+			if d.DeliverDue(ctx) < batchSize {
+				break
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(d.pollInterval)
+	}
+}
+
+// удалить из журнала завершенные доставки старше срока хранения и сверх лимита на подписку.
+func (d *Dispatcher) Prune() int {
+	pruned, err := d.store.PruneWebhookDeliveries(d.now().Add(-d.retention), d.keepPerHook)
+	if err != nil {
+		logger.Log.Error("failed to prune webhook deliveries", zap.Error(err))
+	}
+	return pruned
+}
+
+// отправить доставки, срок которых подошел. Возвращает, сколько их было.
+func (d *Dispatcher) DeliverDue(ctx context.Context) int {
+	// пока доставка в работе, ее не возьмет другой экземпляр
+	lease := 2*d.client.Timeout + time.Minute
+	deliveries, err := d.store.ClaimWebhookDeliveries(d.now(), lease, batchSize)
+	if err != nil {
+		logger.Log.Error("failed to claim webhook deliveries", zap.Error(err))
+		return 0
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(delivery storage.WebhookDelivery) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			d.deliver(ctx, delivery)
+		}(delivery)
+	}
+	wg.Wait()
+
+	return len(deliveries)
+}
+
+// одна попытка доставки.
+func (d *Dispatcher) deliver(ctx context.Context, delivery storage.WebhookDelivery) {
+	webhook, err := d.store.GetWebhook(delivery.WebhookID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		delivery.Status = storage.DeliveryFailed
+		delivery.Error = "webhook was deleted"
+		d.save(delivery)
+		return
+	case err != nil:
+		// хранилище недоступно: доставка вернется в очередь после аренды
+		logger.Log.Error("failed to get webhook", zap.String("webhook_id", delivery.WebhookID), zap.Error(err))
+		return
+	}
+
+	code, err := d.send(ctx, webhook, delivery)
+	if ctx.Err() != nil {
+		// остановка сервиса - не попытка, доставка уйдет после перезапуска
+		return
+	}
+	now := d.now()
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.UpdatedAt = now
+
+	if err == nil {
+		delivery.Status = storage.DeliveryDelivered
+		delivery.Error = ""
+		d.save(delivery)
+		return
+	}
+
+	delivery.Error = errorClass(err)
+	if delivery.Attempts >= d.maxAttempts {
+		delivery.Status = storage.DeliveryFailed
+		logger.Log.Info("webhook delivery failed", zap.String("delivery_id", delivery.ID), zap.Error(err))
+	} else {
+		delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	}
+	d.save(delivery)
+}
+
+func (d *Dispatcher) save(delivery storage.WebhookDelivery) {
+	if err := d.store.UpdateWebhookDelivery(delivery); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		logger.Log.Error("failed to save webhook delivery", zap.String("delivery_id", delivery.ID), zap.Error(err))
+	}
+}
+
+// задержка перед попыткой после attempts неудачных: base, 2*base, 4*base... но не больше max.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.baseDelay
+	for i := 1; i < attempts && delay < d.maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.maxDelay)
+}
+
+// отправить подписанное событие. Успех - любой ответ 2xx.
+func (d *Dispatcher) send(ctx context.Context, webhook storage.Webhook, delivery storage.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shortener-webhooks")
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// дочитываем немного, чтобы соединение вернулось в пул
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &statusError{code: resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,345 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buharamanya/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// файловое хранилище во временном каталоге.
+func openStorage(t *testing.T, path string) *storage.InMemoryStorage {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	s := storage.NewInMemoryStorage(file)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// получатель, который проверяет подпись и отвечает кодами из statuses по очереди.
+type receiver struct {
+	*httptest.Server
+	calls  atomic.Int32
+	events chan Event
+}
+
+func newReceiver(t *testing.T, secret string, statuses ...int) *receiver {
+	rc := &receiver{events: make(chan Event, 10)}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(rc.calls.Add(1))
+
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if !Verify(secret, ts, body, r.Header.Get(HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		status := http.StatusOK
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		if status == http.StatusOK {
+			var ev Event
+			if err := json.Unmarshal(body, &ev); err == nil && ev.Type == r.Header.Get(HeaderEvent) {
+				rc.events <- ev
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func TestDispatcher_DeliversSignedEvents(t *testing.T) {
+	s := openStorage(t, filepath.Join(t.TempDir(), "storage.json"))
+	rc := newReceiver(t, "s3cret")
+
+	require.NoError(t, s.SaveWebhook(storage.Webhook{
+		ID: "wh-1", UserID: "user-1", URL: rc.URL, Secret: "s3cret",
+		Events: []string{EventLinkCreated, EventLinkDeleted},
+	}))
+
+	d := NewDispatcher(s, WithInternalTargets())
+	d.Publish("user-1", EventLinkCreated, LinkData{ShortCode: "abc123", OriginalURL: "https://example.com"})
+	// на переходы не подписан
+	d.Publish("user-1", EventLinkClicked, LinkData{ShortCode: "abc123"})
+	// чужая ссылка
+	d.Publish("user-2", EventLinkCreated, LinkData{ShortCode: "zzz999"})
+
+	assert.Equal(t, 1, d.DeliverDue(context.Background()))
+
+	ev := <-rc.events
+	assert.Equal(t, EventLinkCreated, ev.Type)
+	assert.Equal(t, "abc123", ev.Data.ShortCode)
+	assert.Equal(t, "https://example.com", ev.Data.OriginalURL)
+	assert.Equal(t, int32(1), rc.calls.Load())
+
+	log, err := s.GetWebhookDeliveries("wh-1", 10)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	assert.Equal(t, storage.DeliveryDelivered, log[0].Status)
+	assert.Equal(t, 1, log[0].Attempts)
+	assert.Equal(t, http.StatusOK, log[0].ResponseCode)
+	assert.Equal(t, ev.ID, log[0].EventID)
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	s := openStorage(t, filepath.Join(t.TempDir(), "storage.json"))
+	rc := newReceiver(t, "s3cret", http.StatusInternalServerError, http.StatusBadGateway)
+
+	require.NoError(t, s.SaveWebhook(storage.Webhook{
+		ID: "wh-1", UserID: "user-1", URL: rc.URL, Secret: "s3cret", Events: Events,
+	}))
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDispatcher(s, WithInternalTargets(), WithRetry(5, time.Second, time.Minute))
+	d.now = func() time.Time { return now }
+
+	d.Publish("user-1", EventLinkClicked, LinkData{ShortCode: "abc123", Variant: "b"})
+
+	step := func(advance time.Duration) storage.WebhookDelivery {
+		now = now.Add(advance)
+		d.DeliverDue(context.Background())
+		log, err := s.GetWebhookDeliveries("wh-1", 10)
+		require.NoError(t, err)
+		require.Len(t, log, 1)
+		return log[0]
+	}
+
+	got := step(0)
+	assert.Equal(t, storage.DeliveryPending, got.Status)
+	assert.Equal(t, 1, got.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, got.ResponseCode)
+	assert.Equal(t, now.Add(time.Second), got.NextAttemptAt)
+
+	// срок еще не подошел
+	step(500 * time.Millisecond)
+	assert.Equal(t, int32(1), rc.calls.Load())
+
+	got = step(500 * time.Millisecond)
+	assert.Equal(t, 2, got.Attempts)
+	assert.Equal(t, now.Add(2*time.Second), got.NextAttemptAt)
+
+	got = step(2 * time.Second)
+	assert.Equal(t, storage.DeliveryDelivered, got.Status)
+	assert.Equal(t, 3, got.Attempts)
+	assert.Empty(t, got.Error)
+	assert.Equal(t, "b", (<-rc.events).Data.Variant)
+}
+
+func TestDispatcher_GivesUp(t *testing.T) {
+	s := openStorage(t, filepath.Join(t.TempDir(), "storage.json"))
+	rc := newReceiver(t, "s3cret", http.StatusInternalServerError, http.StatusInternalServerError)
+
+	require.NoError(t, s.SaveWebhook(storage.Webhook{
+		ID: "wh-1", UserID: "user-1", URL: rc.URL, Secret: "s3cret", Events: Events,
+	}))
+
+	now := time.Now()
+	d := NewDispatcher(s, WithInternalTargets(), WithRetry(2, time.Second, time.Minute))
+	d.now = func() time.Time { return now }
+	d.Publish("user-1", EventLinkDeleted, LinkData{ShortCode: "abc123"})
+
+	d.DeliverDue(context.Background())
+	now = now.Add(time.Second)
+	d.DeliverDue(context.Background())
+	now = now.Add(time.Hour)
+	assert.Zero(t, d.DeliverDue(context.Background()))
+
+	log, err := s.GetWebhookDeliveries("wh-1", 10)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	assert.Equal(t, storage.DeliveryFailed, log[0].Status)
+	assert.Equal(t, 2, log[0].Attempts)
+	assert.Contains(t, log[0].Error, "500")
+}
+
+func TestDispatcher_Prune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	s := openStorage(t, path)
+	rc := newReceiver(t, "s3cret", http.StatusOK, http.StatusOK, http.StatusOK, http.StatusInternalServerError)
+
+	require.NoError(t, s.SaveWebhook(storage.Webhook{
+		ID: "wh-1", UserID: "user-1", URL: rc.URL, Secret: "s3cret", Events: Events,
+	}))
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDispatcher(s, WithInternalTargets(), WithRetention(24*time.Hour, 2))
+	d.now = func() time.Time { return now }
+
+	// три доставленных события и одно, ждущее повтора
+	for i := 0; i < 4; i++ {
+		now = now.Add(time.Minute)
+		d.Publish("user-1", EventLinkCreated, LinkData{ShortCode: "abc12" + strconv.Itoa(i)})
+		d.DeliverDue(context.Background())
+	}
+
+	// сверх лимита на подписку удаляется самая старая завершенная
+	assert.Equal(t, 1, d.Prune())
+
+	// по сроку уходят остальные завершенные, ожидающая остается
+	now = now.Add(48 * time.Hour)
+	assert.Equal(t, 2, d.Prune())
+	assert.Zero(t, d.Prune())
+
+	// файл переписан: после перезапуска удаленных доставок нет
+	require.NoError(t, s.Close())
+	log, err := openStorage(t, path).GetWebhookDeliveries("wh-1", 10)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	assert.Equal(t, storage.DeliveryPending, log[0].Status)
+}
+
+func TestDispatcher_InternalTargets(t *testing.T) {
+	rc := newReceiver(t, "s3cret")
+	// внутренний адрес за редиректом с публичного тоже не должен доставаться
+	redirector := httptest.NewServer(http.RedirectHandler(rc.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirector.Close)
+
+	tests := []struct {
+		name      string
+		url       string
+		opts      []Option
+		wantCode  int
+		wantError string
+	}{
+		{"Loopback_refused", rc.URL, nil, 0, "target address is not allowed"},
+		{"Closed_port_hides_details", "http://127.0.0.1:1/", []Option{WithInternalTargets()}, 0, "connection failed"},
+		{"Redirect_not_followed", redirector.URL, []Option{WithInternalTargets()}, http.StatusTemporaryRedirect, "unexpected response status 307"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openStorage(t, filepath.Join(t.TempDir(), "storage.json"))
+			require.NoError(t, s.SaveWebhook(storage.Webhook{
+				ID: "wh-1", UserID: "user-1", URL: tt.url, Secret: "s3cret", Events: Events,
+			}))
+
+			d := NewDispatcher(s, append(tt.opts, WithRetry(1, time.Second, time.Minute))...)
+			d.Publish("user-1", EventLinkCreated, LinkData{ShortCode: "abc123"})
+			d.DeliverDue(context.Background())
+
+			log, err := s.GetWebhookDeliveries("wh-1", 10)
+			require.NoError(t, err)
+			require.Len(t, log, 1)
+			assert.Equal(t, storage.DeliveryFailed, log[0].Status)
+			assert.Equal(t, tt.wantCode, log[0].ResponseCode)
+			assert.Equal(t, tt.wantError, log[0].Error)
+			assert.Zero(t, rc.calls.Load())
+		})
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	tests := []struct {
+		url           string
+		allowInternal bool
+		wantErr       bool
+	}{
+		{"https://crm.example.com/hooks", false, false},
+		{"ftp://crm.example.com/hooks", false, true},
+		{"/relative", false, true},
+		{"http://localhost:9000/in", false, true},
+		{"http://127.0.0.1/in", false, true},
+		{"http://10.1.2.3/in", false, true},
+		{"http://169.254.169.254/latest/meta-data", false, true},
+		{"http://[::1]/in", false, true},
+		{"http://[::ffff:192.168.0.1]/in", false, true},
+		{"http://0.0.0.0/in", false, true},
+		{"http://localhost:9000/in", true, false},
+	}
+	for _, tt := range tests {
+		_, err := CheckTarget(tt.url, tt.allowInternal)
+		assert.Equal(t, tt.wantErr, err != nil, "%s (allowInternal=%v): %v", tt.url, tt.allowInternal, err)
+	}
+}
+
+func TestDispatcher_OutboxSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	rc := newReceiver(t, "s3cret")
+
+	s := openStorage(t, path)
+	require.NoError(t, s.SaveWebhook(storage.Webhook{
+		ID: "wh-1", UserID: "user-1", URL: rc.URL, Secret: "s3cret", Events: Events,
+	}))
+	// событие попало в outbox, но до отправки дело не дошло
+	NewDispatcher(s).Publish("user-1", EventLinkCreated, LinkData{ShortCode: "abc123"})
+	require.NoError(t, s.Close())
+
+	restarted := openStorage(t, path)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewDispatcher(restarted, WithInternalTargets()).Run(ctx)
+
+	select {
+	case ev := <-rc.events:
+		assert.Equal(t, "abc123", ev.Data.ShortCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered after restart")
+	}
+}
+
+func TestDispatcher_RunWakesOnPublish(t *testing.T) {
+	s := openStorage(t, filepath.Join(t.TempDir(), "storage.json"))
+	rc := newReceiver(t, "s3cret")
+	require.NoError(t, s.SaveWebhook(storage.Webhook{
+		ID: "wh-1", UserID: "user-1", URL: rc.URL, Secret: "s3cret", Events: Events,
+	}))
+
+	// опрос редкий, так что доставка идет только по сигналу Publish
+	d := NewDispatcher(s, WithInternalTargets(), WithPollInterval(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	d.Publish("user-1", EventLinkClicked, LinkData{ShortCode: "abc123"})
+
+	select {
+	case ev := <-rc.events:
+		assert.Equal(t, EventLinkClicked, ev.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	d := NewDispatcher(nil, WithRetry(10, 10*time.Second, time.Minute))
+
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{9, time.Minute},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, d.backoff(tt.attempts), "attempts %d", tt.attempts)
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	sig := Sign("s3cret", 1700000000, body)
+
+	assert.True(t, Verify("s3cret", 1700000000, body, sig))
+	assert.False(t, Verify("other", 1700000000, body, sig))
+	assert.False(t, Verify("s3cret", 1700000001, body, sig))
+	assert.False(t, Verify("s3cret", 1700000000, []byte(`{"id":"2"}`), sig))
+}
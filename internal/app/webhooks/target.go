@@ -0,0 +1,123 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenTarget - адрес подписчика во внутренней сети.
+var ErrForbiddenTarget = errors.New("webhook target address is not allowed")
+
+// диапазоны вне стандартных проверок netip: "эта сеть" и CGNAT.
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// внутренний ли адрес: loopback, частные сети, link-local, unspecified и multicast.
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, p := range internalPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// хук net.Dialer: проверяет адрес уже после резолва, поэтому подмена
+// DNS-записи между проверкой подписки и доставкой ничего не дает.
+func denyInternal(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenTarget, address)
+	}
+	if internalAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrForbiddenTarget, addrPort.Addr())
+	}
+	return nil
+}
+
+// http клиент для доставок. Без allowInternal соединения с внутренними адресами
+// запрещены. Редиректы не выполняются: ответ 3xx считается ответом подписчика.
+func NewHTTPClient(timeout time.Duration, allowInternal bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowInternal {
+		dialer.Control = denyInternal
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// через прокси проверка адреса теряет смысл
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// CheckTarget разбирает URL подписки: абсолютный http или https. Без allowInternal
+// заранее отклоняются localhost и литералы внутренних адресов; имена, которые
+// резолвятся во внутреннюю сеть, отсекаются уже при доставке.
+func CheckTarget(rawURL string, allowInternal bool) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, errors.New("webhook url must be an absolute http or https URL")
+	}
+	if allowInternal {
+		return u, nil
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return nil, ErrForbiddenTarget
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && internalAddr(ip) {
+		return nil, ErrForbiddenTarget
+	}
+	return u, nil
+}
+
+// класс ошибки доставки для журнала. Текст сетевых ошибок туда не попадает,
+// чтобы журнал нельзя было использовать для разведки сети.
+func errorClass(err error) string {
+	var statusErr *statusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr):
+		return statusErr.Error()
+	case errors.Is(err, ErrForbiddenTarget):
+		return "target address is not allowed"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "connection failed"
+	}
+}
+
+// подписчик ответил не 2xx.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected response status %d", e.code)
+}
@@ -0,0 +1,67 @@
+// Package webhooks рассылает события о ссылках подписчикам через outbox в хранилище.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// типы событий.
+const (
+	EventLinkCreated = "link.created"
+	EventLinkDeleted = "link.deleted"
+	EventLinkClicked = "link.clicked"
+)
+
+// все типы событий, на которые можно подписаться.
+var Events = []string{EventLinkCreated, EventLinkDeleted, EventLinkClicked}
+
+// известный ли тип события.
+func ValidEvent(eventType string) bool {
+	return slices.Contains(Events, eventType)
+}
+
+// заголовки запроса с событием.
+const (
+	HeaderEventID   = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// тело запроса с событием.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      LinkData  `json:"data"`
+}
+
+// ссылка, с которой произошло событие.
+type LinkData struct {
+	ShortCode   string `json:"short_code"`
+	ShortURL    string `json:"short_url,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
+	TeamID      string `json:"team_id,omitempty"`
+	// вариант из правил перенаправления, только для переходов
+	Variant string `json:"variant,omitempty"`
+}
+
+// подпись тела: sha256=hex(HMAC-SHA256(secret, "<timestamp>.<body>")).
+// Метка времени в подписи не дает переиграть старый запрос.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// проверить подпись на стороне получателя.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}